@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Activated     *bool
+		Email         string
+		CreatedAfter  time.Time
+		CreatedBefore time.Time
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Activated = app.readBool(qs, "activated", v)
+	input.Email = app.readString(qs, "email", "")
+	input.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.CreatedBefore = app.readTime(qs, "created_before", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = data.UserSortSafelist
+	vw := app.readView(qs, data.User{}, nil, v)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	users, metadata, err := app.models.Users.GetAll(input.Activated, input.Email, input.CreatedAfter, input.CreatedBefore, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	list, err := renderList(vw, users, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"users": list, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Read the fields that the client wants in the response.
+	v := validator.New()
+	vw := app.readView(r.URL.Query(), data.User{}, nil, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	item, err := vw.render(user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input struct {
+		Activated          *bool `json:"activated"`
+		ForcePasswordReset bool  `json:"force_password_reset"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if input.Activated != nil && !*input.Activated {
+		v.Check(user.ID != app.contextGetUser(r).ID, "activated", "you cannot deactivate your own account")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	details := map[string]any{}
+	if input.Activated != nil {
+		details["activated"] = *input.Activated
+		user.Activated = *input.Activated
+	}
+	// Forcing a password reset replaces the password with a random one, so the old
+	// password stops working immediately. The user is then emailed a token which
+	// lets them choose a new password.
+	if input.ForcePasswordReset {
+		details["force_password_reset"] = true
+		err = user.Password.SetRandom()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	// Deactivating an account or resetting its password logs the user out everywhere,
+	// including any half-finished two-factor logins. A forced password reset also
+	// revokes the user's API keys, as they may have leaked along with the password. The
+	// update, the revocations and the audit log entry are written in one transaction.
+	var scopes []string
+	if input.ForcePasswordReset || !user.Activated {
+		scopes = data.SessionScopes
+	}
+	entry := app.auditEntry(r, "user.update", user.ID, details)
+	err = app.models.Users.UpdateWithAudit(user, entry, scopes, input.ForcePasswordReset)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if input.ForcePasswordReset {
+		token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+			err := app.mailer.Load().Send(user.Email, "password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if id == app.contextGetUser(r).ID {
+		v := validator.New()
+		v.AddError("id", "you cannot delete your own account here, use DELETE /v1/users/me instead")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// The deletion and its audit log entry are written in one transaction, and the
+	// entry keeps a snapshot of who the user was.
+	err = app.models.Users.DeleteWithAudit(id, app.auditEntry(r, "user.delete", id, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The impersonateUserHandler() issues an administrator a short-lived token which
+// authenticates requests as another user, so that they can see the API as that user
+// does. The token can't be used on account management endpoints, administrators can't
+// be impersonated, and every impersonation is recorded in the audit log.
+func (app *application) impersonateUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	v := validator.New()
+	if v.Check(id != app.contextGetUser(r).ID, "id", "you cannot impersonate yourself"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v.Check(user.Activated, "id", "you cannot impersonate a deactivated user")
+	v.Check(!permissions.Include("admin:users"), "id", "you cannot impersonate another administrator")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	ttl := time.Hour
+	entry := app.auditEntry(r, "user.impersonate", user.ID, map[string]any{"ttl": ttl.String()})
+	token, err := app.models.Tokens.NewWithAudit(user.ID, ttl, data.ScopeImpersonation, entry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"impersonation_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
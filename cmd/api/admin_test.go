@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestDeleteUser checks that deleting a user records the deletion in the audit log,
+// with a snapshot of who the user was.
+func TestDeleteUser(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+	admin := insertTestUser(t, app, "pa55word1234", "admin:users")
+	user := insertTestUser(t, app, "pa55word1234")
+	auth := []string{"Authorization", "Bearer " + authenticationToken(t, app, admin)}
+
+	path := fmt.Sprintf("/v1/admin/users/%d", user.ID)
+	status, _ := ts.do(t, http.MethodDelete, path, nil, auth...)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d; want %d", status, http.StatusOK)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM audit_log WHERE target_user_id = $1`, user.ID) })
+
+	var actorID int64
+	var details []byte
+	err := db.QueryRow(`SELECT actor_id, details FROM audit_log WHERE action = 'user.delete' AND target_user_id = $1`, user.ID).Scan(&actorID, &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot map[string]any
+	err = json.Unmarshal(details, &snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actorID != admin.ID || snapshot["email"] != user.Email || snapshot["client_ip"] != "127.0.0.1" {
+		t.Errorf("got actor %d and details %v; want actor %d and the user's email", actorID, snapshot, admin.ID)
+	}
+
+	// Deleting the user again finds nothing, and records nothing more.
+	status, _ = ts.do(t, http.MethodDelete, path, nil, auth...)
+	if status != http.StatusNotFound {
+		t.Errorf("got status %d deleting again; want %d", status, http.StatusNotFound)
+	}
+	var entries int
+	err = db.QueryRow(`SELECT count(*) FROM audit_log WHERE target_user_id = $1`, user.ID).Scan(&entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 {
+		t.Errorf("got %d audit log entries; want 1", entries)
+	}
+}
+
+// TestForcePasswordReset checks that forcing a password reset logs the user out
+// everywhere and revokes their API keys, in the same transaction as the audit log
+// entry recording it.
+func TestForcePasswordReset(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+	admin := insertTestUser(t, app, "pa55word1234", "admin:users")
+	user := insertTestUser(t, app, "pa55word1234", "movies:read")
+	auth := []string{"Authorization", "Bearer " + authenticationToken(t, app, admin)}
+	t.Cleanup(func() { db.Exec(`DELETE FROM audit_log WHERE target_user_id = $1`, user.ID) })
+
+	for _, scope := range data.SessionScopes {
+		_, err := app.models.Tokens.New(user.ID, time.Hour, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := app.models.APIKeys.New(&data.APIKey{UserID: user.ID, Name: "ci", Permissions: data.Permissions{"movies:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/v1/admin/users/%d", user.ID)
+	status, _ := ts.do(t, http.MethodPatch, path, map[string]any{"force_password_reset": true}, auth...)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d; want %d", status, http.StatusOK)
+	}
+	var sessions, keys, resets, entries int
+	err = db.QueryRow(`
+        SELECT
+            (SELECT count(*) FROM tokens WHERE user_id = $1 AND scope = ANY($2)),
+            (SELECT count(*) FROM api_keys WHERE user_id = $1),
+            (SELECT count(*) FROM tokens WHERE user_id = $1 AND scope = $3),
+            (SELECT count(*) FROM audit_log WHERE target_user_id = $1 AND action = 'user.update')`,
+		user.ID, pq.Array(data.SessionScopes), data.ScopePasswordReset).Scan(&sessions, &keys, &resets, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 0 || keys != 0 || resets != 1 || entries != 1 {
+		t.Errorf("got %d session tokens, %d API keys, %d password-reset tokens and %d audit log entries; want 0, 0, 1 and 1", sessions, keys, resets, entries)
+	}
+}
+
+// TestImpersonateUser checks that an impersonation token authenticates as the user,
+// except on account management endpoints, and that administrators can't be
+// impersonated.
+func TestImpersonateUser(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+	admin := insertTestUser(t, app, "pa55word1234", "admin:users")
+	otherAdmin := insertTestUser(t, app, "pa55word1234", "admin:users")
+	user := insertTestUser(t, app, "pa55word1234", "movies:read")
+	auth := []string{"Authorization", "Bearer " + authenticationToken(t, app, admin)}
+	t.Cleanup(func() { db.Exec(`DELETE FROM audit_log WHERE target_user_id = $1`, user.ID) })
+
+	status, body := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/admin/users/%d/impersonation", user.ID), nil, auth...)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", status, http.StatusCreated)
+	}
+	token, _ := body["impersonation_token"].(map[string]any)["token"].(string)
+	impersonated := []string{"Authorization", "Bearer " + token}
+
+	status, body = ts.do(t, http.MethodGet, "/v1/users/me", nil, impersonated...)
+	if status != http.StatusOK || body["user"].(map[string]any)["email"] != user.Email {
+		t.Errorf("got status %d and body %v for /v1/users/me; want %d and the user", status, body, http.StatusOK)
+	}
+	status, _ = ts.do(t, http.MethodPost, "/v1/users/me/email", map[string]any{"email": testEmail(t)}, impersonated...)
+	if status != http.StatusForbidden {
+		t.Errorf("got status %d changing the email address; want %d", status, http.StatusForbidden)
+	}
+	var entries int
+	err := db.QueryRow(`SELECT count(*) FROM audit_log WHERE target_user_id = $1 AND action = 'user.impersonate' AND actor_id = $2`, user.ID, admin.ID).Scan(&entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 {
+		t.Errorf("got %d audit log entries; want 1", entries)
+	}
+
+	for _, id := range []int64{admin.ID, otherAdmin.ID} {
+		status, _ = ts.do(t, http.MethodPost, fmt.Sprintf("/v1/admin/users/%d/impersonation", id), nil, auth...)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d impersonating user %d; want %d", status, id, http.StatusUnprocessableEntity)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/pkg/client"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// These tests use the Go client in pkg/client against the application's routes, to
+// check that the two agree.
+
+func TestClientRateLimited(t *testing.T) {
+	app := newTestApplication(t, nil, "-limiter-enabled=true", "-limiter-rps=2", "-limiter-burst=1")
+	routes := app.routes()
+	var calls atomic.Int32
+	ts := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		routes.ServeHTTP(w, r)
+	}))
+	c := client.New(ts.URL)
+
+	// The first request uses up the burst, so the second is rate limited, and is
+	// retried after the time in the Retry-After header. Both end up with a 401
+	// response, as the client isn't logged in.
+	_, err := c.GetMovie(context.Background(), 1)
+	if !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("got error %v; want %v", err, client.ErrUnauthorized)
+	}
+	start := time.Now()
+	_, err = c.GetMovie(context.Background(), 1)
+	if !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("got error %v; want %v", err, client.ErrUnauthorized)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s; want at least the 1s in the Retry-After header", elapsed)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("got %d requests; want 3", got)
+	}
+
+	// Without retries, the error says how long to wait.
+	c.MaxRetries = 0
+	_, err = c.GetMovie(context.Background(), 1)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrRateLimited) {
+		t.Fatalf("got error %v; want %v", err, client.ErrRateLimited)
+	}
+	if apiErr.RetryAfter != time.Second {
+		t.Errorf("got RetryAfter %s; want 1s", apiErr.RetryAfter)
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+	ctx := context.Background()
+	user := insertTestUser(t, app, "pa55word1234", "movies:read", "movies:write")
+
+	t.Run("bearer token", func(t *testing.T) {
+		c := client.New(ts.URL)
+		_, err := c.CurrentUser(ctx)
+		if !errors.Is(err, client.ErrUnauthorized) {
+			t.Fatalf("got error %v; want %v", err, client.ErrUnauthorized)
+		}
+		token, err := c.CreateAuthenticationToken(ctx, user.Email, "pa55word1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Token = token.Plaintext
+		me, err := c.CurrentUser(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if me.ID != user.ID {
+			t.Errorf("got user %d; want %d", me.ID, user.ID)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		c := client.New(ts.URL)
+		_, err := c.CreateAuthenticationToken(ctx, user.Email, "wrongpa55word")
+		if !errors.Is(err, client.ErrInvalidCredentials) {
+			t.Fatalf("got error %v; want %v", err, client.ErrInvalidCredentials)
+		}
+		app.models.LoginAttempts.Reset(accountLockoutKey(user.ID))
+		app.models.LoginAttempts.Reset(ipLockoutKey("127.0.0.1"))
+	})
+
+	t.Run("API key", func(t *testing.T) {
+		key := &data.APIKey{UserID: user.ID, Name: "test", Permissions: data.Permissions{"movies:read"}}
+		err := app.models.APIKeys.New(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := client.New(ts.URL)
+		c.APIKey = key.Plaintext
+		me, err := c.CurrentUser(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if me.ID != user.ID {
+			t.Errorf("got user %d; want %d", me.ID, user.ID)
+		}
+		// The key can only do what it has been granted, even though its owner can
+		// do more.
+		_, err = c.CreateMovie(ctx, &client.Movie{Title: "Not Allowed", Year: 2000, Runtime: 100, Genres: []string{"drama"}})
+		if !errors.Is(err, client.ErrForbidden) {
+			t.Errorf("got error %v; want %v", err, client.ErrForbidden)
+		}
+	})
+}
+
+func TestClientMovies(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	routes := app.routes()
+	var listRequests atomic.Int32
+	ts := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v1/movies" {
+			listRequests.Add(1)
+		}
+		routes.ServeHTTP(w, r)
+	}))
+	ctx := context.Background()
+	user := insertTestUser(t, app, "pa55word1234", "movies:read", "movies:write")
+	c := client.New(ts.URL)
+	c.Token = authenticationToken(t, app, user)
+
+	// Give the movies a word in their titles which no other movie has, so that they
+	// can be listed on their own.
+	word := fmt.Sprintf("clienttest%d", time.Now().UnixNano())
+	const total = 5
+	for i := 1; i <= total; i++ {
+		movie, err := c.CreateMovie(ctx, &client.Movie{
+			Title:   fmt.Sprintf("%s %d", word, i),
+			Year:    2000,
+			Runtime: 100,
+			Genres:  []string{"drama"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.DeleteMovie(ctx, movie.ID) })
+	}
+
+	t.Run("iterator", func(t *testing.T) {
+		listRequests.Store(0)
+		it := c.Movies(ctx, client.MovieFilter{Title: word, ListOptions: client.ListOptions{PageSize: 2}})
+		var titles []string
+		for it.Next() {
+			titles = append(titles, it.Value().Title)
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(titles) != total {
+			t.Fatalf("got %d movies %v; want %d", len(titles), titles, total)
+		}
+		for i, title := range titles {
+			if want := fmt.Sprintf("%s %d", word, i+1); title != want {
+				t.Errorf("got movie %d titled %q; want %q", i, title, want)
+			}
+		}
+		// The iterator stops after the last page, which it knows from the metadata.
+		if got := listRequests.Load(); got != 3 {
+			t.Errorf("fetched %d pages; want 3", got)
+		}
+	})
+
+	t.Run("edit conflict", func(t *testing.T) {
+		movies, _, err := c.ListMovies(ctx, client.MovieFilter{Title: word, ListOptions: client.ListOptions{PageSize: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale := *movies[0]
+		movie := *movies[0]
+		movie.Year = 2001
+		updated, err := c.UpdateMovie(ctx, &movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Year != 2001 || updated.Version != stale.Version+1 {
+			t.Errorf("got year %d and version %d; want 2001 and %d", updated.Year, updated.Version, stale.Version+1)
+		}
+		// Updating the copy fetched before the first update fails the patch's version
+		// test.
+		stale.Year = 2002
+		_, err = c.UpdateMovie(ctx, &stale)
+		if !errors.Is(err, client.ErrEditConflict) {
+			t.Fatalf("got error %v; want %v", err, client.ErrEditConflict)
+		}
+	})
+}
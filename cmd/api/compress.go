@@ -0,0 +1,212 @@
+package main
+
+import (
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The compressor interface is satisfied by the gzip, brotli and zstd writers.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// The compressors map holds a pool of writers for each of the content codings that we
+// support. Creating a compressor is relatively expensive (especially for zstd), so we
+// reuse them between responses.
+var compressors = map[string]*sync.Pool{
+	"zstd": {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return &zstdCompressor{enc}
+	}},
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	"gzip": {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// Our order of preference for the content codings, which is used to break ties between
+// codings that the client likes equally.
+var compressionPreference = []string{"zstd", "br", "gzip"}
+
+// The zstd encoder's Reset() method returns an error, unlike the others, so it needs a
+// small adapter.
+type zstdCompressor struct {
+	*zstd.Encoder
+}
+
+func (z *zstdCompressor) Reset(w io.Writer) {
+	z.Encoder.Reset(w)
+}
+
+// The negotiateEncoding() helper picks the content coding for a response from the
+// request's Accept-Encoding header, returning the empty string if the response
+// shouldn't be compressed.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qualities[strings.ToLower(strings.TrimSpace(coding))] = q
+	}
+	for _, coding := range compressionPreference {
+		q, ok := qualities[coding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// The compress() middleware compresses responses with gzip, brotli or zstd, depending
+// on what the client accepts. Responses smaller than the configured minimum size are
+// sent uncompressed, as compressing them isn't worth the overhead.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.compress.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressResponseWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        app.config.compress.minSize,
+			status:         http.StatusOK,
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// The compressResponseWriter type buffers the start of a response until we know whether
+// it's big enough to be worth compressing, and then either compresses the rest of the
+// response or writes it out as it is.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	minSize     int
+	status      int
+	buf         []byte
+	decided     bool
+	compressor  compressor
+	wroteHeader bool
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.wroteHeader || cw.decided {
+		return
+	}
+	cw.status = status
+	// Responses without a body, and informational responses, are never compressed.
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.passthrough()
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+		buffered := cw.buf
+		cw.buf = nil
+		cw.start()
+		_, err := cw.write(buffered)
+		return len(b), err
+	}
+	return cw.write(b)
+}
+
+func (cw *compressResponseWriter) write(b []byte) (int, error) {
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// The start() method decides to compress the response, unless the handler has already
+// set its own Content-Encoding.
+func (cw *compressResponseWriter) start() {
+	if cw.Header().Get("Content-Encoding") != "" {
+		cw.passthrough()
+		return
+	}
+	cw.decided = true
+	cw.Header().Set("Content-Encoding", cw.encoding)
+	cw.Header().Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.wroteHeader = true
+	cw.compressor = compressors[cw.encoding].Get().(compressor)
+	cw.compressor.Reset(cw.ResponseWriter)
+}
+
+// The passthrough() method decides not to compress the response.
+func (cw *compressResponseWriter) passthrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.wroteHeader = true
+}
+
+// Flush sends any buffered data to the client. As the handler wants the data sent now,
+// we stop waiting to see how big the response is and start compressing it.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		buffered := cw.buf
+		cw.buf = nil
+		cw.start()
+		cw.write(buffered)
+	}
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// The close() method finishes the response. If it never reached the minimum size, then
+// it's sent uncompressed.
+func (cw *compressResponseWriter) close() {
+	if !cw.decided {
+		cw.passthrough()
+		cw.ResponseWriter.Write(cw.buf)
+		return
+	}
+	if cw.compressor != nil {
+		cw.compressor.Close()
+		cw.compressor.Reset(io.Discard)
+		compressors[cw.encoding].Put(cw.compressor)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/jsonlog"
+	"greenlight.m4rk1sov.github.com/internal/settings"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Add a db struct field to hold the configuration settings for our database connection
+// pool. For now this only holds the DSN, which we will read in from a command-line flag.
+// config struct to hold all the configuration settings for our application.
+// Add maxOpenConns, maxIdleConns and maxIdleTime fields to hold the configuration
+// settings for the connection pool.
+type config struct {
+	// The config file that the settings were read from (if any), and whether to print
+	// the configuration and exit, rather than starting the server.
+	file        string
+	printConfig bool
+	port        int
+	env         string
+	db          struct {
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  time.Duration
+		// Apply any pending migrations before starting the server.
+		migrateOnStart bool
+	}
+	// Add a new limiter struct containing fields for the requests-per-second and burst
+	// values, and a boolean field which we can use to enable/disable rate limiting
+	// altogether.
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	// Update the config struct to hold the SMTP server settings.
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	// Hold the list of proxy networks whose X-Forwarded-For and Forwarded headers we
+	// trust when working out the real client IP address. If the list is empty, we
+	// always use the address of the immediate peer.
+	proxy struct {
+		trustedCIDRs []*net.IPNet
+	}
+	// Hold the brute-force protection settings for the login endpoint. Failed
+	// attempts are counted separately per user account and per client IP address,
+	// and reaching the relevant limit within the window locks that key out for the
+	// lockout duration. Before that, each further failure doubles the delay that the
+	// client must wait before trying again, starting from the base delay.
+	lockout struct {
+		accountAttempts int
+		ipAttempts      int
+		window          time.Duration
+		duration        time.Duration
+		delay           time.Duration
+	}
+	// Hold the OpenID Connect settings for single sign-on. The login endpoints are
+	// only enabled if an issuer URL is provided.
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+	// Hold the policy settings for new passwords: the minimum strength score (0-4),
+	// whether to reject passwords found in a breached password list, and optionally
+	// the path to a list to use instead of the bundled one.
+	password struct {
+		minScore      int
+		checkBreached bool
+		breachedFile  string
+	}
+	// Hold the response compression settings. Responses smaller than minSize bytes
+	// are sent uncompressed.
+	compress struct {
+		enabled bool
+		minSize int
+	}
+	// Hold how long the responses to requests with an Idempotency-Key header are kept
+	// for replaying.
+	idempotency struct {
+		ttl time.Duration
+	}
+	// Validate request query strings and bodies against the OpenAPI document before
+	// they reach the handlers.
+	openAPI struct {
+		validate bool
+	}
+	// Hold the settings for the background jobs: how often the cleanup jobs run, how
+	// long accounts which are never activated are kept for (0 keeps them forever), and
+	// how long the job history is kept for.
+	jobs struct {
+		enabled            bool
+		interval           time.Duration
+		unactivatedUserTTL time.Duration
+		historyRetention   time.Duration
+	}
+	// Send errors in the old {"error": ...} format, rather than as RFC 7807 problem
+	// details, for clients which haven't been updated yet.
+	legacyErrors bool
+	// The minimum severity level of the log entries to write.
+	logLevel jsonlog.Level
+	// Hold the origins which are allowed to make cross-origin requests to the API.
+	cors struct {
+		trustedOrigins []string
+	}
+}
+
+// The settings which hold secrets, and are redacted when the configuration is printed.
+var secretSettings = []string{"db-dsn", "smtp-password", "oidc-client-secret"}
+
+// The loadConfig() function reads the configuration from, in increasing order of
+// precedence, the defaults below, the YAML or TOML file given by -config (or
+// GREENLIGHT_CONFIG), GREENLIGHT_* environment variables and the command-line flags
+// (see internal/settings), and then validates it. It returns the loader too, so that
+// the caller can print the configuration. Every problem with the configuration is
+// included in the error, rather than just the first.
+func loadConfig(args []string, errorHandling flag.ErrorHandling) (config, *settings.Loader, error) {
+	// Declare an instance of the config struct.
+	var cfg config
+	fs := flag.NewFlagSet("api", errorHandling)
+
+	fs.StringVar(&cfg.file, "config", "", "Config file (.yaml, .yml or .toml)")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the configuration, with secrets redacted, and exit")
+
+	// Read the value of the port and env command-line flags into the config struct. We
+	// default to using the port number 4000 and the environment "development"
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.BoolVar(&cfg.legacyErrors, "legacy-errors", false, "Send errors in the legacy format unless problem details are accepted")
+	fs.Var((*logLevel)(&cfg.logLevel), "log-level", "Minimum log level (info|error|fatal|off)")
+
+	// Read the DSN value from the db-dsn command-line flag into the config struct. The
+	// GREENLIGHT_DB_DSN environment variable is used if the flag isn't given.
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+
+	// Read the connection pool settings from command-line flags into the config struct.
+	// Notice the default values that we're using?
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	fs.BoolVar(&cfg.db.migrateOnStart, "migrate-on-start", false, "Apply pending database migrations before starting the server")
+
+	// Create command line flags to read the setting values into the config struct.
+	// Notice that we use true as the default for the 'enabled' setting?
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+
+	// Read the response compression settings.
+	fs.BoolVar(&cfg.compress.enabled, "compress-enabled", true, "Enable response compression")
+	fs.IntVar(&cfg.compress.minSize, "compress-min-size", 1024, "Minimum response size in bytes to compress")
+
+	// Read the idempotency key settings.
+	fs.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long to keep responses for idempotency keys")
+
+	// Read the OpenAPI request validation setting.
+	fs.BoolVar(&cfg.openAPI.validate, "openapi-validate", false, "Validate requests against the OpenAPI document")
+
+	// Read the background job settings.
+	fs.BoolVar(&cfg.jobs.enabled, "jobs-enabled", true, "Run the background cleanup jobs")
+	fs.DurationVar(&cfg.jobs.interval, "jobs-interval", time.Hour, "How often to run the cleanup jobs")
+	fs.DurationVar(&cfg.jobs.unactivatedUserTTL, "jobs-unactivated-user-ttl", 7*24*time.Hour, "How long to keep accounts which are never activated (0 to keep them)")
+	fs.DurationVar(&cfg.jobs.historyRetention, "jobs-history-retention", 30*24*time.Hour, "How long to keep the job history")
+
+	// Read the SMTP server configuration settings into the config struct, using the
+	// Mailtrap server as the default. There are deliberately no default credentials:
+	// set them in the config file or the GREENLIGHT_SMTP_USERNAME and
+	// GREENLIGHT_SMTP_PASSWORD environment variables.
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@almasmagzumov.mail.ru>", "SMTP sender")
+
+	// Read the login brute-force protection settings.
+	fs.IntVar(&cfg.lockout.accountAttempts, "lockout-account-attempts", 10, "Failed logins before an account is locked")
+	fs.IntVar(&cfg.lockout.ipAttempts, "lockout-ip-attempts", 50, "Failed logins before a client IP is locked")
+	fs.DurationVar(&cfg.lockout.window, "lockout-window", 15*time.Minute, "Window in which failed logins are counted")
+	fs.DurationVar(&cfg.lockout.duration, "lockout-duration", 15*time.Minute, "Lockout duration")
+	fs.DurationVar(&cfg.lockout.delay, "lockout-delay", time.Second, "Base delay between failed logins")
+
+	// Read the OpenID Connect settings into the config struct.
+	fs.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL")
+	fs.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	fs.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	fs.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:4000/v1/oidc/callback", "OpenID Connect redirect URL")
+
+	// Read the password policy settings.
+	fs.IntVar(&cfg.password.minScore, "password-min-score", 2, "Minimum password strength score (0-4)")
+	fs.BoolVar(&cfg.password.checkBreached, "password-check-breached", true, "Reject passwords found in the breached password list")
+	fs.StringVar(&cfg.password.breachedFile, "password-breached-file", "", "Breached password list to use instead of the bundled one")
+
+	// The -trusted-proxies value is a space separated list of CIDR ranges (or single
+	// IP addresses) for the load balancers and reverse proxies sitting in front of the
+	// API. In the config file it can also be a list.
+	fs.Var((*cidrList)(&cfg.proxy.trustedCIDRs), "trusted-proxies", "Trusted proxy CIDRs (space separated)")
+
+	// Likewise, -cors-trusted-origins is a space separated list of the origins, like
+	// https://www.example.com, which may make cross-origin requests.
+	fs.Var((*stringList)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	loader := &settings.Loader{
+		FlagSet:         fs,
+		EnvPrefix:       "GREENLIGHT_",
+		FileFlag:        "config",
+		Secrets:         secretSettings,
+		CommandLineOnly: []string{"print-config"},
+	}
+	err := loader.Load(args)
+	if err != nil {
+		return cfg, loader, err
+	}
+	return cfg, loader, validateConfig(cfg)
+}
+
+// The validateConfig() function checks the settings which the flags' types don't,
+// returning an error which lists the problems by setting name.
+func validateConfig(cfg config) error {
+	v := validator.New()
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleTime >= 0, "db-max-idle-time", "must not be negative")
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	}
+	v.Check(cfg.compress.minSize >= 0, "compress-min-size", "must not be negative")
+	v.Check(cfg.idempotency.ttl > 0, "idempotency-ttl", "must be greater than zero")
+
+	if cfg.jobs.enabled {
+		v.Check(cfg.jobs.interval > 0, "jobs-interval", "must be greater than zero")
+	}
+	v.Check(cfg.jobs.unactivatedUserTTL >= 0, "jobs-unactivated-user-ttl", "must not be negative")
+	v.Check(cfg.jobs.historyRetention > 0, "jobs-history-retention", "must be greater than zero")
+
+	validateSMTPConfig(v, cfg)
+
+	v.Check(cfg.lockout.accountAttempts > 0, "lockout-account-attempts", "must be greater than zero")
+	v.Check(cfg.lockout.ipAttempts > 0, "lockout-ip-attempts", "must be greater than zero")
+	v.Check(cfg.lockout.window > 0, "lockout-window", "must be greater than zero")
+	v.Check(cfg.lockout.duration > 0, "lockout-duration", "must be greater than zero")
+	v.Check(cfg.lockout.delay >= 0, "lockout-delay", "must not be negative")
+
+	if cfg.oidc.issuer != "" {
+		v.Check(cfg.oidc.clientID != "", "oidc-client-id", "must be provided with oidc-issuer")
+		v.Check(cfg.oidc.redirectURL != "", "oidc-redirect-url", "must be provided with oidc-issuer")
+	}
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		v.Check(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "", "cors-trusted-origins", fmt.Sprintf("%q must be a scheme and host, like https://www.example.com", origin))
+	}
+	v.Check(cfg.password.minScore >= 0 && cfg.password.minScore <= 4, "password-min-score", "must be between 0 and 4")
+	if cfg.password.breachedFile != "" {
+		_, err := os.Stat(cfg.password.breachedFile)
+		v.Check(err == nil, "password-breached-file", "must be a readable file")
+	}
+	return configError(v)
+}
+
+// The validateSMTPConfig() function checks the SMTP settings.
+func validateSMTPConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	v.Check(cfg.smtp.sender != "", "smtp-sender", "must be provided")
+	v.Check(cfg.smtp.username == "" || cfg.smtp.password != "", "smtp-password", "must be provided with smtp-username")
+}
+
+// The configError() helper turns the validation errors into a single error, with one
+// line for each problem, or returns nil if there weren't any.
+func configError(v *validator.Validator) error {
+	if v.Valid() {
+		return nil
+	}
+	var problems []string
+	for key, errs := range v.Errors {
+		for _, err := range errs {
+			problems = append(problems, fmt.Sprintf("%s: %s", key, err.Message))
+		}
+	}
+	sort.Strings(problems)
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+// The cidrList type is a flag.Value for a space separated list of CIDR ranges (or
+// single IP addresses).
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	values := make([]string, len(*l))
+	for i, cidr := range *l {
+		values[i] = cidr.String()
+	}
+	return strings.Join(values, " ")
+}
+
+func (l *cidrList) Set(val string) error {
+	cidrs, err := parseTrustedProxies(strings.Fields(val))
+	if err != nil {
+		return err
+	}
+	*l = cidrs
+	return nil
+}
+
+// The stringList type is a flag.Value for a space separated list of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(val string) error {
+	*l = strings.Fields(val)
+	return nil
+}
+
+// The logLevel type is a flag.Value for a jsonlog.Level, written by its name.
+type logLevel jsonlog.Level
+
+func (l *logLevel) String() string {
+	return strings.ToLower(jsonlog.Level(*l).String())
+}
+
+func (l *logLevel) Set(val string) error {
+	level, err := jsonlog.ParseLevel(val)
+	if err != nil {
+		return err
+	}
+	*l = logLevel(level)
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"greenlight.m4rk1sov.github.com/internal/data"
+	"net"
 	"net/http"
 )
 
@@ -14,6 +15,22 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// The clientIPContextKey is used for storing the resolved client IP address in the
+// request context.
+const clientIPContextKey = contextKey("clientIP")
+
+// The requestIDContextKey is used for storing the ID of the request, which is
+// included in error responses and log entries.
+const requestIDContextKey = contextKey("requestID")
+
+// The apiKeyContextKey is used for storing the API key that a request was
+// authenticated with, if any.
+const apiKeyContextKey = contextKey("apiKey")
+
+// The impersonatedContextKey is used for recording that a request was authenticated
+// with an impersonation token.
+const impersonatedContextKey = contextKey("impersonated")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -33,3 +50,64 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetClientIP() method returns a new copy of the request with the resolved
+// client IP address added to the context.
+func (app *application) contextSetClientIP(r *http.Request, ip net.IP) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// The contextGetClientIP() retrieves the resolved client IP address from the request
+// context. Like contextGetUser(), a missing value means the realIP() middleware hasn't
+// been run, which is a programming error and so we panic.
+func (app *application) contextGetClientIP(r *http.Request) net.IP {
+	ip, ok := r.Context().Value(clientIPContextKey).(net.IP)
+	if !ok {
+		panic("missing client IP value in request context")
+	}
+	return ip
+}
+
+// The contextSetRequestID() method returns a new copy of the request with the request
+// ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() retrieves the request ID from the request context, or
+// returns the empty string if the requestID() middleware hasn't run.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// The contextSetAPIKey() method returns a new copy of the request with the API key
+// that was used to authenticate it added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() retrieves the API key from the request context. Unlike the
+// other getters, a missing value is expected (the request was authenticated with a
+// token, or not at all), in which case nil is returned.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// The contextSetImpersonated() method returns a new copy of the request marked as
+// authenticated with an impersonation token.
+func (app *application) contextSetImpersonated(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), impersonatedContextKey, true)
+	return r.WithContext(ctx)
+}
+
+// The contextGetImpersonated() reports whether the request was authenticated with an
+// impersonation token.
+func (app *application) contextGetImpersonated(r *http.Request) bool {
+	impersonated, _ := r.Context().Value(impersonatedContextKey).(bool)
+	return impersonated
+}
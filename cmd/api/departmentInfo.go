@@ -71,7 +71,7 @@ func (app *application) createDepartmentInfoHandler(w http.ResponseWriter, r *ht
 	headers.Set("Location", fmt.Sprintf("/v1/departments/%d", departmentInfo.ID))
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"departments": departmentInfo}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"departments": departmentInfo}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -99,6 +99,14 @@ func (app *application) getDepartmentInfoHandler(w http.ResponseWriter, r *http.
 	//	Version:   1,
 	//}
 
+	// Read the fields (and related resources) that the client wants in the response.
+	v := validator.New()
+	vw := app.readView(r.URL.Query(), data.DepartmentInfo{}, departmentRelations, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
@@ -114,8 +122,23 @@ func (app *application) getDepartmentInfoHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	// If the client asked for it, embed the module which the department belongs to.
+	var related map[string]any
+	if vw.expands("module") {
+		module, err := app.models.Module_info.Get(departmentInfo.Module_Info)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		related = map[string]any{"module": module}
+	}
+	item, err := vw.render(departmentInfo, related)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Encode the struct JSON and send it as the HTTP response
-	err = app.writeJSON(w, http.StatusOK, envelope{"departmentInfo": departmentInfo}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"departmentInfo": item}, nil)
 	if err != nil {
 		// new helper
 		app.serverErrorResponse(w, r, err)
@@ -127,3 +150,7 @@ func (app *application) getDepartmentInfoHandler(w http.ResponseWriter, r *http.
 	// otherwise, interpolate Id in a placeholder response
 	//fmt.Fprintf(w, "show the details of movie %d\n", id)
 }
+
+// The relations which can be embedded in department responses with the expand
+// parameter.
+var departmentRelations = []string{"module"}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The responseEncoder type describes a format that responses can be sent in. If
+// listOnly is true, then the format can only represent a single list of records, and
+// is only offered for responses which contain one.
+type responseEncoder struct {
+	contentType string
+	listOnly    bool
+	encode      func(w io.Writer, data envelope) error
+}
+
+// The responseEncoders registry holds the formats that we can send responses in, in
+// order of preference. JSON comes first, so it's used when the client will accept
+// anything.
+var responseEncoders = []responseEncoder{
+	{contentType: "application/json", encode: encodeJSON},
+	{contentType: "application/xml", encode: encodeXML},
+	{contentType: "application/msgpack", encode: encodeMsgpack},
+	{contentType: "text/csv", listOnly: true, encode: encodeCSV},
+}
+
+// The writeResponse() helper sends a response in the format that best matches the
+// request's Accept header. If we can't produce any of the acceptable formats, then it
+// sends a 406 Not Acceptable response instead.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	w.Header().Add("Vary", "Accept")
+	encoder, ok := negotiateEncoder(r.Header.Get("Accept"), data)
+	if !ok {
+		app.notAcceptableResponse(w, r, availableContentTypes(data))
+		return nil
+	}
+	// Use the plain writeJSON() helper for JSON, as before.
+	if encoder.contentType == "application/json" {
+		return app.writeJSON(w, status, data, headers)
+	}
+	// Encode the whole response before writing anything, so that an encoding error
+	// can still be turned into a 500 response.
+	var buf bytes.Buffer
+	err := encoder.encode(&buf, data)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", encoder.contentType)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// The acceptRange type holds one of the media ranges from an Accept header.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// The parseAccept() helper parses an Accept header into its media ranges, ordered from
+// most to least preferred. Ranges with a q value of 0 are left out.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// The negotiateEncoder() helper picks the encoder for a response from the request's
+// Accept header. A missing Accept header means that anything is acceptable.
+func negotiateEncoder(accept string, data envelope) (*responseEncoder, bool) {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	for _, accepted := range parseAccept(accept) {
+		for i := range responseEncoders {
+			encoder := &responseEncoders[i]
+			if encoder.listOnly && listField(data) == "" {
+				continue
+			}
+			if mediaRangeMatches(accepted.mediaType, encoder.contentType) {
+				return encoder, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func mediaRangeMatches(mediaRange, contentType string) bool {
+	if mediaRange == "*/*" || mediaRange == contentType {
+		return true
+	}
+	prefix, found := strings.CutSuffix(mediaRange, "/*")
+	return found && strings.HasPrefix(contentType, prefix+"/")
+}
+
+// The availableContentTypes() helper returns the formats that a response could be sent
+// in, for the 406 Not Acceptable response.
+func availableContentTypes(data envelope) []string {
+	var types []string
+	for _, encoder := range responseEncoders {
+		if !encoder.listOnly || listField(data) != "" {
+			types = append(types, encoder.contentType)
+		}
+	}
+	return types
+}
+
+// The listField() helper returns the key of the one list of records in an envelope
+// (such as "movies" in {"movies": [...], "metadata": {...}}), or the empty string if
+// there isn't exactly one.
+func listField(data envelope) string {
+	field := ""
+	for key, value := range data {
+		if key == "metadata" {
+			continue
+		}
+		if value == nil {
+			return ""
+		}
+		if reflect.ValueOf(value).Kind() != reflect.Slice || field != "" {
+			return ""
+		}
+		field = key
+	}
+	return field
+}
+
+func encodeJSON(w io.Writer, data envelope) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(data)
+}
+
+// The MessagePack encoder uses the json struct tags, so that fields have the same names
+// (and the same fields are hidden) as in JSON.
+func encodeMsgpack(w io.Writer, data envelope) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+	return enc.Encode(map[string]any(data))
+}
+
+// The XML encoder works from the JSON representation of the data, so that the element
+// names, hidden fields and custom formats (like "102 mins" for a Runtime) all match
+// the JSON responses. The envelope becomes a <response> element, each object member
+// becomes an element with the same name, and each list item becomes an <item> element.
+func encodeXML(w io.Writer, data envelope) error {
+	tree, err := toJSONTree(data)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err = encodeXMLValue(enc, "response", tree)
+	if err != nil {
+		return err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func encodeXMLValue(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case *jsonObject:
+		for _, key := range value.keys {
+			err = encodeXMLValue(enc, key, value.values[key])
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range value {
+			err = encodeXMLValue(enc, "item", item)
+			if err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		err = enc.EncodeToken(xml.CharData(fmt.Sprint(value)))
+		if err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// The xmlName() helper turns a JSON member name into a valid XML element name, by
+// replacing any characters which aren't allowed and making sure that it starts with a
+// letter or underscore (so "2fa_pending_token" becomes "_2fa_pending_token").
+func xmlName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		case i == 0 && unicode.IsDigit(r):
+			b.WriteRune('_')
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// The CSV encoder writes the list of records from the envelope, with a header row made
+// up of the fields of the first record. Like the XML encoder it works from the JSON
+// representation of the data. Scalar values are written as they are, and nested lists
+// and objects (such as a movie's genres) are written as JSON. Pagination metadata isn't
+// included.
+func encodeCSV(w io.Writer, data envelope) error {
+	field := listField(data)
+	tree, err := toJSONTree(data[field])
+	if err != nil {
+		return err
+	}
+	records, _ := tree.([]any)
+	cw := csv.NewWriter(w)
+	var columns []string
+	for i, record := range records {
+		obj, ok := record.(*jsonObject)
+		if !ok {
+			return errors.New("csv: list items must be objects")
+		}
+		if i == 0 {
+			columns = obj.keys
+			err = cw.Write(columns)
+			if err != nil {
+				return err
+			}
+		}
+		row := make([]string, len(columns))
+		for j, column := range columns {
+			row[j], err = csvCell(obj.values[column])
+			if err != nil {
+				return err
+			}
+		}
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvCell(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		js, err := json.Marshal(fromJSONTree(value))
+		return string(js), err
+	}
+}
+
+// The jsonObject type holds a decoded JSON object with its members in their original
+// order, which the XML and CSV encoders preserve.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+// The only() method returns a copy of the object with just the given members, in their
+// original order.
+func (obj *jsonObject) only(keys []string) *jsonObject {
+	result := &jsonObject{values: make(map[string]any)}
+	for _, key := range obj.keys {
+		for _, wanted := range keys {
+			if key == wanted {
+				result.set(key, obj.values[key])
+				break
+			}
+		}
+	}
+	return result
+}
+
+// The set() method adds a member to the end of the object, or replaces the value of an
+// existing one.
+func (obj *jsonObject) set(key string, value any) {
+	if _, exists := obj.values[key]; !exists {
+		obj.keys = append(obj.keys, key)
+	}
+	obj.values[key] = value
+}
+
+// MarshalJSON encodes the object with its members in order, so that a jsonObject can
+// be sent in a response like any other value.
+func (obj *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range obj.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		js, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(js)
+		buf.WriteByte(':')
+		js, err = json.Marshal(obj.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(js)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// EncodeMsgpack encodes the object as a MessagePack map with its members in order.
+func (obj *jsonObject) EncodeMsgpack(enc *msgpack.Encoder) error {
+	err := enc.EncodeMapLen(len(obj.keys))
+	if err != nil {
+		return err
+	}
+	for _, key := range obj.keys {
+		err = enc.EncodeString(key)
+		if err != nil {
+			return err
+		}
+		err = enc.Encode(msgpackValue(obj.values[key]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The msgpackValue() helper converts the json.Number values in a JSON tree back into
+// numbers, as otherwise they would be encoded as strings.
+func msgpackValue(value any) any {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case []any:
+		list := make([]any, len(value))
+		for i, item := range value {
+			list[i] = msgpackValue(item)
+		}
+		return list
+	}
+	return value
+}
+
+// The toJSONTree() helper converts a value to its JSON representation and decodes it
+// again into nil, bool, json.Number, string, []any and *jsonObject values.
+func toJSONTree(value any) (any, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	return decodeJSONTree(dec)
+}
+
+func decodeJSONTree(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		obj := &jsonObject{values: make(map[string]any)}
+		for dec.More() {
+			keyToken, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyToken.(string)
+			value, err := decodeJSONTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.keys = append(obj.keys, key)
+			obj.values[key] = value
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := decodeJSONTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	}
+	return token, nil
+}
+
+// The fromJSONTree() helper converts *jsonObject values back into maps, so that they
+// can be marshaled to JSON again.
+func fromJSONTree(value any) any {
+	switch value := value.(type) {
+	case *jsonObject:
+		m := make(map[string]any, len(value.keys))
+		for _, key := range value.keys {
+			m[key] = fromJSONTree(value.values[key])
+		}
+		return m
+	case []any:
+		list := make([]any, len(value))
+		for i, item := range value {
+			list[i] = fromJSONTree(item)
+		}
+		return list
+	}
+	return value
+}
+
+// The streamsJSONList() helper reports whether JSON is the format that writeResponse()
+// would send a list response in, in which case the handler can stream the list with a
+// jsonListWriter instead of building it up in memory.
+func streamsJSONList(r *http.Request) bool {
+	encoder, ok := negotiateEncoder(r.Header.Get("Accept"), envelope{"list": []any{}})
+	return ok && encoder.contentType == "application/json"
+}
+
+// The streamJSONList() helper sends a list response with a jsonListWriter. The each
+// function is called to write the records under the given field, passing the
+// pagination metadata along with each one, and then returns the metadata again in
+// case there weren't any records. If something goes wrong once part of the response
+// has been sent, then the best that we can do is log the error and abort the
+// response, so that the client doesn't mistake it for a complete one.
+func (app *application) streamJSONList(w http.ResponseWriter, r *http.Request, field string, each func(write func(item any, metadata data.Metadata) error) (data.Metadata, error)) {
+	lw := newJSONListWriter(w, field)
+	metadata, err := each(func(item any, metadata data.Metadata) error {
+		if !lw.Started() {
+			err := lw.Start(envelope{"metadata": metadata})
+			if err != nil {
+				return err
+			}
+		}
+		return lw.WriteItem(item)
+	})
+	if err == nil && !lw.Started() {
+		err = lw.Start(envelope{"metadata": metadata})
+	}
+	if err == nil {
+		err = lw.Finish()
+	}
+	if err != nil {
+		if lw.Started() {
+			app.logError(r, err)
+			panic(http.ErrAbortHandler)
+		}
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The jsonListWriter type streams a JSON response made up of a list of records and
+// other members (such as the pagination metadata), writing each record as soon as it
+// is available. The output is exactly what writeJSON() would send for the same
+// envelope: the members are in sorted order and indented in the same way, so the
+// other members have to be known before the first record is written.
+type jsonListWriter struct {
+	w       http.ResponseWriter
+	field   string
+	started bool
+	empty   bool
+	after   []byte
+}
+
+func newJSONListWriter(w http.ResponseWriter, field string) *jsonListWriter {
+	return &jsonListWriter{w: w, field: field, empty: true}
+}
+
+// Started reports whether any of the response has been written yet. Once it has, an
+// error can no longer be reported to the client, and the handler should abort the
+// response instead.
+func (lw *jsonListWriter) Started() bool {
+	return lw.started
+}
+
+// Start sends the response headers and the members of data which sort before the list,
+// and keeps the rest to be written by Finish. Nothing is written if a member can't be
+// encoded, so that the error can still be sent as a normal error response.
+func (lw *jsonListWriter) Start(data envelope) error {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var before, after bytes.Buffer
+	before.WriteString("{")
+	for _, key := range keys {
+		js, err := json.MarshalIndent(data[key], "\t", "\t")
+		if err != nil {
+			return err
+		}
+		if key < lw.field {
+			fmt.Fprintf(&before, "\n\t%q: %s,", key, js)
+		} else {
+			fmt.Fprintf(&after, ",\n\t%q: %s", key, js)
+		}
+	}
+	fmt.Fprintf(&before, "\n\t%q: [", lw.field)
+	lw.after = after.Bytes()
+
+	lw.started = true
+	lw.w.Header().Add("Vary", "Accept")
+	lw.w.Header().Set("Content-Type", "application/json")
+	lw.w.WriteHeader(http.StatusOK)
+	_, err := lw.w.Write(before.Bytes())
+	return err
+}
+
+// WriteItem writes a record to the list. Start must have been called first.
+func (lw *jsonListWriter) WriteItem(item any) error {
+	js, err := json.MarshalIndent(item, "\t\t", "\t")
+	if err != nil {
+		return err
+	}
+	separator := ","
+	if lw.empty {
+		separator = ""
+	}
+	lw.empty = false
+	_, err = fmt.Fprintf(lw.w, "%s\n\t\t%s", separator, js)
+	return err
+}
+
+// Finish closes the list and writes the members of the response which sort after it.
+func (lw *jsonListWriter) Finish() error {
+	var buf bytes.Buffer
+	if lw.empty {
+		// An empty list is written as [], like json.MarshalIndent() does.
+		buf.WriteString("]")
+	} else {
+		buf.WriteString("\n\t]")
+	}
+	buf.Write(lw.after)
+	buf.WriteString("\n}\n")
+	_, err := lw.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testMovies = []*data.Movie{
+	{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}, Version: 1},
+	{ID: 2, Title: "Black Panther & <friends>", Year: 2018, Runtime: 134, Genres: []string{"action"}, Version: 3},
+	{ID: 3, Title: "Amélie  ", Year: 2001, Genres: []string{"comedy", "romance"}, Version: 1},
+}
+
+// TestStreamJSONListMatchesWriteJSON checks that a streamed list response is byte for
+// byte the same as the response which writeJSON() sends for the same records.
+func TestStreamJSONListMatchesWriteJSON(t *testing.T) {
+	app := newTestApplication(t, nil)
+	tests := []struct {
+		name     string
+		field    string
+		fields   []string
+		movies   []*data.Movie
+		metadata data.Metadata
+	}{
+		{"empty", "movies", nil, []*data.Movie{}, data.Metadata{}},
+		{"one record", "movies", nil, testMovies[:1], data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1}},
+		{"several records", "movies", nil, testMovies, data.Metadata{CurrentPage: 2, PageSize: 3, FirstPage: 1, LastPage: 4, TotalRecords: 11}},
+		{"selected fields", "movies", []string{"title", "id"}, testMovies, data.Metadata{CurrentPage: 1, PageSize: 3, FirstPage: 1, LastPage: 1, TotalRecords: 3}},
+		{"other field name", "module_info", nil, testMovies[1:], data.Metadata{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 1, TotalRecords: 2}},
+		{"list before the metadata", "list", nil, testMovies, data.Metadata{CurrentPage: 1, PageSize: 3, FirstPage: 1, LastPage: 1, TotalRecords: 3}},
+		{"empty list before the metadata", "list", nil, []*data.Movie{}, data.Metadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vw := view{fields: tt.fields}
+			list, err := renderList(vw, tt.movies, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := httptest.NewRecorder()
+			err = app.writeJSON(want, http.StatusOK, envelope{tt.field: list, "metadata": tt.metadata}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+			app.streamJSONList(got, r, tt.field, func(write func(item any, metadata data.Metadata) error) (data.Metadata, error) {
+				for _, movie := range tt.movies {
+					item, err := vw.render(movie, nil)
+					if err != nil {
+						return data.Metadata{}, err
+					}
+					err = write(item, tt.metadata)
+					if err != nil {
+						return data.Metadata{}, err
+					}
+				}
+				return tt.metadata, nil
+			})
+
+			if got.Code != want.Code {
+				t.Errorf("got status %d; want %d", got.Code, want.Code)
+			}
+			if got.Header().Get("Content-Type") != want.Header().Get("Content-Type") {
+				t.Errorf("got Content-Type %q; want %q", got.Header().Get("Content-Type"), want.Header().Get("Content-Type"))
+			}
+			if got.Body.String() != want.Body.String() {
+				t.Errorf("got body:\n%s\nwant body:\n%s", got.Body, want.Body)
+			}
+		})
+	}
+}
+
+// TestStreamJSONListErrors checks that an error before anything has been written is
+// sent as an error response, and that one afterwards aborts the response.
+func TestStreamJSONListErrors(t *testing.T) {
+	app := newTestApplication(t, nil)
+	failure := errors.New("connection reset")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	app.streamJSONList(w, r, "movies", func(write func(item any, metadata data.Metadata) error) (data.Metadata, error) {
+		return data.Metadata{}, failure
+	})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d for an error before the first record; want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("got panic %v for an error after the first record; want %v", recovered, http.ErrAbortHandler)
+		}
+	}()
+	app.streamJSONList(httptest.NewRecorder(), r, "movies", func(write func(item any, metadata data.Metadata) error) (data.Metadata, error) {
+		err := write(testMovies[0], data.Metadata{})
+		if err != nil {
+			return data.Metadata{}, err
+		}
+		return data.Metadata{}, failure
+	})
+}
+
+// TestListMoviesStreamed checks that GET /v1/movies, which streams the movies as they
+// are read from the database, sends the same bytes as writeJSON() would for the page.
+func TestListMoviesStreamed(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	ts := newTestServer(t, app.routes())
+	user := insertTestUser(t, app, "pa55word-for-tests", "movies:read")
+	token := authenticationToken(t, app, user)
+
+	for _, movie := range testMovies {
+		movie := *movie
+		movie.Title += " streamed"
+		err := app.models.Movies.Insert(&movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { app.models.Movies.Delete(movie.ID) })
+	}
+
+	for _, query := range []string{"title=streamed&page_size=2", "title=streamed&page=2&page_size=2", "title=streamed&fields=id,title", "title=nonexistent"} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/movies?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/json")
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		qs := req.URL.Query()
+		vw := view{fields: app.readCSV(qs, "fields", nil)}
+		filters := data.Filters{
+			Page:         app.readInt(qs, "page", 1, nil),
+			PageSize:     app.readInt(qs, "page_size", 20, nil),
+			Sort:         "id",
+			SortSafelist: data.MovieSortSafelist,
+			Fields:       vw.fields,
+		}
+		movies, metadata, err := app.models.Movies.GetAll(qs.Get("title"), []string{}, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := renderList(vw, movies, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := httptest.NewRecorder()
+		err = app.writeJSON(want, http.StatusOK, envelope{"movies": list, "metadata": metadata}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want.Body.String() {
+			t.Errorf("%s: got body:\n%s\nwant body:\n%s", query, got, want.Body)
+		}
+	}
+}
@@ -2,25 +2,89 @@ package main
 
 import (
 	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // generic helper logger for errors
 func (app *application) logError(r *http.Request, err error) {
 	// Use the PrintError() method to log the error message, and include the current
 	// request method and URL as properties in the log entry.
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
+	}
+	// Include the request ID, so that the log entry can be matched up with the
+	// problem details response that the client received.
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		properties["request_id"] = requestID
+	}
+	// Include the resolved client IP address too, if the realIP() middleware has
+	// already run for this request.
+	if ip, ok := r.Context().Value(clientIPContextKey).(net.IP); ok {
+		properties["client_ip"] = ip.String()
+	}
+	app.logger.PrintError(err, properties)
 }
 
-// the errorResponse() method to send JSON-format error with any type on message for versatility
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+// The base URI for the problem types used in RFC 7807 problem details responses.
+const problemTypeBase = "https://greenlight.m4rk1sov.github.com/problems/"
+
+// The problem type describes an error response. Each helper below uses its own problem
+// Type (which is appended to problemTypeBase) with a Title that summarizes that type of
+// problem, along with a Detail explaining this particular occurrence. Any Extensions
+// are added as extra members of the problem object.
+type problem struct {
+	Type       string
+	Title      string
+	Detail     string
+	Extensions envelope
+	// Legacy is the value of the "error" member in the legacy error format. If it is
+	// nil, then the Detail is used instead.
+	Legacy any
+}
+
+// the errorResponse() method to send an error to the client. By default this is an RFC
+// 7807 problem details object with the application/problem+json content type, which
+// includes the request ID so that the problem can be matched up with our logs. If the
+// server is running with -legacy-errors, clients get the old {"error": ...} format
+// instead, unless they explicitly accept application/problem+json.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, p problem) {
+	var env envelope
+	var headers http.Header
+	if app.config.legacyErrors {
+		w.Header().Add("Vary", "Accept")
+	}
+	if app.config.legacyErrors && !strings.Contains(r.Header.Get("Accept"), "application/problem+json") {
+		message := p.Legacy
+		if message == nil {
+			message = p.Detail
+		}
+		env = envelope{"error": message}
+	} else {
+		env = envelope{
+			"type":     problemTypeBase + p.Type,
+			"title":    p.Title,
+			"status":   status,
+			"detail":   p.Detail,
+			"instance": r.URL.Path,
+		}
+		if requestID := app.contextGetRequestID(r); requestID != "" {
+			env["request_id"] = requestID
+		}
+		for key, value := range p.Extensions {
+			env[key] = value
+		}
+		headers = http.Header{"Content-Type": []string{"application/problem+json"}}
+	}
 
 	// response with helper, if error occurs, returns 500 code status
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -31,59 +95,186 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, problem{
+		Type:   "server-error",
+		Title:  "Internal server error",
+		Detail: "the server encountered a problem and could not process your request",
+	})
 }
 
 // the notFoundResponse() method for 404 code
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, problem{
+		Type:   "not-found",
+		Title:  "Resource not found",
+		Detail: "the requested resource could not be found",
+	})
 }
 
 // the methodNotAllowedResponse() method for 405
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this response", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, problem{
+		Type:   "method-not-allowed",
+		Title:  "Method not allowed",
+		Detail: fmt.Sprintf("the %s method is not supported for this response", r.Method),
+	})
 }
 
 // the badRequestResponse() method for 400
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, problem{
+		Type:   "bad-request",
+		Title:  "Malformed request",
+		Detail: err.Error(),
+	})
+}
+
+// The failedValidationResponse() method sends the errors from a Validator, with the
+// problems for each field path listed under "errors". In the legacy format, each field
+// just gets the message for its first problem, as it used to.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors validator.Errors) {
+	legacy := make(map[string]string, len(errors))
+	for key, fieldErrors := range errors {
+		legacy[key] = fieldErrors[0].Message
+	}
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, problem{
+		Type:       "validation-error",
+		Title:      "Your request parameters didn't validate",
+		Detail:     fmt.Sprintf("%d field(s) failed validation", len(errors)),
+		Extensions: envelope{"errors": errors},
+		Legacy:     legacy,
+	})
+}
+
+// 409 conflict error
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusConflict, problem{
+		Type:   "edit-conflict",
+		Title:  "Edit conflict",
+		Detail: "unable to update the record due to an edit conflict, please try again",
+	})
+}
+
+// The notAcceptableResponse() method is used when we can't send the response in any of
+// the formats that the client accepts. The formats that are available are listed in the
+// response.
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, available []string) {
+	app.errorResponse(w, r, http.StatusNotAcceptable, problem{
+		Type:       "not-acceptable",
+		Title:      "Not acceptable",
+		Detail:     "the resource is not available in any of the formats accepted by your Accept header",
+		Extensions: envelope{"available": available},
+	})
+}
+
+// The unsupportedMediaTypeResponse() method is used when the request body isn't in a
+// format that we accept. The formats that are supported are listed in the response.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported []string) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, problem{
+		Type:       "unsupported-media-type",
+		Title:      "Unsupported media type",
+		Detail:     fmt.Sprintf("the %q content type is not supported for this request", r.Header.Get("Content-Type")),
+		Extensions: envelope{"supported": supported},
+	})
+}
+
+// The patchTestFailedResponse() method is used when a test operation in a JSON patch
+// fails, which usually means that the record has changed since the client read it.
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusConflict, problem{
+		Type:   "patch-test-failed",
+		Title:  "Patch test failed",
+		Detail: "a test operation in the patch failed, so the record has not been changed",
+	})
+}
+
+// The idempotencyKeyReusedResponse() method is used when a client sends an
+// Idempotency-Key that it has already used for a different request.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, problem{
+		Type:   "idempotency-key-reused",
+		Title:  "Idempotency key reused",
+		Detail: "the Idempotency-Key header has already been used for a different request",
+	})
+}
+
+// The idempotencyKeyInUseResponse() method is used when the original request with an
+// Idempotency-Key is still being processed.
+func (app *application) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	app.errorResponse(w, r, http.StatusConflict, problem{
+		Type:   "idempotency-key-in-use",
+		Title:  "Idempotency key in use",
+		Detail: "a request with this Idempotency-Key header is still being processed, please try again later",
+	})
 }
 
-// Note that the errors parameter here has the type map[string]string, which is exactly
-// the same as the errors map contained in our Validator type.
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// The Retry-After header tells the client how many seconds to wait before it can make
+// another request, rounded up so that it's never zero.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.errorResponse(w, r, http.StatusTooManyRequests, problem{
+		Type:   "rate-limit-exceeded",
+		Title:  "Rate limit exceeded",
+		Detail: "rate limit exceeded",
+	})
 }
 
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+// The tooManyLoginAttemptsResponse() method is used when a client IP address or user
+// account has failed to log in too many times. The Retry-After header tells the client
+// how many seconds to wait before trying again.
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.errorResponse(w, r, http.StatusTooManyRequests, problem{
+		Type:       "too-many-login-attempts",
+		Title:      "Too many failed login attempts",
+		Detail:     "too many failed login attempts, please try again later",
+		Extensions: envelope{"retry_after": seconds},
+	})
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, problem{
+		Type:   "invalid-credentials",
+		Title:  "Invalid credentials",
+		Detail: "invalid authentication credentials",
+	})
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, problem{
+		Type:   "invalid-authentication-token",
+		Title:  "Invalid authentication token",
+		Detail: "invalid or missing authentication token",
+	})
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, problem{
+		Type:   "authentication-required",
+		Title:  "Authentication required",
+		Detail: "you must be authenticated to access this resource",
+	})
 }
+
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, problem{
+		Type:   "inactive-account",
+		Title:  "Inactive account",
+		Detail: "your user account must be activated to access this resource",
+	})
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, problem{
+		Type:   "not-permitted",
+		Title:  "Not permitted",
+		Detail: "your user account doesn't have the necessary permissions to access this resource",
+	})
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// The view type describes how the client wants a resource to be represented in a
+// response: which of its fields to include (all of them, if fields is empty), and which
+// related resources to embed in it.
+type view struct {
+	fields []string
+	expand []string
+}
+
+// The readView() helper reads the fields and expand query string parameters, such as
+// ?fields=id,title and ?expand=module. The fields are checked against the JSON fields
+// of the resource type, and the relations against the ones which can be expanded for
+// the resource. Any problems are recorded in the provided Validator instance.
+func (app *application) readView(qs url.Values, resource any, expandable []string, v *validator.Validator) view {
+	var vw view
+	allowed := jsonFields(reflect.TypeOf(resource))
+	for i, field := range app.readCSV(qs, "fields", nil) {
+		field = strings.TrimSpace(field)
+		v.CheckField(validator.PermittedValue(field, allowed...), validator.Index("fields", i), validator.OneOf(allowed...))
+		vw.fields = append(vw.fields, field)
+	}
+	for i, relation := range app.readCSV(qs, "expand", nil) {
+		relation = strings.TrimSpace(relation)
+		v.CheckField(validator.PermittedValue(relation, expandable...), validator.Index("expand", i), validator.OneOf(expandable...))
+		vw.expand = append(vw.expand, relation)
+	}
+	return vw
+}
+
+// The expands() method reports whether the client asked for a relation to be embedded.
+func (vw view) expands(relation string) bool {
+	return validator.PermittedValue(relation, vw.expand...)
+}
+
+// The render() method returns the representation of a resource for the response, with
+// the related resources in embedded added under their relation names, and without the
+// fields that the client didn't ask for. If there's nothing to change, then the value
+// is returned as it is.
+func (vw view) render(value any, embedded map[string]any) (any, error) {
+	if len(vw.fields) == 0 && len(embedded) == 0 {
+		return value, nil
+	}
+	tree, err := toJSONTree(value)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := tree.(*jsonObject)
+	if !ok {
+		return tree, nil
+	}
+	if len(vw.fields) > 0 {
+		obj = obj.only(vw.fields)
+	}
+	for _, relation := range vw.expand {
+		related, err := toJSONTree(embedded[relation])
+		if err != nil {
+			return nil, err
+		}
+		obj.set(relation, related)
+	}
+	return obj, nil
+}
+
+// The jsonFields() helper returns the names of the fields of a struct type that appear
+// in its JSON representation.
+func jsonFields(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// The renderList() helper renders each item in a list with render(). The embedded
+// function returns the related resources for an item, and may be nil if the resource
+// doesn't have any relations.
+func renderList[T any](vw view, items []T, embedded func(item T) map[string]any) (any, error) {
+	if len(vw.fields) == 0 && len(vw.expand) == 0 {
+		return items, nil
+	}
+	list := make([]any, len(items))
+	for i, item := range items {
+		var related map[string]any
+		if embedded != nil {
+			related = embedded(item)
+		}
+		rendered, err := vw.render(item, related)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = rendered
+	}
+	return list, nil
+}
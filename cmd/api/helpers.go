@@ -5,12 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"greenlight.m4rk1sov.github.com/internal/data"
 	"greenlight.m4rk1sov.github.com/internal/validator"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // retrieve Id convert it to integer and return, otherwise return 0, error
@@ -51,7 +54,11 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	}
 
 	//add the Content-Type: application/json header then status code and JSON response
-	w.Header().Set("Content-Type", "application/json")
+	// (unless the caller has asked for a more specific JSON media type, such as
+	// application/problem+json)
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	w.Write(js)
 
@@ -64,7 +71,13 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	return app.decodeJSON(r.Body, dst)
+}
 
+// The decodeJSON() helper does the work for readJSON(), decoding a single JSON value
+// from body into dst and turning any errors into plain-english messages. It's also
+// used to decode the result of applying a patch.
+func (app *application) decodeJSON(body io.Reader, dst any) error {
 	//// Decode the request body into the target destination.
 	//err := json.NewDecoder(r.Body).Decode(dst)
 
@@ -72,7 +85,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	// before decoding. This means that if the JSON from the client now includes any
 	// field which cannot be mapped to the target destination, the decoder will return
 	// an error instead of just ignoring the field.
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	// Decode the request body to the destination.
@@ -155,12 +168,6 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 }
 
-// 409 conflict error
-func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
-}
-
 // The readString() helper returns a string value from the query string, or the provided
 // default value if no matching key could be found.
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -204,13 +211,49 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// validator instance and return the default value.
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddFieldError(key, validator.Format("integer").WithMessage("must be an integer value"))
 		return defaultValue
 	}
 	// Otherwise, return the converted integer value.
 	return i
 }
 
+// The readBool() helper reads a boolean value from the query string. It returns nil if
+// no matching key could be found, so that callers can tell "not provided" apart from
+// false. If the value isn't a valid boolean, we record an error message in the
+// provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddFieldError(key, validator.Format("boolean").WithMessage("must be a boolean value"))
+		return nil
+	}
+	return &b
+}
+
+// The readTime() helper reads a timestamp from the query string, accepting either a
+// full RFC 3339 timestamp or a plain date (which is taken as midnight UTC). It returns
+// the zero time if no matching key could be found, and records an error message in the
+// provided Validator instance if the value can't be parsed.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+	v.AddFieldError(key, validator.Format("timestamp").WithMessage("must be a RFC 3339 timestamp or a YYYY-MM-DD date"))
+	return time.Time{}
+}
+
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 	// Increment the WaitGroup counter.
@@ -227,3 +270,133 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// The parseTrustedProxies() helper converts a list of CIDR ranges into a slice of
+// *net.IPNet values. Plain IP addresses are also accepted, and are treated as a single
+// host network (a /32 for IPv4 or a /128 for IPv6).
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, value := range values {
+		normalized, ok := data.NormalizeCIDR(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		_, cidr, err := net.ParseCIDR(normalized)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// The isTrustedProxy() helper reports whether an IP address falls within one of the
+// trusted proxy networks from the application config.
+func (app *application) isTrustedProxy(ip net.IP) bool {
+	for _, cidr := range app.config.proxy.trustedCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// The resolveClientIP() helper works out the real IP address of the client. We only
+// look at the forwarding headers when the immediate peer is a trusted proxy, otherwise
+// anyone could spoof their address simply by setting the header themselves. The
+// hops in the header are walked from right to left (i.e. nearest proxy first), and
+// the first address which isn't a trusted proxy is taken to be the client.
+func (app *application) resolveClientIP(r *http.Request) (net.IP, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	peer := net.ParseIP(host)
+	if peer == nil {
+		return nil, fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+	if !app.isTrustedProxy(peer) {
+		return peer, nil
+	}
+	// The standardized Forwarded header takes precedence over the de-facto
+	// X-Forwarded-For header if both are present.
+	var hops []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = parseForwardedFor(forwarded)
+	} else {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+	clientIP := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseForwardedHop(hops[i])
+		// If a hop can't be parsed (for example it is "unknown" or an obfuscated
+		// identifier) then we can't trust anything to the left of it, so we stop and
+		// use the last address that we know about.
+		if ip == nil {
+			break
+		}
+		clientIP = ip
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return clientIP, nil
+}
+
+// The parseForwardedFor() helper extracts the "for" parameter values from one or more
+// RFC 7239 Forwarded header values, in the order that they appear.
+func parseForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				hops = append(hops, strings.Trim(val, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// The parseForwardedHop() helper parses a single hop from a forwarding header, which
+// may be a bare IP address or include a port (with IPv6 addresses in square brackets).
+// It returns nil if the hop doesn't contain a valid IP address.
+func parseForwardedHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
+
+// The audit() helper records an administrative action against a user account in the
+// audit log.
+func (app *application) audit(r *http.Request, action string, targetUserID int64, details map[string]any) error {
+	return app.models.AuditLog.Insert(app.auditEntry(r, action, targetUserID, details))
+}
+
+// The auditEntry() helper returns the audit log entry for an administrative action, for
+// when it has to be recorded in the same transaction as the action itself. The acting
+// user is taken from the request context, and the resolved client IP address is always
+// included in the details.
+func (app *application) auditEntry(r *http.Request, action string, targetUserID int64, details map[string]any) *data.AuditEntry {
+	if details == nil {
+		details = make(map[string]any)
+	}
+	details["client_ip"] = app.contextGetClientIP(r).String()
+	return &data.AuditEntry{
+		ActorID:      app.contextGetUser(r).ID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// The headers which aren't stored with an idempotent response, because they describe
+// the original exchange rather than the response itself, or because the middleware
+// outside of idempotent() sets them again on every response.
+var idempotencyIgnoredHeaders = []string{"Connection", "Content-Encoding", "Content-Length", "Date", "Vary", "X-Request-Id"}
+
+// How many more times to try storing a response if the first attempt fails, and the
+// delay before the first retry, which doubles for each one after it.
+const (
+	idempotencyCompleteRetries = 4
+	idempotencyCompleteBackoff = 250 * time.Millisecond
+)
+
+// The idempotent() middleware makes a POST endpoint safe to retry. If the client sends
+// an Idempotency-Key header, then the response to the first request with that key is
+// stored, and later requests with the same key get the stored response again instead
+// of repeating the action (so retrying POST /v1/movies after a timeout doesn't create
+// a duplicate movie). Reusing a key with a different request is an error, as is
+// sending a request while another with the same key is still being processed (or
+// whose response couldn't be stored).
+//
+// Keys are scoped to the authenticated user (or the client IP address for anonymous
+// requests), so the middleware must run after authenticate().
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must contain between 1 and 255 printable ASCII characters"))
+			return
+		}
+
+		// Read the request body so that we can hash it, and then replace it so that the
+		// handler can read it as normal. The body is limited to 1MB, like readJSON().
+		maxBytes := 1_048_576
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+				return
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		requestHash := idempotencyRequestHash(r.Method, r.URL.RequestURI(), body)
+
+		scope := app.idempotencyScope(r)
+		reserved, record, err := app.models.Idempotency.Reserve(scope, key, requestHash, app.config.idempotency.ttl)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !reserved {
+			switch {
+			case !bytes.Equal(record.RequestHash, requestHash):
+				app.idempotencyKeyReusedResponse(w, r)
+			case !record.Completed:
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				// Replay the stored response, marking it so that the client can tell.
+				for name, values := range record.Headers {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.Status)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		// We have the key, so handle the request while recording the response. If the
+		// handler fails with a server error (or panics), then release the key instead,
+		// so that the client can retry the request.
+		release := true
+		defer func() {
+			if release {
+				err := app.models.Idempotency.Release(scope, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 || rec.status >= 500 {
+			return
+		}
+		// The action has been carried out, so the key must not be released even if the
+		// response can't be stored, or a retry would repeat the action. Instead it stays
+		// reserved, and retries are told that it's in use, until it expires.
+		release = false
+		app.completeIdempotencyKey(r, scope, key, rec)
+	}
+}
+
+// The completeIdempotencyKey() helper stores the response for an idempotency key. If
+// that fails, it keeps trying in the background for a few seconds, so that a brief
+// database problem doesn't leave the key reserved without a response.
+func (app *application) completeIdempotencyKey(r *http.Request, scope, key string, rec *idempotencyRecorder) {
+	status, headers, body := rec.status, rec.headers, rec.body.Bytes()
+	err := app.models.Idempotency.Complete(scope, key, status, headers, body)
+	if err == nil {
+		return
+	}
+	app.logError(r, err)
+	app.background(func() {
+		backoff := idempotencyCompleteBackoff
+		for i := 0; i < idempotencyCompleteRetries; i++ {
+			time.Sleep(backoff)
+			backoff *= 2
+			err := app.models.Idempotency.Complete(scope, key, status, headers, body)
+			if err == nil {
+				return
+			}
+			app.logError(r, err)
+		}
+	})
+}
+
+// The idempotencyScope() helper returns the scope for a request's idempotency key.
+func (app *application) idempotencyScope(r *http.Request) string {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return "ip:" + app.contextGetClientIP(r).String()
+	}
+	return fmt.Sprintf("user:%d", user.ID)
+}
+
+// The idempotencyRequestHash() function returns the hash which identifies a request,
+// so that an idempotency key can't be reused for a different one.
+func idempotencyRequestHash(method, uri string, body []byte) []byte {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", method, uri)
+	hash.Write(body)
+	return hash.Sum(nil)
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > 255 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// The idempotencyRecorder type passes a response through to the client while keeping
+// a copy of it to store.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status  int
+	headers http.Header
+	body    bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.headers = rec.Header().Clone()
+		for _, name := range idempotencyIgnoredHeaders {
+			rec.headers.Del(name)
+		}
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
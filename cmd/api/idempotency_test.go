@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotentCreateMovie(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+	user := insertTestUser(t, app, "pa55word1234", "movies:read", "movies:write")
+	key := fmt.Sprintf("create-movie-%d", time.Now().UnixNano())
+	headers := []string{"Authorization", "Bearer " + authenticationToken(t, app, user), "Idempotency-Key", key}
+	movie := map[string]any{"title": "Idempotent", "year": 2000, "runtime": "100 mins", "genres": []string{"drama"}}
+
+	status, first := ts.do(t, http.MethodPost, "/v1/movies", movie, headers...)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", status, http.StatusCreated)
+	}
+	id := first["movie"].(map[string]any)["id"]
+	t.Cleanup(func() { db.Exec(`DELETE FROM movies WHERE id = $1`, id) })
+
+	// Retrying the request replays the response, rather than creating another movie.
+	status, second := ts.do(t, http.MethodPost, "/v1/movies", movie, headers...)
+	if status != http.StatusCreated || second["movie"].(map[string]any)["id"] != id {
+		t.Errorf("got status %d and movie %v; want %d and movie %v", status, second["movie"], http.StatusCreated, id)
+	}
+
+	// A key which is reserved without a response, like one whose response couldn't be
+	// stored, is reported as in use rather than released.
+	pending := key + "-pending"
+	scope := fmt.Sprintf("user:%d", user.ID)
+	body, err := json.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := idempotencyRequestHash(http.MethodPost, "/v1/movies", body)
+	reserved, _, err := app.models.Idempotency.Reserve(scope, pending, hash, time.Hour)
+	if err != nil || !reserved {
+		t.Fatalf("got reserved %t and error %v; want the key reserved", reserved, err)
+	}
+	t.Cleanup(func() { app.models.Idempotency.Release(scope, pending) })
+	headers[3] = pending
+	status, _ = ts.do(t, http.MethodPost, "/v1/movies", movie, headers...)
+	if status != http.StatusConflict {
+		t.Errorf("got status %d for a pending key; want %d", status, http.StatusConflict)
+	}
+}
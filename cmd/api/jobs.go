@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/scheduler"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net/http"
+	"time"
+)
+
+// The key for the scheduler's advisory lock. It must be different from the one used by
+// the migrations (see internal/migrate), so that applying migrations doesn't stop the
+// jobs from running, and the same in every instance.
+const schedulerLockKey = 7_433_108_513
+
+// The jobs which only need to run once a day, however often the cleanup jobs run.
+const dailyJobInterval = 24 * time.Hour
+
+// The newScheduler() method returns the scheduler for the background jobs which clean
+// up expired and abandoned records.
+func (app *application) newScheduler(db *sql.DB) *scheduler.Scheduler {
+	interval := app.config.jobs.interval
+	jobs := []scheduler.Job{
+		{Name: "expired_tokens", Interval: interval, Run: app.deleteExpiredTokensJob},
+		{Name: "expired_idempotency_keys", Interval: interval, Run: app.deleteExpiredIdempotencyKeysJob},
+		{Name: "expired_oidc_states", Interval: interval, Run: app.deleteExpiredOIDCStatesJob},
+		{Name: "stale_login_attempts", Interval: interval, Run: app.deleteStaleLoginAttemptsJob},
+		{Name: "job_history", Interval: dailyJobInterval, Run: app.pruneJobHistoryJob},
+	}
+	if app.config.jobs.unactivatedUserTTL > 0 {
+		jobs = append(jobs, scheduler.Job{Name: "unactivated_users", Interval: dailyJobInterval, Run: app.deleteUnactivatedUsersJob})
+	}
+	return scheduler.New(db, app.logger, schedulerLockKey, jobs...)
+}
+
+// The deleteExpiredTokensJob() method deletes the expired tokens of every scope. Any
+// pending email changes go with their tokens.
+func (app *application) deleteExpiredTokensJob() (map[string]any, error) {
+	deleted, err := app.models.Tokens.DeleteExpired()
+	return map[string]any{"deleted": deleted}, err
+}
+
+func (app *application) deleteExpiredIdempotencyKeysJob() (map[string]any, error) {
+	deleted, err := app.models.Idempotency.DeleteExpired()
+	return map[string]any{"deleted": deleted}, err
+}
+
+// The deleteExpiredOIDCStatesJob() method deletes the single sign-on logins which were
+// started but never completed.
+func (app *application) deleteExpiredOIDCStatesJob() (map[string]any, error) {
+	deleted, err := app.models.OIDCStates.DeleteExpired()
+	return map[string]any{"deleted": deleted}, err
+}
+
+// The deleteStaleLoginAttemptsJob() method deletes the failed login counters which have
+// fallen outside of the lockout window.
+func (app *application) deleteStaleLoginAttemptsJob() (map[string]any, error) {
+	deleted, err := app.models.LoginAttempts.DeleteStale(app.config.lockout.window)
+	return map[string]any{"deleted": deleted}, err
+}
+
+func (app *application) pruneJobHistoryJob() (map[string]any, error) {
+	deleted, err := app.models.JobRuns.DeleteOlderThan(time.Now().Add(-app.config.jobs.historyRetention))
+	return map[string]any{"deleted": deleted}, err
+}
+
+// The deleteUnactivatedUsersJob() method deletes the accounts which were never
+// activated within the grace period. Each deletion is recorded in the audit log, like
+// an administrator deleting the account would be, with an actor ID of 0.
+func (app *application) deleteUnactivatedUsersJob() (map[string]any, error) {
+	entry := data.AuditEntry{
+		Action:  "user.delete",
+		Details: map[string]any{"source": "scheduler"},
+	}
+	users, err := app.models.Users.DeleteUnactivated(time.Now().Add(-app.config.jobs.unactivatedUserTTL), entry)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"deleted": len(users)}, nil
+}
+
+// The JobInfo type describes a scheduled job in the response from the
+// GET /v1/admin/jobs endpoint. The next run is only known by the leader. This type and
+// SchedulerInfo are exported only so that they're named like the other schemas in the
+// OpenAPI document.
+type JobInfo struct {
+	Name     string       `json:"name"`
+	Interval string       `json:"interval"`
+	NextRun  *time.Time   `json:"next_run,omitempty"`
+	LastRun  *data.JobRun `json:"last_run,omitempty"`
+}
+
+// The SchedulerInfo type describes the state of this instance's scheduler.
+type SchedulerInfo struct {
+	Enabled  bool   `json:"enabled"`
+	Leader   bool   `json:"leader"`
+	Instance string `json:"instance"`
+}
+
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	latest, err := app.models.JobRuns.GetLatest()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	nextRuns := app.scheduler.NextRuns()
+	jobs := []JobInfo{}
+	for _, job := range app.scheduler.Jobs {
+		info := JobInfo{Name: job.Name, Interval: job.Interval.String(), LastRun: latest[job.Name]}
+		if next, ok := nextRuns[job.Name]; ok && !next.IsZero() {
+			info.NextRun = &next
+		}
+		jobs = append(jobs, info)
+	}
+	status := SchedulerInfo{
+		Enabled:  app.config.jobs.enabled,
+		Leader:   app.scheduler.Leader(),
+		Instance: app.scheduler.Instance,
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"jobs": jobs, "scheduler": status}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listJobRunsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Job    string
+		Status string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Job = app.readString(qs, "job", "")
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-started_at")
+	input.Filters.SortSafelist = data.JobRunSortSafelist
+	if input.Status != "" {
+		v.CheckField(validator.PermittedValue(input.Status, data.JobSucceeded, data.JobFailed), "status", validator.OneOf(data.JobSucceeded, data.JobFailed))
+	}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	runs, metadata, err := app.models.JobRuns.GetAll(input.Job, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"job_runs": runs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The number of failed logins which are allowed before we start making the client
+// wait between attempts.
+const freeLoginAttempts = 3
+
+// The longest that we'll ever make a client wait between failed logins (short of a
+// full lockout).
+const maxLoginDelay = time.Minute
+
+// The accountLockoutKey() and ipLockoutKey() helpers return the keys that failed login
+// attempts are tracked under in the login_attempts table.
+func accountLockoutKey(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// The emailLockoutKey() helper returns the key that failed logins for an email address
+// without an account are tracked under. They're locked out like an account would be,
+// so that the responses don't reveal which email addresses have accounts.
+func emailLockoutKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// The loginDelay() helper returns how long a client must wait after the given number
+// of consecutive failures. The delay doubles with each failure beyond the free
+// attempts, up to maxLoginDelay.
+func (app *application) loginDelay(failures int) time.Duration {
+	if failures < freeLoginAttempts {
+		return 0
+	}
+	delay := app.config.lockout.delay
+	for i := freeLoginAttempts; i < failures && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	return delay
+}
+
+// The loginRetryAfter() helper checks whether a login attempt is allowed for the given
+// key. It returns zero if the attempt can go ahead, or otherwise how long the client
+// must wait before trying again.
+func (app *application) loginRetryAfter(key string) (time.Duration, error) {
+	attempt, err := app.models.LoginAttempts.Get(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+	if attempt.Locked() {
+		return time.Until(attempt.LockedUntil), nil
+	}
+	// Failures from outside the current window don't count.
+	if time.Since(attempt.LastFailure) > app.config.lockout.window {
+		return 0, nil
+	}
+	wait := time.Until(attempt.LastFailure.Add(app.loginDelay(attempt.Failures)))
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// The recordLoginFailure() helper increments the failure counter for a key, and locks
+// the key out if the counter has reached the given limit. It returns true if this
+// failure caused a new lockout, which is when the counter has just reached the limit
+// (while the key is locked, further failures take it past the limit).
+func (app *application) recordLoginFailure(key string, limit int) (bool, error) {
+	attempt, err := app.models.LoginAttempts.RecordFailure(key, app.config.lockout.window, limit, app.config.lockout.duration)
+	if err != nil {
+		return false, err
+	}
+	return attempt.Locked() && attempt.Failures == limit, nil
+}
+
+// The sendLockoutEmail() helper notifies a user in the background that their account
+// has been temporarily locked.
+func (app *application) sendLockoutEmail(user *data.User) {
+	app.background(func() {
+		data := map[string]any{
+			"userID":      user.ID,
+			"lockedUntil": time.Now().Add(app.config.lockout.duration).UTC().Format(time.RFC1123),
+		}
+		err := app.mailer.Load().Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// The unlockUserHandler() lets an administrator clear the failed login counter and any
+// lockout for a user account.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.LoginAttempts.Reset(accountLockoutKey(user.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.audit(r, "user.unlock", user.ID, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "user account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
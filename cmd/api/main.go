@@ -4,54 +4,26 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"github.com/joho/godotenv"
 	"greenlight.m4rk1sov.github.com/internal/data"
 	"greenlight.m4rk1sov.github.com/internal/jsonlog"
 	"greenlight.m4rk1sov.github.com/internal/mailer"
-	"log"
+	"greenlight.m4rk1sov.github.com/internal/oidc"
+	"greenlight.m4rk1sov.github.com/internal/scheduler"
+	"greenlight.m4rk1sov.github.com/internal/settings"
+	"greenlight.m4rk1sov.github.com/internal/validator"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 // application version
 const version = "1.0.0"
 
-// Add a db struct field to hold the configuration settings for our database connection
-// pool. For now this only holds the DSN, which we will read in from a command-line flag.
-// config struct to hold all the configuration settings for our application.
-// Add maxOpenConns, maxIdleConns and maxIdleTime fields to hold the configuration
-// settings for the connection pool.
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
-	// Add a new limiter struct containing fields for the requests-per-second and burst
-	// values, and a boolean field which we can use to enable/disable rate limiting
-	// altogether.
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
-	}
-	// Update the config struct to hold the SMTP server settings.
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
-	}
-}
-
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
 // and middleware
 // Add a models field to hold our new Models struct.
@@ -66,62 +38,26 @@ type config struct {
 // so we don't need to do anything else to initialize it before we can use it.
 type application struct {
 	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	// The latest configuration, which differs from config only in the settings which
+	// can be reloaded with SIGHUP (see reload.go), and the mailer which it uses. Read
+	// them with currentConfig() and app.mailer.Load().
+	reloadable atomic.Pointer[config]
+	mailer     atomic.Pointer[mailer.Mailer]
+	// The loaders for the configuration the server started with and the last one that
+	// was loaded, which reloadConfig() compares the new configuration with.
+	startupSettings  *settings.Loader
+	lastSettings     *settings.Loader
+	logger           *jsonlog.Logger
+	models           data.Models
+	oidc             *oidc.Provider
+	passwordPolicy   *validator.PasswordPolicy
+	openAPI          envelope
+	requestValidator *requestValidator
+	scheduler        *scheduler.Scheduler
+	wg               sync.WaitGroup
 }
 
 func main() {
-	// Declare an instance of the config struct.
-	var cfg config
-
-	// load .env file from given path
-	// we keep it empty it will load .env from current directory
-	envLoadErr := godotenv.Load(".env")
-
-	if envLoadErr != nil {
-		log.Fatalf("Error loading .env file")
-	}
-	// getting env variables
-	dbHost := os.Getenv("GREENLIGHT_DB_DSN")
-
-	// Read the value of the port and env command-line flags into the config struct. We
-	// default to using the port number 4000 and the environment "development"
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-
-	// Use the value of the GREENLIGHT_DB_DSN environment variable as the default value
-	// for our db-dsn command-line flag.
-
-	// Read the DSN value from the db-dsn command-line flag into the config struct. We
-	// default to using our development DSN if no flag is provided.
-	flag.StringVar(&cfg.db.dsn, "db-dsn", dbHost, "PostgreSQL DSN")
-
-	// Read the connection pool settings from command-line flags into the config struct.
-	// Notice the default values that we're using?
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
-
-	// Create command line flags to read the setting values into the config struct.
-	// Notice that we use true as the default for the 'enabled' setting?
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	// Read the SMTP server configuration settings into the config struct, using the
-	// Mailtrap settings as the default values. IMPORTANT: If you're following along,
-	// make sure to replace the default values for smtp-username and smtp-password
-	// with your own Mailtrap credentials.
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "eb444b7fc1bd66", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "397d341a1b10d0", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@almasmagzumov.mail.ru>", "SMTP sender")
-
-	flag.Parse()
-
 	////A new logger which writes messages to the standard out stream, current date and time.
 	//logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 
@@ -129,6 +65,28 @@ func main() {
 	// severity level to the standard out stream.
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// Load a .env file if there is one, so that the GREENLIGHT_* environment variables
+	// can be kept in it during development. It's optional, as in production they're
+	// usually set directly, or the settings are kept in a config file instead.
+	_ = godotenv.Load(".env")
+
+	// Read the configuration from the defaults, the config file, the environment and
+	// the command-line flags. With -print-config, we print it (even if it's invalid,
+	// to help find the problem) instead of starting the server.
+	cfg, loader, err := loadConfig(os.Args[1:], flag.ExitOnError)
+	if cfg.printConfig {
+		if printErr := loader.Print(os.Stdout); printErr != nil {
+			logger.PrintFatal(printErr, nil)
+		}
+	}
+	if err != nil {
+		logger.PrintFatal(fmt.Errorf("invalid configuration:\n%w", err), nil)
+	}
+	if cfg.printConfig {
+		return
+	}
+	logger.SetLevel(cfg.logLevel)
+
 	// Call the openDB() helper function (see below) to create the connection pool,
 	// passing in the config struct. If this returns an error, we log it and exit the
 	// application immediately.
@@ -150,17 +108,60 @@ func main() {
 	// Likewise use the PrintInfo() method to write a message at the INFO level.
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Any arguments after the flags are a subcommand, like "migrate up", which is run
+	// instead of the server.
+	if args := loader.FlagSet.Args(); len(args) > 0 {
+		err = runCommand(db, logger, args)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	// Bring the schema up to date first if we've been asked to.
+	if cfg.db.migrateOnStart {
+		migrator, err := newMigrator(db, logger)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		_, err = migrator.Up()
+		if err != nil {
+			logger.PrintFatal(explainMigrationError(err), nil)
+		}
+	}
+
+	// Build the password policy, loading the breached password list if it's enabled.
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// Declare an instance of the application struct, containing the config struct
 	// Use the data.NewModels() function to initialize a Models struct, passing in the
 	// connection pool as a parameter.
-	// Initialize a new Mailer instance using the settings from the command line
-	// flags, and add it to the application struct.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:          cfg,
+		startupSettings: loader,
+		lastSettings:    loader,
+		logger:          logger,
+		models:          data.NewModels(db),
+		passwordPolicy:  passwordPolicy,
 	}
+	// Store the reloadable copy of the configuration, and initialize a new Mailer
+	// instance using the SMTP settings.
+	app.applyReloadable(cfg)
+
+	// If single sign-on is configured, initialize the OpenID Connect provider. The
+	// provider's metadata and keys are fetched lazily, so this doesn't need the
+	// identity provider to be reachable at startup.
+	if cfg.oidc.issuer != "" {
+		app.oidc = oidc.New(cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret, cfg.oidc.redirectURL)
+	}
+
+	// Set up the scheduler for the background jobs. It's only started by serve() if
+	// the jobs are enabled, but the admin endpoints use it to describe the jobs either
+	// way.
+	app.scheduler = app.newScheduler(db)
 
 	// Call app.serve() to start the server.
 	err = app.serve()
@@ -196,6 +197,25 @@ func main() {
 	//logger.PrintFatal(err, nil)
 }
 
+// The newPasswordPolicy() function returns the password policy described by the config
+// struct.
+func newPasswordPolicy(cfg config) (*validator.PasswordPolicy, error) {
+	policy := &validator.PasswordPolicy{MinScore: cfg.password.minScore}
+	if !cfg.password.checkBreached {
+		return policy, nil
+	}
+	var err error
+	if cfg.password.breachedFile != "" {
+		policy.Breached, err = validator.LoadBreachedPasswordsFile(cfg.password.breachedFile)
+	} else {
+		policy.Breached, err = validator.BundledBreachedPasswords()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
 // The openDB() function returns a sql.DB connection pool.
 func openDB(cfg config) (*sql.DB, error) {
 	// Use sql.Open() to create an empty connection pool, using the DSN from the config
@@ -211,14 +231,9 @@ func openDB(cfg config) (*sql.DB, error) {
 	// Set the maximum number of idle connections in the pool. Again, passing a value
 	// less than or equal to 0 will mean there is no limit.
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-	// Use the time.ParseDuration() function to convert the idle timeout duration string
-	// to a time.Duration type.
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
-	if err != nil {
-		return nil, err
-	}
-	// Set the maximum idle timeout.
-	db.SetConnMaxIdleTime(duration)
+	// Set the maximum idle timeout. It's already been parsed into a time.Duration by
+	// loadConfig().
+	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
 
 	// Create a context with a 5-second timeout deadline.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
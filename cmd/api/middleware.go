@@ -1,18 +1,46 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"golang.org/x/time/rate"
 	"greenlight.m4rk1sov.github.com/internal/data"
 	"greenlight.m4rk1sov.github.com/internal/validator"
-	"net"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 )
 
+// The requestID() middleware gives every request an ID, which is returned in the
+// X-Request-ID response header and included in error responses and log entries. If the
+// client (or a proxy in front of us) has already assigned an ID in the X-Request-ID
+// request header, then we reuse it so that the request can be traced end to end.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(requestID) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			requestID = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		r = app.contextSetRequestID(r, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Only reuse request IDs which are short and contain no unusual characters, as they end
+// up in our responses and logs.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Create a deferred function (which will always be run in the event of a panic
@@ -21,6 +49,12 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 			// Use the builtin recover function to check if there has been a panic or
 			// not.
 			if err := recover(); err != nil {
+				// A handler panics with http.ErrAbortHandler to abort a response
+				// which it has already started sending, so let that one through
+				// to the HTTP server.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 				// If there was a panic, set a "Connection: close" header on the
 				// response. This acts as a trigger to make Go's HTTP server
 				// automatically close the current connection after a response has been
@@ -38,6 +72,21 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// The realIP() middleware resolves the real IP address of the client (taking any
+// trusted proxies into account) and stores it in the request context, so that the
+// rate limiter, logging and anything else downstream see the same value.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := app.resolveClientIP(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		r = app.contextSetClientIP(r, ip)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) rateLimit(next http.Handler) http.Handler {
 	// Define a client struct to hold the rate limiter and last seen time for each
 	// client.
@@ -70,27 +119,40 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		}
 	}()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Only carry out the check if rate limiting is enabled.
-		if app.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
+		// Only carry out the check if rate limiting is enabled. The limiter settings
+		// can be reloaded, so we read them from the current configuration.
+		limiter := app.currentConfig().limiter
+		if limiter.enabled {
+			// Use the client IP address resolved by the realIP() middleware, rather
+			// than the address of the immediate peer (which may be a load balancer).
+			ip := app.contextGetClientIP(r).String()
 			mu.Lock()
 			if _, found := clients[ip]; !found {
 				// Create and add a new client struct to the map if it doesn't already exist.
 				clients[ip] = &client{
 					// Use the requests-per-second and burst values from the config
 					// struct.
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+					limiter: rate.NewLimiter(rate.Limit(limiter.rps), limiter.burst),
 				}
 			}
+			// If the settings have been reloaded since the client's limiter was
+			// created, bring it up to date.
+			if clients[ip].limiter.Limit() != rate.Limit(limiter.rps) {
+				clients[ip].limiter.SetLimit(rate.Limit(limiter.rps))
+			}
+			if clients[ip].limiter.Burst() != limiter.burst {
+				clients[ip].limiter.SetBurst(limiter.burst)
+			}
 			// Update the last seen time for the client.
 			clients[ip].lastSeen = time.Now()
 			if !clients[ip].limiter.Allow() {
+				// Work out how long it'll be until the client can make another
+				// request, without using up that request.
+				reservation := clients[ip].limiter.Reserve()
+				retryAfter := reservation.Delay()
+				reservation.Cancel()
 				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+				app.rateLimitExceededResponse(w, r, retryAfter)
 				return
 			}
 			mu.Unlock()
@@ -99,12 +161,50 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	})
 }
 
+// The enableCORS() middleware allows cross-origin requests from the trusted origins,
+// and responds to their preflight requests. The Vary headers are always added, since
+// the response depends on the Origin (and for preflight requests, the
+// Access-Control-Request-Method) header either way.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			for _, trusted := range app.currentConfig().cors.trustedOrigins {
+				if origin != trusted {
+					continue
+				}
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				// A preflight request is an OPTIONS request with an
+				// Access-Control-Request-Method header. We say which methods and
+				// headers are allowed, and send an empty response.
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key, Idempotency-Key")
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
 		// caches that the response may vary based on the value of the Authorization
 		// header in the request.
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+		// API keys can be sent in the X-API-Key header, in which case we handle them
+		// separately and skip the token checks below.
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			app.authenticateAPIKey(w, r, next, apiKey)
+			return
+		}
 		// Retrieve the value of the Authorization header from the request. This will
 		// return the empty string "" if there is no such header found.
 		authorizationHeader := r.Header.Get("Authorization")
@@ -129,6 +229,12 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		// Extract the actual authentication token from the header parts.
 		token := headerParts[1]
+		// API keys can also be sent as a bearer token, and are recognized by their
+		// distinct prefix.
+		if strings.HasPrefix(token, data.APIKeyPrefix) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 		// If the token isn't valid, use the invalidAuthenticationTokenResponse()
@@ -143,6 +249,15 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// matching record was found. IMPORTANT: Notice that we are using
 		// ScopeAuthentication as the first parameter here.
 		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		// If it isn't an authentication token, it may be an impersonation token issued
+		// to an administrator, which is recorded in the context so that account
+		// management endpoints can refuse it.
+		if errors.Is(err, data.ErrRecordNotFound) {
+			user, err = app.models.Users.GetForToken(data.ScopeImpersonation, token)
+			if err == nil {
+				r = app.contextSetImpersonated(r)
+			}
+		}
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -160,6 +275,66 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// The authenticateAPIKey() helper authenticates a request using an API key. The key
+// must exist, be unexpired and be used from an allowed IP address. On success we
+// record the use, and add both the owning user and the key itself to the request
+// context, so that requirePermission() can restrict the request to the key's
+// permissions.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	key, err := app.models.APIKeys.GetForPlaintext(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	ip := app.contextGetClientIP(r)
+	if !key.AllowsIP(ip) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.APIKeys.Touch(key.ID, ip.String())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
+// The requireAccess() middleware applies the checks for an access level, in the form
+// used by apiOperation.auth: "authenticated" or "activated" for any (activated) user,
+// or otherwise a permission code.
+func (app *application) requireAccess(access string, next http.HandlerFunc) http.HandlerFunc {
+	switch access {
+	case "authenticated":
+		return app.requireAuthenticatedUser(next)
+	case "activated":
+		return app.requireActivatedUser(next)
+	default:
+		return app.requirePermission(access, next)
+	}
+}
+
 // Create a new requireAuthenticatedUser() middleware to check that a user is not
 // anonymous.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
@@ -173,6 +348,33 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
+// The disallowAPIKey() middleware rejects requests which were authenticated with an API
+// key. We use it on account management endpoints, so that a leaked key can't be used
+// to take over the account that owns it.
+func (app *application) disallowAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The disallowImpersonation() middleware rejects requests which were authenticated with
+// an impersonation token. Like disallowAPIKey(), we use it on account management
+// endpoints, so that an administrator impersonating a user can't take over their
+// account, for example by changing its email address.
+func (app *application) disallowImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetImpersonated(r) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Checks that a user is both authenticated and activated.
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
@@ -207,6 +409,12 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			app.notPermittedResponse(w, r)
 			return
 		}
+		// If the request was authenticated with an API key, then the key must have
+		// been granted the permission too.
+		if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
 		// Otherwise they have the required permission so we call the next handler in
 		// the chain.
 		next.ServeHTTP(w, r)
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/jsonlog"
+	"greenlight.m4rk1sov.github.com/internal/migrate"
+	"greenlight.m4rk1sov.github.com/migrations"
+	"greenlight.m4rk1sov.github.com/migrations2"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// The newMigrator() helper returns a Migrator for the migrations embedded in the
+// binary, from both the migrations and migrations2 directories, which logs each
+// migration that it applies or rolls back.
+func newMigrator(db *sql.DB, logger *jsonlog.Logger) (*migrate.Migrator, error) {
+	migrator, err := migrate.New(db, migrations.FS, migrations2.FS)
+	if err != nil {
+		return nil, err
+	}
+	migrator.Logf = func(format string, args ...any) {
+		logger.PrintInfo(fmt.Sprintf(format, args...), nil)
+	}
+	return migrator, nil
+}
+
+// The runCommand() function runs a subcommand given after the flags, such as:
+//
+//	api -db-dsn=... migrate up        apply all pending migrations
+//	api -db-dsn=... migrate down      roll back the latest migration
+//	api -db-dsn=... migrate to 12     migrate up or down to version 12
+//	api -db-dsn=... migrate status    list the migrations and whether they're applied
+//	api -db-dsn=... migrate adopt 12  record versions up to 12 as applied, without running them
+//
+// The adopt command is for databases migrated with the external migrate tool whose
+// version can't be adopted automatically (see internal/migrate). Check the schema to
+// find the latest migration which is fully applied, and adopt that version; the
+// other commands then work as usual.
+func runCommand(db *sql.DB, logger *jsonlog.Logger, args []string) error {
+	if args[0] != "migrate" || len(args) < 2 {
+		return fmt.Errorf("unknown command %q, expected migrate up|down|status|to N|adopt N", strings.Join(args, " "))
+	}
+	migrator, err := newMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	switch {
+	case args[1] == "up" && len(args) == 2:
+		count, err = migrator.Up()
+	case args[1] == "down" && len(args) == 2:
+		count, err = migrator.Down()
+	case (args[1] == "to" || args[1] == "adopt") && len(args) == 3:
+		version, parseErr := strconv.ParseInt(args[2], 10, 64)
+		if parseErr != nil || version < 0 {
+			return fmt.Errorf("invalid migration version %q", args[2])
+		}
+		if args[1] == "to" {
+			count, err = migrator.To(version)
+		} else {
+			count, err = migrator.Adopt(version)
+		}
+	case args[1] == "status" && len(args) == 2:
+		err = printMigrationStatus(migrator)
+		return explainMigrationError(err)
+	default:
+		return errors.New("usage: migrate up|down|status|to N|adopt N")
+	}
+	if err != nil {
+		return explainMigrationError(err)
+	}
+	logger.PrintInfo("migrations complete", map[string]string{"count": strconv.Itoa(count)})
+	return nil
+}
+
+// The explainMigrationError() function adds what to do about a legacy version which
+// can't be adopted to the error, as the operator has to sort it out by hand.
+func explainMigrationError(err error) error {
+	if errors.Is(err, migrate.ErrLegacyVersion) {
+		return fmt.Errorf("%w; check which migrations have been applied, and record the latest with \"migrate adopt N\"", err)
+	}
+	return err
+}
+
+// The printMigrationStatus() function writes a table of the migrations to stdout.
+func printMigrationStatus(migrator *migrate.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.Applied {
+			applied = status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	return tw.Flush()
+}
@@ -69,7 +69,7 @@ func (app *application) createModuleInfoHandler(w http.ResponseWriter, r *http.R
 	headers.Set("Location", fmt.Sprintf("/v1/modules/%d", module_info.ID))
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"modules": module_info}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"modules": module_info}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -97,6 +97,14 @@ func (app *application) getModuleInfoHandler(w http.ResponseWriter, r *http.Requ
 	//	Version:   1,
 	//}
 
+	// Read the fields (and related resources) that the client wants in the response.
+	v := validator.New()
+	vw := app.readView(r.URL.Query(), data.Module_info{}, moduleRelations, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
@@ -112,8 +120,13 @@ func (app *application) getModuleInfoHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	items, err := app.renderModules(vw, []*data.Module_info{module_info})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Encode the struct JSON and send it as the HTTP response
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": module_info}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": items[0]}, nil)
 	if err != nil {
 		// new helper
 		app.serverErrorResponse(w, r, err)
@@ -146,37 +159,14 @@ func (app *application) editModuleInfoHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Declare an input struct to hold the expected data from the client.
-	// Use pointers for the Title, Year and Runtime fields.
-	var input struct {
-		ModuleName     *string       `json:"moduleName"`
-		ModuleDuration *data.Runtime `json:"moduleDuration,omitempty"`
-		ExamType       *string       `json:"examType"`
-	}
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
+	// Apply the patch in the request body to the module record, in the same way as
+	// for movies.
+	err = app.readPatch(w, r, module_info, "id", "version")
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.patchErrorResponse(w, r, err)
 		return
 	}
 
-	// If the input.Title value is nil then we know that no corresponding "title" key/
-	// value pair was provided in the JSON request body. So we move on and leave the
-	// movie record unchanged. Otherwise, we update the movie record with the new title
-	// value. Importantly, because input.Title is a now a pointer to a string, we need
-	// to dereference the pointer using the * operator to get the underlying value
-	// before assigning it to our movie record.
-	if input.ModuleName != nil {
-		module_info.ModuleName = *input.ModuleName
-	}
-	// We also do the same for the other fields in the input struct.
-	if input.ModuleDuration != nil {
-		module_info.ModuleDuration = *input.ModuleDuration
-	}
-	if input.ExamType != nil {
-		module_info.ExamType = *input.ExamType
-	}
-
 	//// Copy the values from the request body to the appropriate fields of the movie
 	//// record.
 	//movie.Title = input.Title
@@ -213,7 +203,7 @@ func (app *application) editModuleInfoHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": module_info}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": module_info}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -239,7 +229,7 @@ func (app *application) deleteModuleInfoHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Module successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "Module successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -258,11 +248,28 @@ func (app *application) listModulesInfoHandler(w http.ResponseWriter, r *http.Re
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "moduleName", "moduleDuration", "-id", "-moduleName", "-moduleDuration"}
+	input.Filters.SortSafelist = data.ModuleSortSafelist
+	vw := app.readView(qs, data.Module_info{}, moduleRelations, v)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// If the response is going to be JSON, then stream the modules to the client as
+	// they are read from the database. Embedding the departments needs the IDs of the
+	// whole page up front, so that they can be fetched with a single query, so those
+	// responses are built in memory instead.
+	if streamsJSONList(r) && !vw.expands("departments") {
+		app.streamJSONList(w, r, "module_info", func(write func(item any, metadata data.Metadata) error) (data.Metadata, error) {
+			return app.models.Module_info.EachModule(r.Context(), input.ModuleName, input.ExamType, input.Filters, func(module_info *data.Module_info, metadata data.Metadata) error {
+				item, err := vw.render(module_info, nil)
+				if err != nil {
+					return err
+				}
+				return write(item, metadata)
+			})
+		})
+		return
+	}
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
 	// parameters.
 	// Accept the metadata struct as a return value.
@@ -271,11 +278,54 @@ func (app *application) listModulesInfoHandler(w http.ResponseWriter, r *http.Re
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	items, err := app.renderModules(vw, modules_info)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Send a JSON response containing the movie data.
 	// Include the metadata in the response envelope.
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": modules_info, "metadata": metadata}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": items, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// The relations which can be embedded in module responses with the expand parameter.
+var moduleRelations = []string{"departments"}
+
+// The renderModules() helper renders modules for a response, embedding their
+// departments if the client asked for them. The departments for all of the modules are
+// fetched with a single query.
+func (app *application) renderModules(vw view, modules []*data.Module_info) ([]any, error) {
+	var departments map[int64][]*data.DepartmentInfo
+	if vw.expands("departments") {
+		ids := make([]int64, len(modules))
+		for i, module := range modules {
+			ids[i] = module.ID
+		}
+		var err error
+		departments, err = app.models.DepartmentInfo.GetAllForModules(ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	items := make([]any, len(modules))
+	for i, module := range modules {
+		var related map[string]any
+		if vw.expands("departments") {
+			moduleDepartments := departments[module.ID]
+			if moduleDepartments == nil {
+				moduleDepartments = []*data.DepartmentInfo{}
+			}
+			related = map[string]any{"departments": moduleDepartments}
+		}
+		item, err := vw.render(module, related)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
@@ -71,7 +71,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -99,6 +99,14 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	//	Version:   1,
 	//}
 
+	// Read the fields (and related resources) that the client wants in the response.
+	v := validator.New()
+	vw := app.readView(r.URL.Query(), data.Movie{}, nil, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
@@ -115,7 +123,12 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Encode the struct JSON and send it as the HTTP response
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	item, err := vw.render(movie, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": item}, nil)
 	if err != nil {
 		// new helper
 		app.serverErrorResponse(w, r, err)
@@ -148,41 +161,16 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Declare an input struct to hold the expected data from the client.
-	// Use pointers for the Title, Year and Runtime fields.
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
+	// Apply the patch in the request body to the movie record. The client can send a
+	// JSON Merge Patch (or plain JSON, which is treated the same way) to change or
+	// clear fields, or a JSON Patch to do things like adding a single genre or testing
+	// the version before changing anything.
+	err = app.readPatch(w, r, movie, "id", "version")
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.patchErrorResponse(w, r, err)
 		return
 	}
 
-	// If the input.Title value is nil then we know that no corresponding "title" key/
-	// value pair was provided in the JSON request body. So we move on and leave the
-	// movie record unchanged. Otherwise, we update the movie record with the new title
-	// value. Importantly, because input.Title is a now a pointer to a string, we need
-	// to dereference the pointer using the * operator to get the underlying value
-	// before assigning it to our movie record.
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	// We also do the same for the other fields in the input struct.
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres // Note that we don't need to dereference a slice.
-	}
-
 	//// Copy the values from the request body to the appropriate fields of the movie
 	//// record.
 	//movie.Title = input.Title
@@ -219,7 +207,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -245,7 +233,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -264,11 +252,29 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = data.MovieSortSafelist
+	// Read the fields that the client wants, so that the query only selects those
+	// columns.
+	vw := app.readView(qs, data.Movie{}, nil, v)
+	input.Filters.Fields = vw.fields
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// If the response is going to be JSON, then stream the movies to the client as they
+	// are read from the database, rather than holding the whole page in memory.
+	if streamsJSONList(r) {
+		app.streamJSONList(w, r, "movies", func(write func(item any, metadata data.Metadata) error) (data.Metadata, error) {
+			return app.models.Movies.Each(r.Context(), input.Title, input.Genres, input.Filters, func(movie *data.Movie, metadata data.Metadata) error {
+				item, err := vw.render(movie, nil)
+				if err != nil {
+					return err
+				}
+				return write(item, metadata)
+			})
+		})
+		return
+	}
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
 	// parameters.
 	// Accept the metadata struct as a return value.
@@ -277,9 +283,14 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	list, err := renderList(vw, movies, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Send a JSON response containing the movie data.
 	// Include the metadata in the response envelope.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": list, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/oidc"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net/http"
+	"time"
+)
+
+// How long a user has to complete a login at the identity provider.
+const oidcStateTTL = 10 * time.Minute
+
+// The cookie which ties a login to the browser that started it.
+const oidcStateCookie = "greenlight_oidc_state"
+
+// The oidcLoginHandler() starts a single sign-on login. It records the state, nonce
+// and PKCE code verifier for the login and then redirects the user to the identity
+// provider. The state is also set in a cookie, so that the callback can check that it
+// comes from the same browser, and an attacker can't have a victim complete a login
+// which the attacker started (login CSRF).
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	state := &data.OIDCState{Expiry: time.Now().Add(oidcStateTTL)}
+	var err error
+	for _, value := range []*string{&state.State, &state.Nonce} {
+		*value, err = oidc.GenerateNonce()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	state.CodeVerifier, err = oidc.GenerateVerifier()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.OIDCStates.Insert(state)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state.State,
+		Path:     "/v1/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		Secure:   app.config.env != "development",
+		HttpOnly: true,
+		// Lax, rather than Strict, so that the cookie is sent with the redirect back
+		// from the identity provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// The oidcCallbackHandler() completes a single sign-on login. The authorization code
+// is exchanged for an ID token, the external identity is mapped to a local user
+// (linking or creating one if necessary), and a normal authentication token is issued.
+// The same checks apply as when logging in with a password: locked out clients and
+// accounts are refused, and users with two-factor authentication enabled are sent a
+// 2fa-pending token rather than an authentication token.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	retryAfter, err := app.loginRetryAfter(ipLockoutKey(app.contextGetClientIP(r).String()))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+	qs := r.URL.Query()
+	// If the user declined the login, or something went wrong at the identity
+	// provider, then we are sent an error code instead of an authorization code.
+	if errorCode := app.readString(qs, "error", ""); errorCode != "" {
+		app.badRequestResponse(w, r, fmt.Errorf("identity provider returned an error: %s", errorCode))
+		return
+	}
+	stateValue := app.readString(qs, "state", "")
+	code := app.readString(qs, "code", "")
+	v := validator.New()
+	v.Check(stateValue != "", "state", "must be provided")
+	v.Check(code != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// The login must be completed in the browser which started it. Once it has been
+	// checked, the cookie isn't needed any more.
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateValue)) != 1 {
+		v.AddError("state", "does not match the login started in this browser")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/v1/oidc", MaxAge: -1})
+	state, err := app.models.OIDCStates.Consume(stateValue)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	claims, err := app.oidc.Exchange(r.Context(), code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken), errors.Is(err, oidc.ErrUnsupportedAlg), errors.Is(err, oidc.ErrExchangeRejected):
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user, err := app.userForIdentity(v, claims)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// The identity provider has authenticated the user, but an account which has been
+	// locked after failed logins stays locked until the lockout ends (or an
+	// administrator lifts it).
+	retryAfter, err = app.loginRetryAfter(accountLockoutKey(user.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+	if app.requireSecondFactor(w, r, user) {
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The userForIdentity() helper returns the local user for an external identity. If the
+// identity isn't linked yet, we link it to the existing user with the same email
+// address or, if there isn't one, provision a new activated user with the default
+// permissions. Either way the identity provider must have verified the email address.
+// Problems with the claims are recorded in the provided Validator instance.
+func (app *application) userForIdentity(v *validator.Validator, claims *oidc.Claims) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(claims.Issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+	if !claims.EmailVerified {
+		v.AddError("email", "must be verified by the identity provider")
+		return nil, nil
+	}
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// The identity provider has vouched for the email address, so an account that
+		// was never activated can be activated now.
+		if !user.Activated {
+			user.Activated = true
+			err = app.models.Users.Update(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.provisionUser(v, claims)
+		if err != nil || !v.Valid() {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	err = app.models.Identities.Insert(&data.Identity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// The provisionUser() helper creates a new activated user for an external identity.
+// The user is given a random password that nobody knows, so they can only log in via
+// the identity provider, unless they later set a password of their own with a token
+// from POST /v1/tokens/password-reset.
+func (app *application) provisionUser(v *validator.Validator, claims *oidc.Claims) (*data.User, error) {
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+	err := user.Password.SetRandom()
+	if err != nil {
+		return nil, err
+	}
+	if data.ValidateUser(v, user); !v.Valid() {
+		return nil, nil
+	}
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddFieldError("email", validator.AlreadyExists().WithMessage("a user with this email address already exists"))
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	err = app.models.Permissions.AddForUser(user.ID, defaultPermissions...)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/oidc"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testOIDCClientID = "greenlight"
+
+// The testIdP type is an identity provider which serves a discovery document, its
+// signing key and a token endpoint. The token endpoint returns whatever ID token the
+// test has set for the authorization code.
+type testIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	tokens map[string]string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key, tokens: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idToken, ok := idp.tokens[r.PostFormValue("code")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// The sign() method returns an ID token for the claims, signed with the given key.
+func (idp *testIdP) sign(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key-1"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// The login() method starts a login as the oidcLoginHandler() would, and sets up the
+// token endpoint to return an ID token for the email address, signed with the key.
+// It returns the path of the callback that the identity provider would redirect to.
+func (idp *testIdP) login(t *testing.T, app *application, email string, key *rsa.PrivateKey) (string, string) {
+	t.Helper()
+	state := &data.OIDCState{Expiry: time.Now().Add(oidcStateTTL)}
+	for _, value := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		var err error
+		*value, err = oidc.GenerateNonce()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := app.models.OIDCStates.Insert(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := "code-" + state.State
+	idp.tokens[code] = idp.sign(t, key, map[string]any{
+		"iss":            idp.URL,
+		"sub":            email,
+		"aud":            testOIDCClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          state.Nonce,
+		"email":          email,
+		"email_verified": true,
+	})
+	path := "/v1/oidc/callback?" + url.Values{"state": {state.State}, "code": {code}}.Encode()
+	return path, (&http.Cookie{Name: oidcStateCookie, Value: state.State}).String()
+}
+
+func TestOIDCCallback(t *testing.T) {
+	db := newTestDB(t)
+	idp := newTestIdP(t)
+	app := newTestApplication(t, db)
+	app.oidc = oidc.New(idp.URL, testOIDCClientID, "", "http://localhost/v1/oidc/callback")
+	ts := newTestServer(t, app.routes())
+
+	t.Run("existing user", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		path, cookie := idp.login(t, app, user.Email, idp.key)
+		status, body := ts.do(t, http.MethodGet, path, nil, "Cookie", cookie)
+		if status != http.StatusCreated {
+			t.Fatalf("got status %d; want %d: %v", status, http.StatusCreated, body)
+		}
+		if body["authentication_token"] == nil {
+			t.Errorf("got no authentication token: %v", body)
+		}
+		linked, err := app.models.Identities.GetUser(idp.URL, user.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if linked.ID != user.ID {
+			t.Errorf("identity linked to user %d; want %d", linked.ID, user.ID)
+		}
+	})
+
+	t.Run("two-factor authentication enabled", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		err := app.models.TwoFactor.Enroll(&data.TwoFactor{UserID: user.ID, Secret: []byte("12345678901234567890")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = app.models.TwoFactor.Enable(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, cookie := idp.login(t, app, user.Email, idp.key)
+		status, body := ts.do(t, http.MethodGet, path, nil, "Cookie", cookie)
+		if status != http.StatusAccepted {
+			t.Fatalf("got status %d; want %d: %v", status, http.StatusAccepted, body)
+		}
+		if body["2fa_pending_token"] == nil || body["authentication_token"] != nil {
+			t.Errorf("got %v; want only a 2fa_pending_token", body)
+		}
+	})
+
+	t.Run("locked account", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		key := accountLockoutKey(user.ID)
+		_, err := app.models.LoginAttempts.RecordFailure(key, time.Hour, 1, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { app.models.LoginAttempts.Reset(key) })
+		path, cookie := idp.login(t, app, user.Email, idp.key)
+		status, body := ts.do(t, http.MethodGet, path, nil, "Cookie", cookie)
+		if status != http.StatusTooManyRequests {
+			t.Fatalf("got status %d; want %d: %v", status, http.StatusTooManyRequests, body)
+		}
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, cookie := idp.login(t, app, testEmail(t), otherKey)
+		status, _ := ts.do(t, http.MethodGet, path, nil, "Cookie", cookie)
+		if status != http.StatusUnauthorized {
+			t.Fatalf("got status %d; want %d", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("state used twice", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		path, cookie := idp.login(t, app, user.Email, idp.key)
+		ts.do(t, http.MethodGet, path, nil, "Cookie", cookie)
+		status, _ := ts.do(t, http.MethodGet, path, nil, "Cookie", cookie)
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %d; want %d", status, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("different browser", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		path, _ := idp.login(t, app, user.Email, idp.key)
+		_, otherCookie := idp.login(t, app, user.Email, idp.key)
+		for _, headers := range [][]string{nil, {"Cookie", otherCookie}} {
+			status, _ := ts.do(t, http.MethodGet, path, nil, headers...)
+			if status != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d with cookie %q; want %d", status, headers, http.StatusUnprocessableEntity)
+			}
+		}
+	})
+}
+
+func TestOIDCLoginSetsStateCookie(t *testing.T) {
+	db := newTestDB(t)
+	idp := newTestIdP(t)
+	app := newTestApplication(t, db)
+	app.oidc = oidc.New(idp.URL, testOIDCClientID, "", "http://localhost/v1/oidc/callback")
+	ts := newTestServer(t, app.routes())
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(ts.URL + "/v1/oidc/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusFound)
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != location.Query().Get("state") {
+		t.Fatalf("got cookie %v; want one holding the state %q", cookie, location.Query().Get("state"))
+	}
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("got cookie %v; want it HttpOnly and SameSite=Lax", cookie)
+	}
+}
+
+func TestOIDCCallbackNotConfigured(t *testing.T) {
+	app := newTestApplication(t, nil)
+	ts := newTestServer(t, app.routes())
+	status, _ := ts.do(t, http.MethodGet, "/v1/oidc/callback?state=a&code=b", nil)
+	if status != http.StatusNotFound {
+		t.Fatalf("got status %d; want %d", status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,875 @@
+package main
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The routeTable type wraps httprouter.Router and keeps a record of the routes which
+// are registered on it, and the access that each of them requires, so that the
+// OpenAPI document can be checked against them. If middleware is set, it's applied to
+// the handler for each route, with the route in the same form as the keys of
+// apiOperations. It runs after the access checks, so that a client which isn't allowed
+// to use a route gets a 401 or 403 response rather than a list of problems with its
+// request.
+type routeTable struct {
+	*httprouter.Router
+	routes     []string
+	access     map[string]string
+	middleware func(route string, next http.HandlerFunc) http.HandlerFunc
+	// The function which wraps a handler with the checks for an access level (see
+	// Require()).
+	require func(access string, next http.HandlerFunc) http.HandlerFunc
+}
+
+// HandlerFunc registers a handler for a route which anyone can use.
+func (t *routeTable) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	t.Require(method, path, "", handler)
+}
+
+// Require registers a handler for a route which requires the given access, in the same
+// form as apiOperation.auth. Any checks run after the access check, in the order
+// given, and before the middleware.
+func (t *routeTable) Require(method, path, access string, handler http.HandlerFunc, checks ...func(http.HandlerFunc) http.HandlerFunc) {
+	route := method + " " + path
+	t.routes = append(t.routes, route)
+	if t.access == nil {
+		t.access = make(map[string]string)
+	}
+	t.access[route] = access
+	if t.middleware != nil {
+		handler = t.middleware(route, handler)
+	}
+	for i := len(checks) - 1; i >= 0; i-- {
+		handler = checks[i](handler)
+	}
+	if access != "" {
+		handler = t.require(access, handler)
+	}
+	t.Router.HandlerFunc(method, path, handler)
+}
+
+// The apiOperation type describes an endpoint for the OpenAPI document. The request
+// body and response are described by example values, whose types are converted into
+// JSON schemas (including the constraints from any validate struct tags).
+type apiOperation struct {
+	id      string
+	summary string
+	tag     string
+	// The access that the endpoint requires: "" for none, "authenticated" or
+	// "activated" for any (activated) user, or otherwise a permission code.
+	auth string
+	// The query string parameters. List endpoints also take the pagination parameters
+	// and a sort parameter which accepts the values in sort, defaulting to sortDefault
+	// (or id, if that's empty).
+	query       []apiParameter
+	sort        []string
+	sortDefault string
+	// The resource whose fields can be picked with the fields parameter, and the
+	// relations which can be embedded with the expand parameter.
+	fields any
+	expand []string
+	// The request body, and whether it's a patch.
+	body  any
+	patch bool
+	// Whether the endpoint accepts an Idempotency-Key header.
+	idempotent bool
+	status     int
+	response   envelope
+	// Another successful response which the endpoint can send instead, if any.
+	alternate *apiResponse
+	// Any error statuses that the endpoint can return beyond the usual ones.
+	errors []int
+}
+
+// The apiResponse type describes a successful response other than the usual one.
+type apiResponse struct {
+	status      int
+	description string
+	response    envelope
+}
+
+// The response to a login which needs a two-factor authentication code before it can
+// be completed.
+var twoFactorRequiredResponse = &apiResponse{
+	status:      http.StatusAccepted,
+	description: "Two-factor authentication is required",
+	response:    envelope{"2fa_pending_token": data.Token{}},
+}
+
+// The apiParameter type describes a query string parameter.
+type apiParameter struct {
+	name        string
+	schema      map[string]any
+	description string
+}
+
+// The apiOperations map describes every route, keyed by method and path in the form
+// used by the router. Every route must have an entry here, otherwise the application
+// won't start (see buildOpenAPI()).
+var apiOperations = map[string]apiOperation{
+	"GET /v1/healthcheck": {
+		id: "healthcheck", summary: "Show the application status", tag: "system",
+		status: http.StatusOK,
+		response: envelope{"status": "", "system_info": struct {
+			Environment string `json:"environment"`
+			Version     string `json:"version"`
+		}{}},
+	},
+	"GET /v1/openapi.json": {
+		id: "getOpenAPI", summary: "Show this OpenAPI document", tag: "system",
+		status: http.StatusOK,
+	},
+
+	"GET /v1/movies": {
+		id: "listMovies", summary: "List movies", tag: "movies", auth: "movies:read",
+		query: []apiParameter{
+			{"title", stringSchema(), "Full-text search on the movie title"},
+			{"genres", stringSchema(), "Comma-separated list of genres which the movies must all have"},
+		},
+		sort: data.MovieSortSafelist, fields: data.Movie{},
+		status: http.StatusOK, response: envelope{"movies": []data.Movie{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/movies": {
+		id: "createMovie", summary: "Create a movie", tag: "movies", auth: "movies:write",
+		body: data.Movie{}, idempotent: true,
+		status: http.StatusCreated, response: envelope{"movie": data.Movie{}},
+	},
+	"GET /v1/movies/:id": {
+		id: "showMovie", summary: "Show a movie", tag: "movies", auth: "movies:read",
+		fields: data.Movie{},
+		status: http.StatusOK, response: envelope{"movie": data.Movie{}},
+	},
+	"PATCH /v1/movies/:id": {
+		id: "updateMovie", summary: "Update a movie", tag: "movies", auth: "movies:write",
+		body: data.Movie{}, patch: true,
+		status: http.StatusOK, response: envelope{"movie": data.Movie{}},
+		errors: []int{http.StatusConflict},
+	},
+	"DELETE /v1/movies/:id": {
+		id: "deleteMovie", summary: "Delete a movie", tag: "movies", auth: "movies:write",
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+
+	"GET /v1/modules": {
+		id: "listModules", summary: "List modules", tag: "modules", auth: "movies:read",
+		query: []apiParameter{
+			{"moduleName", stringSchema(), "Full-text search on the module name"},
+			{"examType", stringSchema(), "Only list modules with this exam type"},
+		},
+		sort: data.ModuleSortSafelist, fields: data.Module_info{}, expand: moduleRelations,
+		status: http.StatusOK, response: envelope{"module_info": []data.Module_info{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/modules": {
+		id: "createModule", summary: "Create a module", tag: "modules", auth: "movies:write",
+		body: data.Module_info{}, idempotent: true,
+		status: http.StatusCreated, response: envelope{"modules": data.Module_info{}},
+	},
+	"GET /v1/modules/:id": {
+		id: "showModule", summary: "Show a module", tag: "modules", auth: "movies:read",
+		fields: data.Module_info{}, expand: moduleRelations,
+		status: http.StatusOK, response: envelope{"module_info": data.Module_info{}},
+	},
+	"PATCH /v1/modules/:id": {
+		id: "updateModule", summary: "Update a module", tag: "modules", auth: "movies:write",
+		body: data.Module_info{}, patch: true,
+		status: http.StatusOK, response: envelope{"module_info": data.Module_info{}},
+		errors: []int{http.StatusConflict},
+	},
+	"DELETE /v1/modules/:id": {
+		id: "deleteModule", summary: "Delete a module", tag: "modules", auth: "movies:write",
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+
+	"POST /v1/departments": {
+		id: "createDepartment", summary: "Create a department", tag: "departments", auth: "movies:write",
+		body: data.DepartmentInfo{}, idempotent: true,
+		status: http.StatusCreated, response: envelope{"departments": data.DepartmentInfo{}},
+	},
+	"GET /v1/departments/:id": {
+		id: "showDepartment", summary: "Show a department", tag: "departments", auth: "movies:read",
+		fields: data.DepartmentInfo{}, expand: departmentRelations,
+		status: http.StatusOK, response: envelope{"departmentInfo": data.DepartmentInfo{}},
+	},
+
+	"POST /v1/users": {
+		id: "registerUser", summary: "Register a new user", tag: "users",
+		body: struct {
+			Name     string `json:"name" validate:"required,max=500"`
+			Email    string `json:"email" validate:"required,email"`
+			Password string `json:"password" validate:"required,min=8,max=256"`
+		}{},
+		status: http.StatusAccepted, response: envelope{"user": data.User{}},
+	},
+	"PUT /v1/users/activated": {
+		id: "activateUser", summary: "Activate a user with the token from the welcome email", tag: "users",
+		body: struct {
+			Token string `json:"token" validate:"required,len=26"`
+		}{},
+		status: http.StatusOK, response: envelope{"user": data.User{}},
+		errors: []int{http.StatusConflict},
+	},
+	"PUT /v1/users/password": {
+		id: "resetPassword", summary: "Set a new password with a password-reset token", tag: "users",
+		body: struct {
+			Password string `json:"password" validate:"required,min=8,max=256"`
+			Token    string `json:"token" validate:"required,len=26"`
+		}{},
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+	"POST /v1/tokens/authentication": {
+		id: "createAuthenticationToken", summary: "Log in with an email address and password", tag: "tokens",
+		body: struct {
+			Email    string `json:"email" validate:"required,email"`
+			Password string `json:"password" validate:"required,min=8,max=256"`
+		}{},
+		status: http.StatusCreated, response: envelope{"authentication_token": data.Token{}},
+		alternate: twoFactorRequiredResponse,
+		errors:    []int{http.StatusUnauthorized},
+	},
+	"POST /v1/tokens/2fa": {
+		id: "createTwoFactorAuthenticationToken", summary: "Complete a login with a two-factor authentication code", tag: "tokens",
+		body: struct {
+			Token string `json:"token" validate:"required,len=26"`
+			Code  string `json:"code" validate:"required"`
+		}{},
+		status: http.StatusCreated, response: envelope{"authentication_token": data.Token{}},
+		errors: []int{http.StatusUnauthorized},
+	},
+	"POST /v1/tokens/password-reset": {
+		id: "createPasswordResetToken", summary: "Email a password-reset token, if the address has an account", tag: "tokens",
+		body: struct {
+			Email string `json:"email" validate:"required,email"`
+		}{},
+		status: http.StatusAccepted, response: envelope{"message": ""},
+	},
+
+	"GET /v1/oidc/login": {
+		id: "oidcLogin", summary: "Start a single sign-on login, redirecting to the identity provider", tag: "oidc",
+		status: http.StatusFound,
+	},
+	"GET /v1/oidc/callback": {
+		id: "oidcCallback", summary: "Complete a single sign-on login", tag: "oidc",
+		query: []apiParameter{
+			{"code", stringSchema(), "The authorization code from the identity provider"},
+			{"state", stringSchema(), "The state value from the login redirect"},
+			{"error", stringSchema(), "The error code, if the identity provider refused the login"},
+		},
+		status: http.StatusCreated, response: envelope{"authentication_token": data.Token{}},
+		alternate: twoFactorRequiredResponse,
+		errors:    []int{http.StatusUnauthorized},
+	},
+
+	"GET /v1/users/me": {
+		id: "showCurrentUser", summary: "Show your account", tag: "account", auth: "authenticated",
+		fields: data.User{},
+		status: http.StatusOK, response: envelope{"user": data.User{}},
+	},
+	"PATCH /v1/users/me": {
+		id: "updateCurrentUser", summary: "Update your account", tag: "account", auth: "authenticated",
+		body: struct {
+			Name string `json:"name" validate:"max=500"`
+		}{},
+		status: http.StatusOK, response: envelope{"user": data.User{}},
+		errors: []int{http.StatusConflict},
+	},
+	"DELETE /v1/users/me": {
+		id: "deleteCurrentUser", summary: "Delete your account, giving your current password or a password-reset token", tag: "account", auth: "authenticated",
+		body: struct {
+			CurrentPassword string `json:"current_password"`
+			Token           string `json:"token" validate:"len=26"`
+		}{},
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+	"POST /v1/users/me/email": {
+		id: "requestEmailChange", summary: "Start changing your email address", tag: "account", auth: "authenticated",
+		body: struct {
+			Email string `json:"email" validate:"required,email"`
+		}{},
+		status: http.StatusAccepted, response: envelope{"message": ""},
+	},
+	"PUT /v1/users/me/email": {
+		id: "confirmEmailChange", summary: "Confirm a change of email address", tag: "account", auth: "authenticated",
+		body: struct {
+			Token string `json:"token" validate:"required,len=26"`
+		}{},
+		status: http.StatusOK, response: envelope{"user": data.User{}},
+		errors: []int{http.StatusConflict},
+	},
+	"PUT /v1/users/me/password": {
+		id: "changePassword", summary: "Change your password, giving your current password or a password-reset token", tag: "account", auth: "authenticated",
+		body: struct {
+			CurrentPassword string `json:"current_password"`
+			Token           string `json:"token" validate:"len=26"`
+			Password        string `json:"password" validate:"required,min=8,max=256"`
+		}{},
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+
+	"POST /v1/users/me/2fa": {
+		id: "enrollTwoFactor", summary: "Start enrolling in two-factor authentication", tag: "two-factor", auth: "activated",
+		status: http.StatusCreated, response: envelope{"two_factor": struct {
+			Secret     string `json:"secret"`
+			OTPAuthURI string `json:"otpauth_uri"`
+			Enabled    bool   `json:"enabled"`
+		}{}},
+	},
+	"POST /v1/users/me/2fa/verify": {
+		id: "verifyTwoFactor", summary: "Enable two-factor authentication with a code from your app", tag: "two-factor", auth: "activated",
+		body: struct {
+			Code string `json:"code" validate:"required"`
+		}{},
+		status: http.StatusOK, response: envelope{"recovery_codes": []string{}},
+	},
+	"DELETE /v1/users/me/2fa": {
+		id: "disableTwoFactor", summary: "Disable two-factor authentication", tag: "two-factor", auth: "activated",
+		body: struct {
+			Code string `json:"code" validate:"required"`
+		}{},
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+
+	"GET /v1/users/me/api-keys": {
+		id: "listAPIKeys", summary: "List your API keys", tag: "api-keys", auth: "activated",
+		fields: data.APIKey{},
+		status: http.StatusOK, response: envelope{"api_keys": []data.APIKey{}},
+	},
+	"POST /v1/users/me/api-keys": {
+		id: "createAPIKey", summary: "Create an API key", tag: "api-keys", auth: "activated",
+		body: struct {
+			Name        string     `json:"name" validate:"required,max=100"`
+			Permissions []string   `json:"permissions" validate:"required,min=1,unique"`
+			Expiry      *time.Time `json:"expiry"`
+			AllowedIPs  []string   `json:"allowed_ips"`
+		}{},
+		status: http.StatusCreated, response: envelope{"api_key": data.APIKey{}},
+	},
+	"DELETE /v1/users/me/api-keys/:id": {
+		id: "deleteAPIKey", summary: "Revoke an API key", tag: "api-keys", auth: "activated",
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+
+	"GET /v1/admin/users": {
+		id: "listUsers", summary: "List user accounts", tag: "admin", auth: "admin:users",
+		query: []apiParameter{
+			{"activated", map[string]any{"type": "boolean"}, "Only list users who are (or aren't) activated"},
+			{"email", stringSchema(), "Only list users whose email address contains this text, ignoring case"},
+			{"created_after", timeParameterSchema(), "Only list users created after this time"},
+			{"created_before", timeParameterSchema(), "Only list users created before this time"},
+		},
+		sort: data.UserSortSafelist, fields: data.User{},
+		status: http.StatusOK, response: envelope{"users": []data.User{}, "metadata": data.Metadata{}},
+	},
+	"GET /v1/admin/users/:id": {
+		id: "showUser", summary: "Show a user account", tag: "admin", auth: "admin:users",
+		fields: data.User{},
+		status: http.StatusOK, response: envelope{"user": data.User{}},
+	},
+	"PATCH /v1/admin/users/:id": {
+		id: "updateUser", summary: "Activate or deactivate a user, or force a password reset", tag: "admin", auth: "admin:users",
+		body: struct {
+			Activated          *bool `json:"activated"`
+			ForcePasswordReset bool  `json:"force_password_reset"`
+		}{},
+		status: http.StatusOK, response: envelope{"user": data.User{}},
+		errors: []int{http.StatusConflict},
+	},
+	"DELETE /v1/admin/users/:id": {
+		id: "deleteUser", summary: "Delete a user account", tag: "admin", auth: "admin:users",
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+	"DELETE /v1/admin/users/:id/lockout": {
+		id: "unlockUser", summary: "Clear the login lockout for a user account", tag: "admin", auth: "admin:users",
+		status: http.StatusOK, response: envelope{"message": ""},
+	},
+	"POST /v1/admin/users/:id/impersonation": {
+		id: "impersonateUser", summary: "Get a short-lived token which authenticates as a user, except on account management endpoints", tag: "admin", auth: "admin:users",
+		status: http.StatusCreated, response: envelope{"impersonation_token": data.Token{}},
+	},
+	"GET /v1/admin/jobs": {
+		id: "listJobs", summary: "List the background jobs and their latest runs", tag: "admin", auth: "admin:users",
+		status: http.StatusOK, response: envelope{"jobs": []JobInfo{}, "scheduler": SchedulerInfo{}},
+	},
+	"GET /v1/admin/jobs/runs": {
+		id: "listJobRuns", summary: "List the history of background job runs", tag: "admin", auth: "admin:users",
+		query: []apiParameter{
+			{"job", stringSchema(), "Only list the runs of this job"},
+			{"status", map[string]any{"type": "string", "enum": []string{data.JobSucceeded, data.JobFailed}}, "Only list the runs with this status"},
+		},
+		sort: data.JobRunSortSafelist, sortDefault: "-started_at",
+		status: http.StatusOK, response: envelope{"job_runs": []data.JobRun{}, "metadata": data.Metadata{}},
+	},
+}
+
+// The buildOpenAPI() helper generates the OpenAPI 3.1 document for the API from the
+// registered routes and their entries in apiOperations. It returns an error if any
+// route is missing from apiOperations, if apiOperations describes a route which isn't
+// registered, or if it describes the access that a route requires differently, so
+// that the document can't fall out of date.
+func buildOpenAPI(router *routeTable) (envelope, error) {
+	g := &schemaGenerator{schemas: make(map[string]any)}
+	paths := make(map[string]map[string]any)
+	registered := make(map[string]bool)
+	var missing, wrongAuth []string
+	for _, route := range router.routes {
+		registered[route] = true
+		op, ok := apiOperations[route]
+		if !ok {
+			missing = append(missing, route)
+			continue
+		}
+		if op.auth != router.access[route] {
+			wrongAuth = append(wrongAuth, fmt.Sprintf("%s (requires %q, documented as %q)", route, router.access[route], op.auth))
+		}
+		method, path, _ := strings.Cut(route, " ")
+		specPath := openAPIPath(path)
+		if paths[specPath] == nil {
+			paths[specPath] = make(map[string]any)
+		}
+		paths[specPath][strings.ToLower(method)] = g.operation(path, op)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("openapi: routes missing from apiOperations: %s", strings.Join(missing, ", "))
+	}
+	if len(wrongAuth) > 0 {
+		return nil, fmt.Errorf("openapi: apiOperations describes the access for routes wrongly: %s", strings.Join(wrongAuth, ", "))
+	}
+	for route := range apiOperations {
+		if !registered[route] {
+			missing = append(missing, route)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("openapi: apiOperations describes routes which aren't registered: %s", strings.Join(missing, ", "))
+	}
+
+	g.schemas["Problem"] = map[string]any{
+		"type":     "object",
+		"required": []string{"type", "title", "status", "detail", "instance"},
+		"properties": map[string]any{
+			"type":       map[string]any{"type": "string", "format": "uri"},
+			"title":      stringSchema(),
+			"status":     map[string]any{"type": "integer"},
+			"detail":     stringSchema(),
+			"instance":   stringSchema(),
+			"request_id": stringSchema(),
+		},
+	}
+	g.schemas["ValidationProblem"] = map[string]any{
+		"allOf": []any{
+			ref("Problem"),
+			map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"errors": map[string]any{
+						"type":                 "object",
+						"description":          "The problems with the request, keyed by field path (such as genres[2])",
+						"additionalProperties": map[string]any{"type": "array", "items": ref("ValidationError")},
+					},
+				},
+			},
+		},
+	}
+	g.schemas["ValidationError"] = map[string]any{
+		"type":                 "object",
+		"required":             []string{"code", "message"},
+		"description":          "A single validation problem. Any parameters for the problem (such as min for a too_short error) are included as extra members.",
+		"properties":           map[string]any{"code": stringSchema(), "message": stringSchema()},
+		"additionalProperties": true,
+	}
+	g.schemas["JSONPatch"] = map[string]any{
+		"type":        "array",
+		"description": "A JSON Patch document (RFC 6902)",
+		"items": map[string]any{
+			"type":     "object",
+			"required": []string{"op", "path"},
+			"properties": map[string]any{
+				"op":    map[string]any{"enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  stringSchema(),
+				"from":  stringSchema(),
+				"value": map[string]any{},
+			},
+		},
+	}
+
+	problem := func(description, schema string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content":     map[string]any{"application/problem+json": map[string]any{"schema": ref(schema)}},
+		}
+	}
+	return envelope{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Greenlight API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"responses": map[string]any{
+				"Problem":           problem("An error, described as RFC 7807 problem details", "Problem"),
+				"ValidationProblem": problem("The request failed validation", "ValidationProblem"),
+			},
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKeyAuth": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}, nil
+}
+
+// The operation() method generates the OpenAPI operation object for an endpoint.
+func (g *schemaGenerator) operation(path string, op apiOperation) map[string]any {
+	o := map[string]any{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+	}
+
+	parameters := []any{}
+	for _, segment := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			parameters = append(parameters, map[string]any{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]any{"type": "integer", "format": "int64", "minimum": 1},
+			})
+		}
+	}
+	query := op.query
+	if op.sort != nil {
+		sortDefault := op.sortDefault
+		if sortDefault == "" {
+			sortDefault = "id"
+		}
+		query = append(query,
+			apiParameter{"page", map[string]any{"type": "integer", "minimum": 1, "maximum": data.MaxPage, "default": 1}, "The page of results to return"},
+			apiParameter{"page_size", map[string]any{"type": "integer", "minimum": 1, "maximum": data.MaxPageSize, "default": 20}, "The number of results on each page"},
+			apiParameter{"sort", map[string]any{"type": "string", "enum": op.sort, "default": sortDefault}, "The field to sort by, with a leading - for descending order"},
+		)
+	}
+	if op.fields != nil {
+		fields := jsonFields(reflect.TypeOf(op.fields))
+		query = append(query, apiParameter{"fields", stringSchema(), "Comma-separated list of the fields to include in the response, out of: " + strings.Join(fields, ", ")})
+	}
+	if op.expand != nil {
+		query = append(query, apiParameter{"expand", stringSchema(), "Comma-separated list of related resources to embed in the response, out of: " + strings.Join(op.expand, ", ")})
+	}
+	for _, param := range query {
+		parameters = append(parameters, map[string]any{
+			"name": param.name, "in": "query", "description": param.description, "schema": param.schema,
+		})
+	}
+	if op.idempotent {
+		parameters = append(parameters, map[string]any{
+			"name": "Idempotency-Key", "in": "header",
+			"description": "A unique key for the request. Retrying a request with the same key returns the original response instead of repeating the action.",
+			"schema":      map[string]any{"type": "string", "minLength": 1, "maxLength": 255},
+		})
+	}
+	if len(parameters) > 0 {
+		o["parameters"] = parameters
+	}
+
+	switch op.auth {
+	case "":
+		o["security"] = []any{}
+	default:
+		o["security"] = []any{
+			map[string]any{"bearerAuth": []string{}},
+			map[string]any{"apiKeyAuth": []string{}},
+		}
+		switch op.auth {
+		case "authenticated":
+			o["description"] = "Requires an authenticated user."
+		case "activated":
+			o["description"] = "Requires an authenticated and activated user."
+		default:
+			o["description"] = fmt.Sprintf("Requires the %s permission.", op.auth)
+			o["x-permission"] = op.auth
+		}
+	}
+
+	if op.body != nil {
+		content := map[string]any{}
+		if op.patch {
+			// Patches are applied to the resource's JSON representation, so a merge
+			// patch looks like the resource with every field optional.
+			schema := g.patchSchema(reflect.TypeOf(op.body))
+			content["application/merge-patch+json"] = map[string]any{"schema": schema}
+			content["application/json-patch+json"] = map[string]any{"schema": ref("JSONPatch")}
+			content["application/json"] = map[string]any{"schema": schema}
+		} else {
+			content["application/json"] = map[string]any{"schema": g.schema(reflect.TypeOf(op.body))}
+		}
+		o["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	responses := map[string]any{}
+	success := map[string]any{"description": http.StatusText(op.status)}
+	switch {
+	case op.status == http.StatusFound:
+		success["headers"] = map[string]any{"Location": map[string]any{"schema": map[string]any{"type": "string", "format": "uri"}}}
+	case op.response != nil:
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": g.envelopeSchema(op.response)}}
+	default:
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}}
+	}
+	responses[strconv.Itoa(op.status)] = success
+	if op.alternate != nil {
+		responses[strconv.Itoa(op.alternate.status)] = map[string]any{
+			"description": op.alternate.description,
+			"content":     map[string]any{"application/json": map[string]any{"schema": g.envelopeSchema(op.alternate.response)}},
+		}
+	}
+
+	statuses := append([]int{http.StatusTooManyRequests, http.StatusInternalServerError}, op.errors...)
+	if op.response != nil {
+		statuses = append(statuses, http.StatusNotAcceptable)
+	}
+	if strings.Contains(path, "/:") {
+		statuses = append(statuses, http.StatusNotFound)
+	}
+	if op.body != nil {
+		statuses = append(statuses, http.StatusBadRequest, http.StatusUnprocessableEntity)
+	}
+	if op.query != nil || op.sort != nil || op.fields != nil {
+		statuses = append(statuses, http.StatusUnprocessableEntity)
+	}
+	if op.patch {
+		statuses = append(statuses, http.StatusConflict, http.StatusUnsupportedMediaType)
+	}
+	if op.idempotent {
+		statuses = append(statuses, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+	if op.auth != "" {
+		statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	for _, status := range statuses {
+		switch status {
+		case op.status:
+		case http.StatusUnprocessableEntity:
+			responses["422"] = map[string]any{"$ref": "#/components/responses/ValidationProblem"}
+		default:
+			responses[strconv.Itoa(status)] = map[string]any{"$ref": "#/components/responses/Problem"}
+		}
+	}
+	o["responses"] = responses
+	return o
+}
+
+// The openAPIPath() helper converts a router path like /v1/movies/:id into the OpenAPI
+// form, /v1/movies/{id}.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func stringSchema() map[string]any {
+	return map[string]any{"type": "string"}
+}
+
+func dateTimeSchema() map[string]any {
+	return map[string]any{"type": "string", "format": "date-time"}
+}
+
+// Like readTime(), time query parameters accept a date as well as a timestamp.
+func timeParameterSchema() map[string]any {
+	return map[string]any{
+		"type":  "string",
+		"anyOf": []any{map[string]any{"format": "date-time"}, map[string]any{"format": "date"}},
+	}
+}
+
+// The schemaGenerator type converts Go types into JSON schemas. Named struct types are
+// added to the components of the document and referred to by name.
+type schemaGenerator struct {
+	schemas map[string]any
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	runtimeType = reflect.TypeOf(data.Runtime(0))
+)
+
+// The fields which are set by the server, and are marked read-only in the schemas.
+var readOnlyFields = []string{"id", "created_at", "updated_at", "version"}
+
+func (g *schemaGenerator) envelopeSchema(env envelope) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for key, value := range env {
+		properties[key] = g.schema(reflect.TypeOf(value))
+		required = append(required, key)
+	}
+	sort.Strings(required)
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return dateTimeSchema()
+	case runtimeType:
+		return map[string]any{"type": "string", "pattern": "^[0-9]+ mins$", "examples": []string{"102 mins"}}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return stringSchema()
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, exists := g.schemas[t.Name()]; !exists {
+			// Add a placeholder first, in case the type refers to itself.
+			g.schemas[t.Name()] = map[string]any{}
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return ref(t.Name())
+	}
+	return map[string]any{}
+}
+
+// The patchSchema() method generates the schema for a merge patch of a struct type,
+// which is added to the components as the type name followed by Patch. None of the
+// fields are required, and any field that the client can change may be null, to remove
+// it.
+func (g *schemaGenerator) patchSchema(t reflect.Type) map[string]any {
+	if t.Name() == "" {
+		panic("openapi: patch bodies must be named struct types")
+	}
+	name := t.Name() + "Patch"
+	if _, exists := g.schemas[name]; exists {
+		return ref(name)
+	}
+	schema := g.structSchema(t)
+	delete(schema, "required")
+	for key, property := range schema["properties"].(map[string]any) {
+		if property.(map[string]any)["readOnly"] == true {
+			continue
+		}
+		schema["properties"].(map[string]any)[key] = map[string]any{
+			"anyOf": []any{property, map[string]any{"type": "null"}},
+		}
+	}
+	g.schemas[name] = schema
+	return ref(name)
+}
+
+// The structSchema() method generates the schema for a struct type from its json and
+// validate tags.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := g.schema(field.Type)
+		if _, isRef := schema["$ref"]; !isRef {
+			applyValidateTag(schema, field.Tag.Get("validate"))
+		}
+		if strings.Contains(field.Tag.Get("validate"), "required") {
+			required = append(required, name)
+		}
+		for _, readOnly := range readOnlyFields {
+			if name == readOnly {
+				schema["readOnly"] = true
+			}
+		}
+		properties[name] = schema
+	}
+	schema := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// The applyValidateTag() helper adds the constraints from a validate struct tag to a
+// schema, where JSON Schema has an equivalent. Rules after dive apply to the items of
+// an array.
+func applyValidateTag(schema map[string]any, tag string) {
+	if tag == "" {
+		return
+	}
+	for i, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		n, _ := strconv.Atoi(param)
+		switch name {
+		case "dive":
+			if items, ok := schema["items"].(map[string]any); ok {
+				applyValidateTag(items, strings.Join(strings.Split(tag, ",")[i+1:], ","))
+			}
+			return
+		case "min", "max", "len":
+			keywords := map[string][2]string{
+				"string":  {"minLength", "maxLength"},
+				"array":   {"minItems", "maxItems"},
+				"integer": {"minimum", "maximum"},
+			}[fmt.Sprint(schema["type"])]
+			if keywords[0] == "" {
+				continue
+			}
+			if name != "max" {
+				schema[keywords[0]] = n
+			}
+			if name != "min" {
+				schema[keywords[1]] = n
+			}
+		case "unique":
+			schema["uniqueItems"] = true
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "email":
+			schema["format"] = "email"
+		case "required":
+			if schema["type"] == "string" {
+				schema["minLength"] = 1
+			}
+		}
+	}
+}
+
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, app.openAPI, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The requestValidator type checks requests against the parameters and request bodies
+// described in the OpenAPI document. It works on the document as it's served (decoded
+// from JSON), so that clients and the API always agree on what a valid request is.
+type requestValidator struct {
+	paths    map[string]map[string]any
+	schemas  map[string]any
+	patterns sync.Map
+}
+
+func newRequestValidator(spec envelope) (*requestValidator, error) {
+	js, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return &requestValidator{paths: doc.Paths, schemas: doc.Components.Schemas}, nil
+}
+
+// The validateRequest() middleware checks the query string and body of a request against
+// the OpenAPI document before the handler for the route runs, and sends a failed
+// validation response listing every problem if they don't match. It's opt-in, with the
+// -openapi-validate command-line flag, and is applied to each route by the routeTable.
+//
+// Requests which can't be checked (for example because the body isn't valid JSON, or
+// has an unsupported content type) are passed through to the handler, which sends the
+// usual error response.
+func (app *application) validateRequest(route string, next http.HandlerFunc) http.HandlerFunc {
+	if !app.config.openAPI.validate {
+		return next
+	}
+	method, path, _ := strings.Cut(route, " ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The document is generated after the routes are registered, so look up the
+		// operation when the request arrives.
+		op, ok := app.requestValidator.paths[openAPIPath(path)][strings.ToLower(method)].(map[string]any)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		v := validator.New()
+		app.requestValidator.checkQuery(v, op, r)
+
+		requestBody, _ := op["requestBody"].(map[string]any)
+		if content, ok := requestBody["content"].(map[string]any); ok {
+			// Read the body (with the same 1MB limit as readJSON()) and then replace it,
+			// so that the handler can read it as normal.
+			maxBytes := 1_048_576
+			r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxBytesError *http.MaxBytesError
+				if errors.As(err, &maxBytesError) {
+					app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+					return
+				}
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			app.requestValidator.checkBody(v, content, r.Header.Get("Content-Type"), body)
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// The checkQuery() method checks the query string parameters of a request. Parameters
+// which aren't in the document are ignored, as they always have been.
+func (rv *requestValidator) checkQuery(v *validator.Validator, op map[string]any, r *http.Request) {
+	parameters, _ := op["parameters"].([]any)
+	qs := r.URL.Query()
+	for _, p := range parameters {
+		param := p.(map[string]any)
+		name, _ := param["name"].(string)
+		if param["in"] != "query" || qs.Get(name) == "" {
+			continue
+		}
+		schema := rv.resolve(param["schema"])
+		s := qs.Get(name)
+
+		// Query string values are always strings, so convert the values of numeric and
+		// boolean parameters first, with the same errors as readInt() and readBool().
+		var value any = s
+		switch schema["type"] {
+		case "integer":
+			if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+				v.AddFieldError(name, validator.Format("integer").WithMessage("must be an integer value"))
+				continue
+			}
+			value = json.Number(s)
+		case "boolean":
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				v.AddFieldError(name, validator.Format("boolean").WithMessage("must be a boolean value"))
+				continue
+			}
+			value = b
+		}
+		rv.check(v, name, value, schema)
+	}
+}
+
+// The checkBody() method checks a request body against the schema for its content type.
+func (rv *requestValidator) checkBody(v *validator.Validator, content map[string]any, contentType string, body []byte) {
+	mediaType := "application/json"
+	if contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return
+		}
+	}
+	media, ok := content[mediaType].(map[string]any)
+	if !ok || len(bytes.TrimSpace(body)) == 0 {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value any
+	if dec.Decode(&value) != nil || dec.More() {
+		return
+	}
+	rv.check(v, "", value, rv.resolve(media["schema"]))
+}
+
+// The resolve() method follows a $ref to a schema in the components of the document.
+func (rv *requestValidator) resolve(schema any) map[string]any {
+	s, _ := schema.(map[string]any)
+	for {
+		ref, ok := s["$ref"].(string)
+		if !ok {
+			return s
+		}
+		s, _ = rv.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any)
+	}
+}
+
+// The check() method checks a value against a schema, recording any problems in the
+// Validator under the given field path. Only the schema keywords which the document
+// uses are supported.
+func (rv *requestValidator) check(v *validator.Validator, key string, value any, schema map[string]any) {
+	schema = rv.resolve(schema)
+
+	if alternatives, ok := schema["anyOf"].([]any); ok && !rv.checkAnyOf(v, key, value, alternatives) {
+		return
+	}
+
+	if typ, ok := schema["type"].(string); ok && !hasJSONType(value, typ) {
+		v.AddFieldError(rootKey(key), validator.Type(typ))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		permitted := false
+		for _, allowed := range enum {
+			permitted = permitted || fmt.Sprint(allowed) == fmt.Sprint(value)
+		}
+		if !permitted {
+			v.AddFieldError(rootKey(key), validator.OneOf(enum...))
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, exists := value[name.(string)]; !exists {
+				v.AddFieldError(fieldKey(key, name.(string)), validator.Required())
+			}
+		}
+		for name, item := range value {
+			if property, ok := properties[name]; ok {
+				rv.check(v, fieldKey(key, name), item, rv.resolve(property))
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					v.AddFieldError(fieldKey(key, name), validator.UnknownField())
+				}
+			case map[string]any:
+				rv.check(v, fieldKey(key, name), item, rv.resolve(additional))
+			}
+		}
+
+	case []any:
+		if min, ok := schemaInt(schema, "minItems"); ok && len(value) < min {
+			v.AddFieldError(rootKey(key), validator.MinItems(min))
+		}
+		if max, ok := schemaInt(schema, "maxItems"); ok && len(value) > max {
+			v.AddFieldError(rootKey(key), validator.MaxItems(max))
+		}
+		if schema["uniqueItems"] == true {
+			seen := make(map[string]bool, len(value))
+			for _, item := range value {
+				js, _ := json.Marshal(item)
+				if seen[string(js)] {
+					v.AddFieldError(rootKey(key), validator.UniqueItems())
+					break
+				}
+				seen[string(js)] = true
+			}
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				rv.check(v, validator.Index(rootKey(key), i), item, rv.resolve(items))
+			}
+		}
+
+	case string:
+		// JSON Schema counts the length of a string in characters, not bytes.
+		length := utf8.RuneCountInString(value)
+		if min, ok := schemaInt(schema, "minLength"); ok && length < min {
+			v.AddFieldError(rootKey(key), validator.MinLength(min).WithMessage(fmt.Sprintf("must be at least %d characters long", min)))
+		}
+		if max, ok := schemaInt(schema, "maxLength"); ok && length > max {
+			v.AddFieldError(rootKey(key), validator.MaxLength(max).WithMessage(fmt.Sprintf("must not be more than %d characters long", max)))
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !rv.pattern(pattern).MatchString(value) {
+			v.AddFieldError(rootKey(key), validator.Pattern(pattern))
+		}
+		if format, ok := schema["format"].(string); ok && !validFormat(format, value) {
+			v.AddFieldError(rootKey(key), formatError(format))
+		}
+
+	case json.Number:
+		n, err := value.Int64()
+		if err != nil {
+			break
+		}
+		if min, ok := schemaInt(schema, "minimum"); ok && n < int64(min) {
+			v.AddFieldError(rootKey(key), validator.Min(int64(min)))
+		}
+		if max, ok := schemaInt(schema, "maximum"); ok && n > int64(max) {
+			v.AddFieldError(rootKey(key), validator.Max(int64(max)))
+		}
+	}
+}
+
+// The checkAnyOf() method checks a value against a list of alternative schemas, returning
+// true if it matches one of them. If it doesn't, then the problems with the first
+// alternative that isn't just null are recorded, because the other alternative in the
+// document is always either null (for fields in a merge patch which can be removed) or
+// another format of the same value.
+func (rv *requestValidator) checkAnyOf(v *validator.Validator, key string, value any, alternatives []any) bool {
+	var first *validator.Validator
+	var formats []string
+	for _, alternative := range alternatives {
+		schema := rv.resolve(alternative)
+		attempt := validator.New()
+		rv.check(attempt, key, value, schema)
+		if attempt.Valid() {
+			return true
+		}
+		if format, ok := schema["format"].(string); ok {
+			formats = append(formats, format)
+		}
+		if first == nil && schema["type"] != "null" {
+			first = attempt
+		}
+	}
+	if len(formats) == len(alternatives) {
+		v.AddFieldError(rootKey(key), validator.Format(strings.Join(formats, " or ")))
+		return false
+	}
+	for field, errs := range first.Errors {
+		for _, err := range errs {
+			v.AddFieldError(field, err)
+		}
+	}
+	return false
+}
+
+func (rv *requestValidator) pattern(pattern string) *regexp.Regexp {
+	if rx, ok := rv.patterns.Load(pattern); ok {
+		return rx.(*regexp.Regexp)
+	}
+	rx := regexp.MustCompile(pattern)
+	rv.patterns.Store(pattern, rx)
+	return rx
+}
+
+// The hasJSONType() helper reports whether a decoded JSON value has the given JSON
+// Schema type.
+func hasJSONType(value any, typ string) bool {
+	switch value := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case string:
+		return typ == "string"
+	case json.Number:
+		if typ == "integer" {
+			_, err := value.Int64()
+			return err == nil
+		}
+		return typ == "number"
+	case []any:
+		return typ == "array"
+	case map[string]any:
+		return typ == "object"
+	}
+	return false
+}
+
+func validFormat(format, value string) bool {
+	switch format {
+	case "email":
+		return validator.Matches(value, validator.EmailRX)
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	}
+	return true
+}
+
+func formatError(format string) validator.Error {
+	switch format {
+	case "email":
+		return validator.Format("email").WithMessage("must be a valid email address")
+	case "date-time":
+		return validator.Format("timestamp").WithMessage("must be a RFC 3339 timestamp")
+	}
+	return validator.Format(format)
+}
+
+// The schemaInt() helper reads an integer keyword (such as maxLength) from a schema.
+func schemaInt(schema map[string]any, keyword string) (int, bool) {
+	n, ok := schema[keyword].(float64)
+	return int(n), ok
+}
+
+// The fieldKey() and rootKey() helpers build the field paths for errors, where the key
+// for the body itself is "body".
+func fieldKey(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return validator.Field(parent, name)
+}
+
+func rootKey(key string) string {
+	if key == "" {
+		return "body"
+	}
+	return key
+}
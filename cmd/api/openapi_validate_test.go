@@ -0,0 +1,57 @@
+package main
+
+import (
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net/http"
+	"testing"
+)
+
+func TestValidateStringLength(t *testing.T) {
+	rv := &requestValidator{}
+	schema := map[string]any{"type": "string", "minLength": float64(3), "maxLength": float64(5)}
+
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{"abc", ""},
+		{"ab", "min_length"},
+		{"abcdef", "max_length"},
+		// The lengths are in characters, so these are valid even though they're more
+		// than 5 bytes long.
+		{"äöü", ""},
+		{"日本語です", ""},
+		{"日本語ですね", "max_length"},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		rv.check(v, "name", tt.value, schema)
+		var got string
+		if errs := v.Errors["name"]; len(errs) > 0 {
+			got = errs[0].Code
+		}
+		if got != tt.wantErr {
+			t.Errorf("%q: got error %q; want %q", tt.value, got, tt.wantErr)
+		}
+	}
+}
+
+// TestValidateRequestAfterAccess checks that a client which isn't allowed to use a
+// route is told so, rather than what's wrong with its request.
+func TestValidateRequestAfterAccess(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, "-openapi-validate=true")
+	ts := newTestServer(t, app.routes())
+	reader := insertTestUser(t, app, "pa55word1234", "movies:read")
+	writer := insertTestUser(t, app, "pa55word1234", "movies:read", "movies:write")
+	invalid := map[string]any{"title": 42}
+
+	status, _ := ts.do(t, http.MethodPost, "/v1/movies", invalid, "Authorization", "Bearer "+authenticationToken(t, app, reader))
+	if status != http.StatusForbidden {
+		t.Errorf("got status %d without permission; want %d", status, http.StatusForbidden)
+	}
+	status, _ = ts.do(t, http.MethodPost, "/v1/movies", invalid, "Authorization", "Bearer "+authenticationToken(t, app, writer))
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d with permission; want %d", status, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/jsonpatch"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// The patch formats that the PATCH endpoints accept. A plain JSON body is treated as a
+// merge patch, which is how those endpoints have always behaved for fields which are
+// present in the body.
+var patchContentTypes = []string{"application/json", "application/merge-patch+json", "application/json-patch+json"}
+
+// errUnsupportedMediaType is returned by readPatch() if the request body isn't in one
+// of the patch formats.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// The readPatch() helper reads a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)
+// from the request body, depending on its Content-Type, and applies it to dst, which
+// should be a pointer to the record loaded from the database. The patch works on the
+// JSON representation of the record, so a merge patch can remove a field by setting it
+// to null, and a JSON patch can add and remove individual genres. Members removed by
+// the patch are left as zero values in dst, ready for validation.
+//
+// The fields listed in readOnly (such as "id" and "version") can't be changed by the
+// patch, although a JSON patch can still use them in test operations.
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, dst any, readOnly ...string) error {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedMediaType
+		}
+	}
+	if !validator.PermittedValue(mediaType, patchContentTypes...) {
+		return errUnsupportedMediaType
+	}
+
+	// Read the patch, with the same 1MB limit as readJSON().
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return err
+	}
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	doc, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	var patched []byte
+	switch mediaType {
+	case "application/json-patch+json":
+		patched, err = jsonpatch.Apply(doc, patch)
+	default:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	}
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return err
+		}
+		return fmt.Errorf("body contains an invalid patch: %v", err)
+	}
+
+	err = checkReadOnly(doc, patched, readOnly)
+	if err != nil {
+		return err
+	}
+
+	// Clear the fields of the record which appear in JSON, so that any members removed
+	// by the patch end up as zero values, and then decode the patched document into
+	// it. This also catches unknown keys and values of the wrong type.
+	value := reflect.ValueOf(dst).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.IsExported() && field.Tag.Get("json") != "-" {
+			value.Field(i).SetZero()
+		}
+	}
+	return app.decodeJSON(bytes.NewReader(patched), dst)
+}
+
+// The checkReadOnly() helper returns an error if the patched document has a different
+// value for any of the read-only fields.
+func checkReadOnly(doc, patched []byte, readOnly []string) error {
+	var before, after map[string]json.RawMessage
+	err := json.Unmarshal(doc, &before)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(patched, &after)
+	if err != nil {
+		return errors.New("body must patch the record to a JSON object")
+	}
+	for _, key := range readOnly {
+		if !bytes.Equal(compactJSON(before[key]), compactJSON(after[key])) {
+			return fmt.Errorf("body must not change the %s field", key)
+		}
+	}
+	return nil
+}
+
+func compactJSON(js json.RawMessage) []byte {
+	var buf bytes.Buffer
+	if json.Compact(&buf, js) != nil {
+		return js
+	}
+	return buf.Bytes()
+}
+
+// The patchErrorResponse() helper sends the response for an error from readPatch().
+func (app *application) patchErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		w.Header().Set("Accept-Patch", strings.Join(patchContentTypes, ", "))
+		app.unsupportedMediaTypeResponse(w, r, patchContentTypes)
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		app.patchTestFailedResponse(w, r)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/mailer"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"os"
+	"strings"
+)
+
+// The settings which can be changed without restarting the server, by editing the
+// config file and sending the process a SIGHUP signal. Changes to any other settings
+// only take effect after a restart.
+var reloadableSettings = []string{
+	"limiter-enabled", "limiter-rps", "limiter-burst",
+	"log-level",
+	"cors-trusted-origins",
+	"smtp-host", "smtp-port", "smtp-username", "smtp-password", "smtp-sender",
+}
+
+// The currentConfig() method returns the latest configuration. It only differs from
+// app.config in the reloadable settings, so it's only needed to read those.
+func (app *application) currentConfig() *config {
+	return app.reloadable.Load()
+}
+
+// The reloadConfig() method loads the configuration again from the same sources as at
+// startup, and if it's valid, switches to the new values of the reloadable settings.
+// It logs which settings changed, and any changes which need a restart.
+func (app *application) reloadConfig() error {
+	cfg, loader, err := loadConfig(os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		return fmt.Errorf("not reloading the configuration, as it's invalid:\n%w", err)
+	}
+
+	var changed, ignored []string
+	loader.FlagSet.VisitAll(func(f *flag.Flag) {
+		reloadable := validator.PermittedValue(f.Name, reloadableSettings...)
+		// The reloadable settings are compared with the last configuration loaded, and
+		// the others with the configuration in effect, which is the one at startup.
+		previous := app.startupSettings
+		if reloadable {
+			previous = app.lastSettings
+		}
+		if f.Value.String() == previous.FlagSet.Lookup(f.Name).Value.String() {
+			return
+		}
+		switch {
+		case !reloadable:
+			ignored = append(ignored, f.Name)
+		case validator.PermittedValue(f.Name, secretSettings...):
+			changed = append(changed, f.Name)
+		default:
+			changed = append(changed, fmt.Sprintf("%s: %q -> %q", f.Name, previous.Redacted(f.Name), loader.Redacted(f.Name)))
+		}
+	})
+
+	// Log the changes before applying them, in case the new log level hides the entry.
+	properties := map[string]string{"changed": strings.Join(changed, ", ")}
+	if len(ignored) > 0 {
+		properties["restart_required"] = strings.Join(ignored, ", ")
+	}
+	app.logger.PrintInfo("reloading configuration", properties)
+
+	app.applyReloadable(cfg)
+	app.lastSettings = loader
+	return nil
+}
+
+// The applyReloadable() method switches to the reloadable settings from cfg, keeping
+// the rest of the configuration as it is. Each of the values is swapped atomically, so
+// requests see either the old settings or the new ones. The mailer is replaced rather
+// than changed, as it may be in use by a background goroutine.
+func (app *application) applyReloadable(cfg config) {
+	next := app.config
+	if current := app.currentConfig(); current != nil {
+		next = *current
+	}
+	next.limiter = cfg.limiter
+	next.logLevel = cfg.logLevel
+	next.cors = cfg.cors
+	next.smtp = cfg.smtp
+
+	m := mailer.New(next.smtp.host, next.smtp.port, next.smtp.username, next.smtp.password, next.smtp.sender)
+	app.mailer.Store(&m)
+	app.logger.SetLevel(next.logLevel)
+	app.reloadable.Store(&next)
+}
@@ -7,8 +7,38 @@ import (
 
 // Update the routes() method to return a http.Handler instead of a *httprouter.Router.
 func (app *application) routes() http.Handler {
-	// router instance
-	router := httprouter.New()
+	router := app.router()
+
+	// Generate the OpenAPI document from the registered routes. A route without an
+	// entry in apiOperations is a programming error, so we panic rather than serve an
+	// incomplete document.
+	spec, err := buildOpenAPI(router)
+	if err != nil {
+		panic(err)
+	}
+	app.openAPI = spec
+	app.requestValidator, err = newRequestValidator(spec)
+	if err != nil {
+		panic(err)
+	}
+
+	// Wrap the router with the rateLimit() middleware.
+	// Use the authenticate() middleware on all requests.
+	// Resolve the real client IP address before rate limiting.
+	// Assign a request ID before anything else, so that every response has one.
+	// Compress responses outside of recoverPanic(), so that its 500 responses are
+	// compressed like any other.
+	// Handle CORS before rate limiting, so that preflight requests aren't counted.
+	return app.requestID(app.compress(app.recoverPanic(app.realIP(app.enableCORS(app.rateLimit(app.authenticate(router)))))))
+}
+
+// The router() method registers the routes. Routes which need more than anonymous
+// access are registered with Require(), along with the access that they need: a
+// permission code, or "authenticated" or "activated" for any (activated) user.
+func (app *application) router() *routeTable {
+	// router instance, which records the registered routes for the OpenAPI document and
+	// (if enabled) validates requests against it before they reach the handlers
+	router := &routeTable{Router: httprouter.New(), middleware: app.validateRequest, require: app.requireAccess}
 
 	// convert our own helpers to http.Handler 404 code error using adapter SDP beyba xD
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
@@ -18,35 +48,74 @@ func (app *application) routes() http.Handler {
 
 	// router for healthcheck
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.openAPIHandler)
 
 	// relevant methods
 	// Add the route for the GET /v1/movies endpoint.
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.Require(http.MethodGet, "/v1/movies", "movies:read", app.listMoviesHandler)
+	// Creating records can be safely retried by sending an Idempotency-Key header.
+	router.Require(http.MethodPost, "/v1/movies", "movies:write", app.idempotent(app.createMovieHandler))
+	router.Require(http.MethodGet, "/v1/movies/:id", "movies:read", app.showMovieHandler)
 	// Add the route for the PUT /v1/movies/:id endpoint.
 	// Require a PATCH request, rather than PUT.
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	router.Require(http.MethodPatch, "/v1/movies/:id", "movies:write", app.updateMovieHandler)
 	// Add the route for the DELETE /v1/movies/:id endpoint.
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.Require(http.MethodDelete, "/v1/movies/:id", "movies:write", app.deleteMovieHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/modules", app.requirePermission("movies:read", app.listModulesInfoHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/modules", app.requirePermission("movies:write", app.createModuleInfoHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/modules/:id", app.requirePermission("movies:read", app.getModuleInfoHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/modules/:id", app.requirePermission("movies:write", app.editModuleInfoHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/modules/:id", app.requirePermission("movies:write", app.deleteModuleInfoHandler))
+	router.Require(http.MethodGet, "/v1/modules", "movies:read", app.listModulesInfoHandler)
+	router.Require(http.MethodPost, "/v1/modules", "movies:write", app.idempotent(app.createModuleInfoHandler))
+	router.Require(http.MethodGet, "/v1/modules/:id", "movies:read", app.getModuleInfoHandler)
+	router.Require(http.MethodPatch, "/v1/modules/:id", "movies:write", app.editModuleInfoHandler)
+	router.Require(http.MethodDelete, "/v1/modules/:id", "movies:write", app.deleteModuleInfoHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/departments", app.requirePermission("movies:write", app.createDepartmentInfoHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/departments/:id", app.requirePermission("movies:read", app.getDepartmentInfoHandler))
+	router.Require(http.MethodPost, "/v1/departments", "movies:write", app.idempotent(app.createDepartmentInfoHandler))
+	router.Require(http.MethodGet, "/v1/departments/:id", "movies:read", app.getDepartmentInfoHandler)
 
 	// Add the route for the POST /v1/users endpoint.
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	// Add the route for the PUT /v1/users/activated endpoint.
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.resetPasswordHandler)
 	// Add the route for the POST /v1/tokens/authentication endpoint.
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/2fa", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
-	// Wrap the router with the rateLimit() middleware.
-	// Use the authenticate() middleware on all requests.
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	// Single sign-on via an OpenID Connect identity provider.
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/callback", app.oidcCallbackHandler)
+
+	// Self-service endpoints for the current user's own account.
+	router.Require(http.MethodGet, "/v1/users/me", "authenticated", app.showCurrentUserHandler)
+	router.Require(http.MethodPatch, "/v1/users/me", "authenticated", app.updateCurrentUserHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodDelete, "/v1/users/me", "authenticated", app.deleteCurrentUserHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodPost, "/v1/users/me/email", "authenticated", app.requestEmailChangeHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodPut, "/v1/users/me/email", "authenticated", app.confirmEmailChangeHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodPut, "/v1/users/me/password", "authenticated", app.changePasswordHandler, app.disallowAPIKey, app.disallowImpersonation)
+
+	// Two-factor authentication management for the current user. Like the other
+	// account management endpoints, these can't be used with an API key or while
+	// impersonating the user.
+	router.Require(http.MethodPost, "/v1/users/me/2fa", "activated", app.enrollTwoFactorHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodPost, "/v1/users/me/2fa/verify", "activated", app.verifyTwoFactorHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodDelete, "/v1/users/me/2fa", "activated", app.disableTwoFactorHandler, app.disallowAPIKey, app.disallowImpersonation)
+
+	// API keys for service-to-service access, owned by the current user. API keys
+	// can't be used to create or revoke API keys, otherwise a leaked key could be used
+	// to mint new ones which outlive its revocation, or to revoke the owner's other keys.
+	router.Require(http.MethodGet, "/v1/users/me/api-keys", "activated", app.listAPIKeysHandler)
+	router.Require(http.MethodPost, "/v1/users/me/api-keys", "activated", app.createAPIKeyHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodDelete, "/v1/users/me/api-keys/:id", "activated", app.deleteAPIKeyHandler, app.disallowAPIKey, app.disallowImpersonation)
+
+	// Administrator endpoints for managing user accounts.
+	router.Require(http.MethodGet, "/v1/admin/users", "admin:users", app.listUsersHandler)
+	router.Require(http.MethodGet, "/v1/admin/users/:id", "admin:users", app.showUserHandler)
+	router.Require(http.MethodPatch, "/v1/admin/users/:id", "admin:users", app.updateUserHandler)
+	router.Require(http.MethodDelete, "/v1/admin/users/:id", "admin:users", app.deleteUserHandler)
+	router.Require(http.MethodDelete, "/v1/admin/users/:id/lockout", "admin:users", app.unlockUserHandler)
+	router.Require(http.MethodPost, "/v1/admin/users/:id/impersonation", "admin:users", app.impersonateUserHandler, app.disallowAPIKey, app.disallowImpersonation)
+	router.Require(http.MethodGet, "/v1/admin/jobs", "admin:users", app.listJobsHandler)
+	router.Require(http.MethodGet, "/v1/admin/jobs/runs", "admin:users", app.listJobRunsHandler)
+
+	return router
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestRoutesDocumented checks that the routes and apiOperations describe the same
+// endpoints, with the same access. routes() panics if they don't, but this reports
+// every difference at once.
+func TestRoutesDocumented(t *testing.T) {
+	app := newTestApplication(t, nil)
+	router := app.router()
+
+	registered := make(map[string]bool)
+	for _, route := range router.routes {
+		registered[route] = true
+		op, ok := apiOperations[route]
+		if !ok {
+			t.Errorf("%s: registered but missing from apiOperations", route)
+			continue
+		}
+		if access := router.access[route]; op.auth != access {
+			t.Errorf("%s: requires %q, but apiOperations says %q", route, access, op.auth)
+		}
+	}
+	for route := range apiOperations {
+		if !registered[route] {
+			t.Errorf("%s: in apiOperations but not registered", route)
+		}
+	}
+}
+
+// TestRoutesRequireAuthentication checks that every route which apiOperations says
+// needs access refuses anonymous requests, with request validation enabled so that
+// it's checked too.
+func TestRoutesRequireAuthentication(t *testing.T) {
+	app := newTestApplication(t, nil, "-openapi-validate=true")
+	ts := newTestServer(t, app.routes())
+
+	for route, op := range apiOperations {
+		if op.auth == "" {
+			continue
+		}
+		method, path, _ := strings.Cut(route, " ")
+		path = strings.ReplaceAll(path, ":id", "1")
+		status, _ := ts.do(t, method, path, map[string]any{})
+		if status != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d for an anonymous request; want %d", route, status, http.StatusUnauthorized)
+		}
+	}
+}
+
+// TestOperationStatuses checks that apiOperations only lists error statuses as errors,
+// and that a login's 202 response is documented as a success with its token.
+func TestOperationStatuses(t *testing.T) {
+	for route, op := range apiOperations {
+		for _, status := range op.errors {
+			if status < 400 {
+				t.Errorf("%s: %d is listed as an error status", route, status)
+			}
+		}
+	}
+
+	g := &schemaGenerator{schemas: make(map[string]any)}
+	for _, route := range []string{"POST /v1/tokens/authentication", "GET /v1/oidc/callback"} {
+		_, path, _ := strings.Cut(route, " ")
+		responses := g.operation(path, apiOperations[route])["responses"].(map[string]any)
+		accepted, ok := responses["202"].(map[string]any)
+		if !ok || accepted["content"] == nil {
+			t.Errorf("%s: got 202 response %v; want the 2fa_pending_token envelope", route, responses["202"])
+		}
+	}
+}
@@ -25,6 +25,36 @@ func (app *application) serve() error {
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
 
+	// Start the scheduler for the background jobs, if they're enabled. It's stopped by
+	// cancelling backgroundCtx when the server shuts down, and like the other background
+	// goroutines, we wait for it to finish before exiting.
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if app.config.jobs.enabled {
+		app.background(func() {
+			app.scheduler.Run(backgroundCtx)
+		})
+	}
+
+	// Reload the configuration whenever we receive a SIGHUP signal. If the new
+	// configuration is invalid, we log the problems and carry on with the old one.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for {
+			select {
+			case <-reload:
+				err := app.reloadConfig()
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"signal": "SIGHUP"})
+				}
+			case <-backgroundCtx.Done():
+				return
+			}
+		}
+	}()
+
 	// Start a background goroutine.
 	go func() {
 		// Create a quit channel which carries os.Signal values.
@@ -61,6 +91,10 @@ func (app *application) serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		// Stop the scheduler (letting any job which is running finish first) and the
+		// configuration reloads.
+		stopBackground()
+
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
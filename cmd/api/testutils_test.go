@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/jsonlog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The tests which need a database use the one given by the GREENLIGHT_TEST_DB_DSN
+// environment variable, and are skipped if it isn't set. The migrations are applied to
+// it, and the tests create their own users with unique email addresses, so it can be
+// shared between runs (but shouldn't be used for anything else).
+const testDBEnv = "GREENLIGHT_TEST_DB_DSN"
+
+// The newTestDB() helper returns a connection pool for the test database, skipping the
+// test if there isn't one.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv(testDBEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDBEnv)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := newMigrator(db, jsonlog.New(io.Discard, jsonlog.LevelOff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = migrator.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every test request comes from 127.0.0.1, so clear any failed logins recorded
+	// against it by earlier tests.
+	_, err = db.Exec(`DELETE FROM login_attempts WHERE key = $1`, ipLockoutKey("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// The newTestApplication() helper returns an application with the default settings,
+// changed by the given command-line flags, which doesn't log anything. If db is nil,
+// the models use a connection pool which never connects, which is enough for tests
+// which don't reach the database.
+func newTestApplication(t *testing.T, db *sql.DB, args ...string) *application {
+	t.Helper()
+	args = append([]string{"-db-dsn=postgres://test", "-limiter-enabled=false", "-log-level=off"}, args...)
+	cfg, loader, err := loadConfig(args, flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db == nil {
+		db, err = sql.Open("postgres", cfg.db.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+	}
+	passwordPolicy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{
+		config:          cfg,
+		startupSettings: loader,
+		lastSettings:    loader,
+		logger:          jsonlog.New(io.Discard, jsonlog.LevelOff),
+		models:          data.NewModels(db),
+		passwordPolicy:  passwordPolicy,
+	}
+	app.applyReloadable(cfg)
+	app.scheduler = app.newScheduler(db)
+	// Wait for any emails being sent in the background, so that they don't outlive
+	// the test.
+	t.Cleanup(app.wg.Wait)
+	return app
+}
+
+// The testServer type wraps a httptest.Server serving the application's routes.
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// The do() method sends a request with an optional JSON body and returns the response
+// status and decoded JSON body. Headers are given as name/value pairs.
+func (ts *testServer) do(t *testing.T, method, path string, body any, headers ...string) (int, map[string]any) {
+	t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(js)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var resBody map[string]any
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > 0 && strings.Contains(res.Header.Get("Content-Type"), "json") {
+		err = json.Unmarshal(b, &resBody)
+		if err != nil {
+			t.Fatalf("decoding %s %s response: %v", method, path, err)
+		}
+	}
+	return res.StatusCode, resBody
+}
+
+var testUserCount atomic.Int64
+
+// The testEmail() helper returns an email address which no other test user has.
+func testEmail(t *testing.T) string {
+	name := strings.NewReplacer("/", "-", " ", "-").Replace(strings.ToLower(t.Name()))
+	return fmt.Sprintf("%s-%d-%d@example.com", name, time.Now().UnixNano(), testUserCount.Add(1))
+}
+
+// The insertTestUser() helper creates an activated user with the given password and
+// permissions, deleting it again at the end of the test.
+func insertTestUser(t *testing.T, app *application, password string, permissions ...string) *data.User {
+	t.Helper()
+	user := &data.User{Name: "Test User", Email: testEmail(t), Activated: true}
+	err := user.Password.Set(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.models.Users.Delete(user.ID) })
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// The authenticationToken() helper returns a new authentication token for the user.
+func authenticationToken(t *testing.T, app *application, user *data.User) string {
+	t.Helper()
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Plaintext
+}
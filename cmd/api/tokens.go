@@ -27,6 +27,18 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Check that the client IP address isn't currently locked out or being made to
+	// wait after earlier failed attempts.
+	ipKey := ipLockoutKey(app.contextGetClientIP(r).String())
+	retryAfter, err := app.loginRetryAfter(ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
@@ -34,12 +46,23 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.unknownEmailLogin(w, r, ipKey, input.Email)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	// Likewise check that the user account isn't locked out.
+	accountKey := accountLockoutKey(user.ID)
+	retryAfter, err = app.loginRetryAfter(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
 	// Check if the provided password matches the actual password for the user.
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
@@ -48,10 +71,51 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
 	// helper again and return.
+	// Before that, we record the failure against both the client IP address and the
+	// user account, and email the user if their account has just been locked.
 	if !match {
+		_, err = app.recordLoginFailure(ipKey, app.config.lockout.ipAttempts)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		locked, err := app.recordLoginFailure(accountKey, app.config.lockout.accountAttempts)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if locked {
+			app.sendLockoutEmail(user)
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
+	// Now that we have the correct plaintext password, take the opportunity to upgrade
+	// the stored hash if it was created by an older algorithm or with outdated
+	// settings. A failure here shouldn't stop the user from logging in, so we just log
+	// it and try again next time.
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.Users.Update(user)
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+	// If the user has two-factor authentication enabled, then the password alone isn't
+	// enough, and the client is sent a 2fa-pending token instead. Note that we don't
+	// clear the failed attempts for the account yet, as they also cover guessed codes.
+	if app.requireSecondFactor(w, r, user) {
+		return
+	}
+	// The password is correct, so clear any failed attempts recorded against the user
+	// account.
+	err = app.models.LoginAttempts.Reset(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
 	// expiry time and the scope 'authentication'.
 	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
@@ -61,7 +125,120 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 	// Encode the token to JSON and send it in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The unknownEmailLogin() helper responds to a login with an email address which
+// doesn't have an account. The failure is counted against the email address as if it
+// were an account, so the client is made to wait and then locked out in just the
+// same way, and can't tell from the responses whether the account exists.
+func (app *application) unknownEmailLogin(w http.ResponseWriter, r *http.Request, ipKey, email string) {
+	emailKey := emailLockoutKey(email)
+	retryAfter, err := app.loginRetryAfter(emailKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+	_, err = app.recordLoginFailure(ipKey, app.config.lockout.ipAttempts)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	_, err = app.recordLoginFailure(emailKey, app.config.lockout.accountAttempts)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.invalidCredentialsResponse(w, r)
+}
+
+// The requireSecondFactor() helper checks whether the user has two-factor
+// authentication enabled, in which case a first factor (like a password or a single
+// sign-on login) isn't enough to log in. Instead we send a short-lived 2fa-pending
+// token, which the client must exchange along with a valid code at POST
+// /v1/tokens/2fa. It returns true if it has sent a response, in which case the caller
+// must not issue an authentication token.
+func (app *application) requireSecondFactor(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	if twoFactor == nil || !twoFactor.Enabled {
+		return false
+	}
+	token, err := app.models.Tokens.New(user.ID, twoFactorPendingTTL, data.ScopeTwoFactorPending)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"2fa_pending_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+	return true
+}
+
+// The createPasswordResetTokenHandler() emails a password-reset token to a user who
+// has forgotten their password, or who never had one because their account was
+// provisioned by single sign-on. The token can be used to set a new password, or in
+// place of the current password when changing it or deleting the account. The
+// response is the same whether or not the email address has an account, so that it
+// can't be used to find out which accounts exist.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	env := envelope{"message": "if the email address has an account, an email will be sent to it containing password reset instructions"}
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Accounts which haven't been activated need to be activated with the token from
+	// the welcome email first, so they don't get a reset token.
+	if user.Activated {
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+			err := app.mailer.Load().Send(user.Email, "password_reset_request.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestLoginLockoutUnknownEmail checks that failed logins with an email address which
+// has no account get the same responses as those for a real account, so that they
+// can't be used to find out which accounts exist.
+func TestLoginLockoutUnknownEmail(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db, "-lockout-account-attempts=2", "-lockout-ip-attempts=100", "-lockout-delay=0s")
+	ts := newTestServer(t, app.routes())
+	user := insertTestUser(t, app, "pa55word1234")
+	unknown := testEmail(t)
+	t.Cleanup(func() {
+		app.models.LoginAttempts.Reset(accountLockoutKey(user.ID))
+		app.models.LoginAttempts.Reset(emailLockoutKey(unknown))
+		app.models.LoginAttempts.Reset(ipLockoutKey("127.0.0.1"))
+	})
+
+	statuses := func(email string) string {
+		var got []int
+		for i := 0; i < 3; i++ {
+			status, _ := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]any{"email": email, "password": "wrongpa55word"})
+			got = append(got, status)
+		}
+		return fmt.Sprint(got)
+	}
+	want := fmt.Sprint([]int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests})
+	if got := statuses(user.Email); got != want {
+		t.Errorf("got statuses %s for an account; want %s", got, want)
+	}
+	if got := statuses(unknown); got != want {
+		t.Errorf("got statuses %s for an unknown email address; want %s", got, want)
+	}
+}
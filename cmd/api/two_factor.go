@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/totp"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net/http"
+	"time"
+)
+
+// The issuer name shown in authenticator apps.
+const totpIssuer = "Greenlight"
+
+// How long a 2fa-pending token can be exchanged for an authentication token.
+const twoFactorPendingTTL = 5 * time.Minute
+
+// The checkTwoFactorCode() helper checks a code provided by a user against their
+// two-factor configuration. Six-digit codes are treated as TOTP codes (allowing one
+// time step of clock drift either way, and rejecting codes that have already been
+// used), and anything else is treated as a one-time recovery code.
+func (app *application) checkTwoFactorCode(twoFactor *data.TwoFactor, code string) (bool, error) {
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(twoFactor.Secret, code, time.Now(), 1)
+		if !ok {
+			return false, nil
+		}
+		err := app.models.TwoFactor.UseStep(twoFactor.UserID, step)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return app.models.TwoFactor.UseRecoveryCode(twoFactor.UserID, code)
+}
+
+// The enrollTwoFactorHandler() starts two-factor enrollment for the current user by
+// generating a new secret. Two-factor authentication isn't enabled until a code from
+// the authenticator app has been verified.
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	twoFactor := &data.TwoFactor{
+		UserID: user.ID,
+		Secret: secret,
+	}
+	err = app.models.TwoFactor.Enroll(twoFactor)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("two_factor", "is already enabled for this account")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelope{
+		"two_factor": map[string]any{
+			"secret":      totp.EncodeSecret(secret),
+			"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+			"enabled":     twoFactor.Enabled,
+		},
+	}
+	err = app.writeResponse(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The verifyTwoFactorHandler() completes enrollment. If the code matches the pending
+// secret, two-factor authentication is enabled and a set of recovery codes is
+// returned. This is the only time that the recovery codes are shown.
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTwoFactorCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("two_factor", "enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if twoFactor.Enabled {
+		v.AddError("two_factor", "is already enabled for this account")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Only TOTP codes are accepted here, as recovery codes haven't been issued yet.
+	step, ok := totp.Validate(twoFactor.Secret, input.Code, time.Now(), 1)
+	if !ok {
+		v.AddError("code", "invalid two-factor code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.TwoFactor.UseStep(user.ID, step)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	codes, err := app.models.TwoFactor.Enable(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("two_factor", "is already enabled for this account")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The disableTwoFactorHandler() turns off two-factor authentication for the current
+// user. A valid TOTP or recovery code is required, so that a stolen authentication
+// token alone isn't enough to remove the protection.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTwoFactorCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// A pending enrollment can be cancelled without a code, as it was never enabled.
+	if twoFactor.Enabled {
+		ok, err := app.checkTwoFactorCode(twoFactor, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			v.AddError("code", "invalid two-factor code")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+	err = app.models.TwoFactor.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createTwoFactorAuthenticationTokenHandler() exchanges a 2fa-pending token and a
+// valid TOTP or recovery code for a normal authentication token.
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	data.ValidateTwoFactorCode(v, input.Code)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactorPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Guessing codes counts towards the same account lockout as guessing passwords.
+	accountKey := accountLockoutKey(user.ID)
+	retryAfter, err := app.loginRetryAfter(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	ok, err := app.checkTwoFactorCode(twoFactor, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		locked, err := app.recordLoginFailure(accountKey, app.config.lockout.accountAttempts)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if locked {
+			app.sendLockoutEmail(user)
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	// The pending token has done its job, so delete it along with any failed attempts
+	// and then issue the authentication token.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactorPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.LoginAttempts.Reset(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
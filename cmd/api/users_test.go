@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestReauthenticateWithToken checks that a user who doesn't know their password, such
+// as one provisioned by single sign-on, can change it or delete their account with a
+// password-reset token instead, and that the token must be their own.
+func TestReauthenticateWithToken(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+
+	resetToken := func(user *data.User) string {
+		token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopePasswordReset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.Plaintext
+	}
+	newPassword := "quiet-lantern-orchard-52"
+
+	t.Run("change password", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		err := user.Password.SetRandom()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = app.models.Users.Update(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth := []string{"Authorization", "Bearer " + authenticationToken(t, app, user)}
+		token := resetToken(user)
+
+		status, _ := ts.do(t, http.MethodPut, "/v1/users/me/password", map[string]any{"token": token, "password": newPassword}, auth...)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d; want %d", status, http.StatusOK)
+		}
+		status, _ = ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]any{"email": user.Email, "password": newPassword})
+		if status != http.StatusCreated {
+			t.Errorf("got status %d logging in with the new password; want %d", status, http.StatusCreated)
+		}
+		// The token can't be used again.
+		auth = []string{"Authorization", "Bearer " + authenticationToken(t, app, user)}
+		status, _ = ts.do(t, http.MethodPut, "/v1/users/me/password", map[string]any{"token": token, "password": newPassword}, auth...)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d reusing the token; want %d", status, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("delete account", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		auth := []string{"Authorization", "Bearer " + authenticationToken(t, app, user)}
+		status, _ := ts.do(t, http.MethodDelete, "/v1/users/me", map[string]any{"token": resetToken(user)}, auth...)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d; want %d", status, http.StatusOK)
+		}
+		_, err := app.models.Users.Get(user.ID)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("got error %v getting the deleted user; want %v", err, data.ErrRecordNotFound)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		user := insertTestUser(t, app, "pa55word1234")
+		other := insertTestUser(t, app, "pa55word1234")
+		auth := []string{"Authorization", "Bearer " + authenticationToken(t, app, user)}
+		tests := []struct {
+			name string
+			body map[string]any
+		}{
+			{"neither", map[string]any{}},
+			{"both", map[string]any{"current_password": "pa55word1234", "token": resetToken(user)}},
+			{"another user's token", map[string]any{"token": resetToken(other)}},
+			{"malformed token", map[string]any{"token": "abc"}},
+			{"wrong password", map[string]any{"current_password": "wrongpa55word"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				status, _ := ts.do(t, http.MethodDelete, "/v1/users/me", tt.body, auth...)
+				if status != http.StatusUnprocessableEntity {
+					t.Errorf("got status %d; want %d", status, http.StatusUnprocessableEntity)
+				}
+			})
+		}
+	})
+}
+
+// TestCreatePasswordResetToken checks that asking for a password-reset token gets the
+// same response whether or not the email address has an account.
+func TestCreatePasswordResetToken(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+	user := insertTestUser(t, app, "pa55word1234")
+
+	known, knownBody := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", map[string]any{"email": user.Email})
+	unknown, unknownBody := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", map[string]any{"email": testEmail(t)})
+	if known != http.StatusAccepted || unknown != http.StatusAccepted {
+		t.Fatalf("got statuses %d and %d; want %d", known, unknown, http.StatusAccepted)
+	}
+	if knownBody["message"] != unknownBody["message"] {
+		t.Errorf("got messages %q and %q; want them to be the same", knownBody["message"], unknownBody["message"])
+	}
+	var tokens int
+	err := db.QueryRow(`SELECT count(*) FROM tokens WHERE user_id = $1 AND scope = $2`, user.ID, data.ScopePasswordReset).Scan(&tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 1 {
+		t.Errorf("got %d password-reset tokens; want 1", tokens)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"strconv"
+	"strings"
+)
+
+// The parseID() helper parses the ID argument of a show or delete command.
+func parseID(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, errUsage
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid id %q", args[0])
+	}
+	return id, nil
+}
+
+// The notFound() helper gives a friendlier message for ErrRecordNotFound.
+func notFound(err error, resource string, id int64) error {
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("%s %d not found", resource, id)
+	}
+	return err
+}
+
+var movieHeaders = []string{"ID", "TITLE", "YEAR", "RUNTIME", "GENRES", "VERSION"}
+
+func movieRow(movie *data.Movie) []string {
+	return []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.Itoa(int(movie.Year)),
+		fmt.Sprintf("%d mins", movie.Runtime),
+		strings.Join(movie.Genres, ","),
+		strconv.Itoa(int(movie.Version)),
+	}
+}
+
+func moviesList(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	var filters data.Filters
+	listFlags(fs, &filters, data.MovieSortSafelist)
+	title := fs.String("title", "", "Full-text search on the movie title")
+	genres := fs.String("genres", "", "Comma-separated list of genres which the movies must all have")
+	return func(c *ctl, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+		if err := validateFilters(filters); err != nil {
+			return err
+		}
+		genreList := []string{}
+		if *genres != "" {
+			genreList = strings.Split(*genres, ",")
+		}
+		movies, metadata, err := c.models.Movies.GetAll(*title, genreList, filters)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, len(movies))
+		for i, movie := range movies {
+			rows[i] = movieRow(movie)
+		}
+		return c.print(map[string]any{"movies": movies, "metadata": metadata}, movieHeaders, rows)
+	}
+}
+
+func moviesShow(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+		movie, err := c.models.Movies.Get(id)
+		if err != nil {
+			return notFound(err, "movie", id)
+		}
+		return c.print(map[string]any{"movie": movie}, movieHeaders, [][]string{movieRow(movie)})
+	}
+}
+
+func moviesDelete(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+		err = c.models.Movies.Delete(id)
+		if err != nil {
+			return notFound(err, "movie", id)
+		}
+		return c.printMessage("movie %d deleted", id)
+	}
+}
+
+var moduleHeaders = []string{"ID", "NAME", "DURATION", "EXAM TYPE", "VERSION"}
+
+func moduleRow(module *data.Module_info) []string {
+	return []string{
+		strconv.FormatInt(module.ID, 10),
+		module.ModuleName,
+		fmt.Sprintf("%d mins", module.ModuleDuration),
+		module.ExamType,
+		strconv.Itoa(int(module.Version)),
+	}
+}
+
+func modulesList(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	var filters data.Filters
+	listFlags(fs, &filters, data.ModuleSortSafelist)
+	moduleName := fs.String("name", "", "Full-text search on the module name")
+	examType := fs.String("exam-type", "", "Only list modules with this exam type")
+	return func(c *ctl, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+		if err := validateFilters(filters); err != nil {
+			return err
+		}
+		modules, metadata, err := c.models.Module_info.GetAllModules(*moduleName, *examType, filters)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, len(modules))
+		for i, module := range modules {
+			rows[i] = moduleRow(module)
+		}
+		return c.print(map[string]any{"module_info": modules, "metadata": metadata}, moduleHeaders, rows)
+	}
+}
+
+func modulesShow(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+		module, err := c.models.Module_info.Get(id)
+		if err != nil {
+			return notFound(err, "module", id)
+		}
+		return c.print(map[string]any{"module_info": module}, moduleHeaders, [][]string{moduleRow(module)})
+	}
+}
+
+func modulesDelete(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+		err = c.models.Module_info.Delete(id)
+		if err != nil {
+			return notFound(err, "module", id)
+		}
+		return c.printMessage("module %d deleted", id)
+	}
+}
@@ -0,0 +1,256 @@
+// Command greenlightctl carries out operational tasks against the Greenlight database,
+// such as activating users, granting permissions and purging expired tokens, using the
+// same models as the API. It reads the database DSN the same way as cmd/api (see
+// internal/settings): from the -db-dsn flag, the GREENLIGHT_DB_DSN environment variable
+// (which can be set in a .env file), or the db-dsn setting in the API's config file,
+// given by -config or GREENLIGHT_CONFIG. The API's other settings in the file are
+// ignored.
+//
+// Usage:
+//
+//	greenlightctl [-config file] [-db-dsn dsn] [-output table|json] <resource> <action> [flags] [args]
+//
+// Run greenlightctl -help for the list of commands.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/settings"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// errUsage is returned by a command when its arguments are wrong, so that the usage
+// for the command is printed.
+var errUsage = errors.New("usage")
+
+// The command type describes a single greenlightctl command, like "users activate".
+// The setup function defines the command's flags, and returns the function which runs
+// the command once they've been parsed, with the remaining arguments.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(c *ctl, args []string) error
+}
+
+// The commands, in the order that they're shown in the help.
+var commands = []command{
+	{"users list", "", "List user accounts", usersList},
+	{"users show", "<id|email>", "Show a user account and its permissions", usersShow},
+	{"users activate", "<id|email>", "Activate a user account", usersActivate},
+	{"users deactivate", "<id|email>", "Deactivate a user account and log it out everywhere", usersDeactivate},
+	{"users delete", "<id|email>", "Delete a user account", usersDelete},
+	{"permissions list", "[id|email]", "List the permissions of a user, or all permissions", permissionsList},
+	{"permissions grant", "<id|email> <code>...", "Grant permissions (like movies:write) to a user", permissionsGrant},
+	{"permissions revoke", "<id|email> <code>...", "Revoke permissions from a user", permissionsRevoke},
+	{"tokens purge", "", "Delete expired tokens and idempotency keys", tokensPurge},
+	{"tokens revoke", "<id|email>", "Delete a user's tokens, logging it out everywhere", tokensRevoke},
+	{"movies list", "", "List movies", moviesList},
+	{"movies show", "<id>", "Show a movie", moviesShow},
+	{"movies delete", "<id>", "Delete a movie", moviesDelete},
+	{"modules list", "", "List modules", modulesList},
+	{"modules show", "<id>", "Show a module", modulesShow},
+	{"modules delete", "<id>", "Delete a module", modulesDelete},
+}
+
+// The ctl type holds the dependencies for the commands.
+type ctl struct {
+	models data.Models
+	out    io.Writer
+	json   bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	// Load a .env file if there is one, like cmd/api does, but don't insist on it.
+	_ = godotenv.Load(".env")
+
+	fs := flag.NewFlagSet("greenlightctl", flag.ContinueOnError)
+	fs.String("config", "", "The API's config file (.yaml, .yml or .toml)")
+	dsn := fs.String("db-dsn", "", "PostgreSQL DSN")
+	output := fs.String("output", "table", "Output format (table|json)")
+	fs.Usage = func() { usage(fs) }
+	loader := &settings.Loader{
+		FlagSet:         fs,
+		EnvPrefix:       "GREENLIGHT_",
+		FileFlag:        "config",
+		IgnoreUnknown:   true,
+		Secrets:         []string{"db-dsn"},
+		CommandLineOnly: []string{"output"},
+	}
+	err := loader.Load(args)
+	if errors.Is(err, settings.ErrCommandLine) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "greenlightctl: %v\n", err)
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "greenlightctl: invalid -output %q, must be table or json\n", *output)
+		return 2
+	}
+	if fs.NArg() < 2 {
+		usage(fs)
+		return 2
+	}
+	name := fs.Arg(0) + " " + fs.Arg(1)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		cmdFlags := flag.NewFlagSet("greenlightctl "+name, flag.ContinueOnError)
+		cmdFlags.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: greenlightctl %s [flags] %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+			cmdFlags.PrintDefaults()
+		}
+		runCmd := cmd.setup(cmdFlags)
+		err := cmdFlags.Parse(fs.Args()[2:])
+		if err != nil {
+			return 2
+		}
+
+		if *dsn == "" {
+			fmt.Fprintln(os.Stderr, "greenlightctl: no database DSN, set -db-dsn, GREENLIGHT_DB_DSN or db-dsn in the -config file")
+			return 1
+		}
+		db, err := openDB(*dsn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "greenlightctl: %v\n", err)
+			return 1
+		}
+		defer db.Close()
+
+		c := &ctl{models: data.NewModels(db), out: os.Stdout, json: *output == "json"}
+		err = runCmd(c, cmdFlags.Args())
+		switch {
+		case errors.Is(err, errUsage):
+			cmdFlags.Usage()
+			return 2
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "greenlightctl: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "greenlightctl: unknown command %q\n\n", name)
+	usage(fs)
+	return 2
+}
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: greenlightctl [flags] <resource> <action> [flags] [args]\n\nFlags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+}
+
+// The openDB() function opens the connection pool and checks that the database can be
+// reached. The tool only needs a couple of connections.
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// The print() method writes the result of a command. With -output json, value is
+// written as indented JSON; otherwise the rows are written as a table under the
+// headers.
+func (c *ctl) print(value any, headers []string, rows [][]string) error {
+	if c.json {
+		js, err := json.MarshalIndent(value, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.out, string(js))
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// The printMessage() method writes a message about an action which was carried out,
+// as {"message": ...} with -output json.
+func (c *ctl) printMessage(format string, args ...any) error {
+	message := fmt.Sprintf(format, args...)
+	if c.json {
+		return c.print(map[string]string{"message": message}, nil, nil)
+	}
+	_, err := fmt.Fprintln(c.out, message)
+	return err
+}
+
+// The listFlags() helper defines the pagination flags for a list command, which are
+// validated with data.ValidateFilters() like the query string parameters of the API.
+// The safelist is the one which the API uses for the same list, from internal/data.
+func listFlags(fs *flag.FlagSet, filters *data.Filters, safelist []string) {
+	filters.SortSafelist = safelist
+	var fields []string
+	for _, value := range safelist {
+		if !strings.HasPrefix(value, "-") {
+			fields = append(fields, value)
+		}
+	}
+	fs.IntVar(&filters.Page, "page", 1, "Page of results to show")
+	fs.IntVar(&filters.PageSize, "page-size", 20, "Number of results on each page")
+	fs.StringVar(&filters.Sort, "sort", "id", fmt.Sprintf("Field to sort by (%s), with a leading - for descending order", strings.Join(fields, ", ")))
+}
+
+// The validateFilters() helper checks the pagination flags, returning an error which
+// lists the problems.
+func validateFilters(filters data.Filters) error {
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		var problems []string
+		for key, errs := range v.Errors {
+			for _, err := range errs {
+				problems = append(problems, fmt.Sprintf("-%s %s", strings.ReplaceAll(key, "_", "-"), err.Message))
+			}
+		}
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The lookupUser() method finds a user by ID or email address.
+func (c *ctl) lookupUser(idOrEmail string) (*data.User, error) {
+	var user *data.User
+	var err error
+	if id, parseErr := strconv.ParseInt(idOrEmail, 10, 64); parseErr == nil {
+		user, err = c.models.Users.Get(id)
+	} else {
+		user, err = c.models.Users.GetByEmail(idOrEmail)
+	}
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return nil, fmt.Errorf("user %q not found", idOrEmail)
+	}
+	return user, err
+}
+
+// The audit() method records an action taken against a user account in the audit log,
+// like the admin endpoints of the API do. The actor ID is 0, as the action wasn't
+// taken by a user of the API.
+func (c *ctl) audit(action string, targetUserID int64, details map[string]any) error {
+	return c.models.AuditLog.Insert(c.auditEntry(action, targetUserID, details))
+}
+
+// The auditEntry() method returns the audit log entry for an action, for when it has
+// to be recorded in the same transaction as the action itself.
+func (c *ctl) auditEntry(action string, targetUserID int64, details map[string]any) *data.AuditEntry {
+	if details == nil {
+		details = make(map[string]any)
+	}
+	details["source"] = "greenlightctl"
+	return &data.AuditEntry{
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+	}
+}
+
+func userRow(user *data.User) []string {
+	return []string{strconv.FormatInt(user.ID, 10), user.Name, user.Email, strconv.FormatBool(user.Activated), formatTime(user.CreatedAt)}
+}
+
+var userHeaders = []string{"ID", "NAME", "EMAIL", "ACTIVATED", "CREATED"}
+
+func usersList(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	var filters data.Filters
+	listFlags(fs, &filters, data.UserSortSafelist)
+	activated := fs.String("activated", "", "Only list users who are (true) or aren't (false) activated")
+	email := fs.String("email", "", "Only list users whose email address contains this text")
+	return func(c *ctl, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+		if err := validateFilters(filters); err != nil {
+			return err
+		}
+		var activatedFilter *bool
+		if *activated != "" {
+			b, err := strconv.ParseBool(*activated)
+			if err != nil {
+				return fmt.Errorf("-activated must be true or false")
+			}
+			activatedFilter = &b
+		}
+		users, metadata, err := c.models.Users.GetAll(activatedFilter, *email, time.Time{}, time.Time{}, filters)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, len(users))
+		for i, user := range users {
+			rows[i] = userRow(user)
+		}
+		return c.print(map[string]any{"users": users, "metadata": metadata}, userHeaders, rows)
+	}
+}
+
+func usersShow(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		user, err := c.lookupUser(args[0])
+		if err != nil {
+			return err
+		}
+		permissions, err := c.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return err
+		}
+		if permissions == nil {
+			permissions = data.Permissions{}
+		}
+		row := append(userRow(user), strings.Join(permissions, ","))
+		return c.print(
+			map[string]any{"user": user, "permissions": permissions},
+			append(userHeaders, "PERMISSIONS"),
+			[][]string{row},
+		)
+	}
+}
+
+func usersActivate(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		return c.setActivated(args, true)
+	}
+}
+
+func usersDeactivate(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		return c.setActivated(args, false)
+	}
+}
+
+// The setActivated() method activates or deactivates a user account. As with the
+// PATCH /v1/admin/users/:id endpoint, deactivating an account logs the user out
+// everywhere.
+func (c *ctl) setActivated(args []string, activated bool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	user, err := c.lookupUser(args[0])
+	if err != nil {
+		return err
+	}
+	user.Activated = activated
+	var scopes []string
+	if !activated {
+		scopes = data.SessionScopes
+	}
+	entry := c.auditEntry("user.update", user.ID, map[string]any{"activated": activated})
+	err = c.models.Users.UpdateWithAudit(user, entry, scopes, false)
+	if err != nil {
+		return err
+	}
+	if activated {
+		return c.printMessage("user %s activated", user.Email)
+	}
+	return c.printMessage("user %s deactivated", user.Email)
+}
+
+func usersDelete(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		user, err := c.lookupUser(args[0])
+		if err != nil {
+			return err
+		}
+		err = c.models.Users.DeleteWithAudit(user.ID, c.auditEntry("user.delete", user.ID, nil))
+		if err != nil {
+			return err
+		}
+		return c.printMessage("user %s deleted", user.Email)
+	}
+}
+
+func permissionsList(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		var permissions data.Permissions
+		var err error
+		switch len(args) {
+		case 0:
+			permissions, err = c.models.Permissions.GetAll()
+		case 1:
+			var user *data.User
+			user, err = c.lookupUser(args[0])
+			if err != nil {
+				return err
+			}
+			permissions, err = c.models.Permissions.GetAllForUser(user.ID)
+		default:
+			return errUsage
+		}
+		if err != nil {
+			return err
+		}
+		if permissions == nil {
+			permissions = data.Permissions{}
+		}
+		rows := make([][]string, len(permissions))
+		for i, permission := range permissions {
+			rows[i] = []string{permission}
+		}
+		return c.print(map[string]any{"permissions": permissions}, []string{"PERMISSION"}, rows)
+	}
+}
+
+func permissionsGrant(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		if len(args) < 2 {
+			return errUsage
+		}
+		user, err := c.lookupUser(args[0])
+		if err != nil {
+			return err
+		}
+		codes, err := c.checkPermissionCodes(args[1:])
+		if err != nil {
+			return err
+		}
+		// Only add the permissions which the user doesn't have yet, as adding one twice
+		// would violate the primary key of users_permissions.
+		existing, err := c.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return err
+		}
+		var added []string
+		for _, code := range codes {
+			if !existing.Include(code) {
+				added = append(added, code)
+			}
+		}
+		if len(added) > 0 {
+			err = c.models.Permissions.AddForUser(user.ID, added...)
+			if err != nil {
+				return err
+			}
+			err = c.audit("user.permissions.grant", user.ID, map[string]any{"permissions": added})
+			if err != nil {
+				return err
+			}
+		}
+		return c.printMessage("granted %d permission(s) to user %s", len(added), user.Email)
+	}
+}
+
+func permissionsRevoke(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		if len(args) < 2 {
+			return errUsage
+		}
+		user, err := c.lookupUser(args[0])
+		if err != nil {
+			return err
+		}
+		codes, err := c.checkPermissionCodes(args[1:])
+		if err != nil {
+			return err
+		}
+		removed, err := c.models.Permissions.RemoveForUser(user.ID, codes...)
+		if err != nil {
+			return err
+		}
+		if removed > 0 {
+			err = c.audit("user.permissions.revoke", user.ID, map[string]any{"permissions": codes})
+			if err != nil {
+				return err
+			}
+		}
+		return c.printMessage("revoked %d permission(s) from user %s", removed, user.Email)
+	}
+}
+
+// The checkPermissionCodes() method returns an error if any of the codes isn't a known
+// permission, so that a typo isn't silently ignored.
+func (c *ctl) checkPermissionCodes(codes []string) ([]string, error) {
+	all, err := c.models.Permissions.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if !all.Include(code) {
+			return nil, fmt.Errorf("unknown permission %q (known permissions: %s)", code, strings.Join(all, ", "))
+		}
+	}
+	return codes, nil
+}
+
+func tokensPurge(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		if len(args) > 0 {
+			return errUsage
+		}
+		tokens, err := c.models.Tokens.DeleteExpired()
+		if err != nil {
+			return err
+		}
+		keys, err := c.models.Idempotency.DeleteExpired()
+		if err != nil {
+			return err
+		}
+		return c.printMessage("deleted %d expired token(s) and %d expired idempotency key(s)", tokens, keys)
+	}
+}
+
+func tokensRevoke(fs *flag.FlagSet) func(c *ctl, args []string) error {
+	scope := fs.String("scope", data.ScopeAuthentication, "Scope of the tokens to delete")
+	return func(c *ctl, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		user, err := c.lookupUser(args[0])
+		if err != nil {
+			return err
+		}
+		err = c.models.Tokens.DeleteAllForUser(*scope, user.ID)
+		if err != nil {
+			return err
+		}
+		return c.printMessage("deleted the %s tokens of user %s", *scope, user.Email)
+	}
+}
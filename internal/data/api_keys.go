@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/lib/pq"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"net"
+	"strings"
+	"time"
+)
+
+// All API keys start with this prefix, which lets the authenticate() middleware tell
+// them apart from authentication tokens when they are sent as a bearer token, and
+// makes them easy to spot if they are accidentally committed somewhere.
+const APIKeyPrefix = "glk_"
+
+// The total length of a plaintext API key: the prefix plus 32 base-32 characters.
+const apiKeyLength = len(APIKeyPrefix) + 32
+
+// Define an APIKey struct to hold the data for an individual API key. Like tokens,
+// only the SHA-256 hash of the key is stored, and the plaintext is only available in
+// the response to the request which created it. The Prefix field holds the first few
+// characters of the key so that users can tell their keys apart.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Prefix      string      `json:"prefix"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+	AllowedIPs  []string    `json:"allowed_ips"`
+	LastUsedAt  *time.Time  `json:"last_used_at,omitempty"`
+	LastUsedIP  string      `json:"last_used_ip,omitempty"`
+}
+
+// AllowsIP reports whether the key may be used from the given IP address. A key with
+// an empty allow-list can be used from anywhere.
+func (k *APIKey) AllowsIP(ip net.IP) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	for _, allowed := range k.AllowedIPs {
+		_, cidr, err := net.ParseCIDR(allowed)
+		if err == nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// The generateAPIKey() function creates a new random API key for a user, filling in
+// the plaintext, prefix and hash fields.
+func generateAPIKey(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:len(APIKeyPrefix)+8]
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+	return nil
+}
+
+// NormalizeCIDR converts an IP address or CIDR range into CIDR notation, returning
+// false if the value isn't valid.
+func NormalizeCIDR(value string) (string, bool) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", false
+		}
+		if ip.To4() != nil {
+			return ip.String() + "/32", true
+		}
+		return ip.String() + "/128", true
+	}
+	_, cidr, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", false
+	}
+	return cidr.String(), true
+}
+
+// Check that the plaintext API key has the expected prefix and length.
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.CheckField(plaintext != "", "key", validator.Required())
+	v.CheckField(strings.HasPrefix(plaintext, APIKeyPrefix), "key", validator.Format("API key").WithMessage("must start with "+APIKeyPrefix))
+	v.CheckField(len(plaintext) == apiKeyLength, "key", validator.Length(apiKeyLength))
+}
+
+// ValidateAPIKey checks the user-provided settings for a new API key. The granted
+// permissions must be a subset of the owner's own permissions, which are passed in as
+// the final parameter.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, ownerPermissions Permissions) {
+	v.CheckField(key.Name != "", "name", validator.Required())
+	v.CheckField(len(key.Name) <= 100, "name", validator.MaxLength(100))
+	v.CheckField(len(key.Permissions) >= 1, "permissions", validator.MinItems(1).WithMessage("must contain at least 1 permission"))
+	v.CheckField(validator.Unique(key.Permissions), "permissions", validator.UniqueItems())
+	for i, code := range key.Permissions {
+		v.CheckField(ownerPermissions.Include(code), validator.Index("permissions", i), validator.OneOf(ownerPermissions...).WithMessage("must be a permission that you have"))
+	}
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+	v.CheckField(validator.Unique(key.AllowedIPs), "allowed_ips", validator.UniqueItems())
+	for i, allowed := range key.AllowedIPs {
+		_, ok := NormalizeCIDR(allowed)
+		v.CheckField(ok, validator.Index("allowed_ips", i), validator.Format("IP address or CIDR range").WithMessage("must be a valid IP address or CIDR range"))
+	}
+}
+
+// Define the APIKeyModel type.
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// New() generates a new key for the provided settings and inserts it in the api_keys
+// table. The allow-list entries are normalized to CIDR notation before being stored.
+func (m APIKeyModel) New(key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+	allowedIPs := make([]string, 0, len(key.AllowedIPs))
+	for _, allowed := range key.AllowedIPs {
+		if cidr, ok := NormalizeCIDR(allowed); ok {
+			allowedIPs = append(allowedIPs, cidr)
+		}
+	}
+	key.AllowedIPs = allowedIPs
+	query := `
+        INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry, allowed_ips)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at`
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array([]string(key.Permissions)), key.Expiry, pq.Array(key.AllowedIPs)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetForPlaintext() returns the unexpired API key matching a plaintext key, or
+// ErrRecordNotFound if there isn't one.
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+        SELECT id, created_at, user_id, name, prefix, permissions, expiry, allowed_ips, last_used_at, last_used_ip
+        FROM api_keys
+        WHERE hash = $1
+        AND (expiry IS NULL OR expiry > $2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hash[:], time.Now()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// GetAllForUser() returns all of the API keys owned by a user, newest first.
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+        SELECT id, created_at, user_id, name, prefix, permissions, expiry, allowed_ips, last_used_at, last_used_ip
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Touch() records when and where an API key was last used.
+func (m APIKeyModel) Touch(id int64, ip string) error {
+	query := `
+        UPDATE api_keys
+        SET last_used_at = NOW(), last_used_ip = $2
+        WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id, ip)
+	return err
+}
+
+// Delete() revokes an API key. The user ID is included in the WHERE clause so that
+// users can only delete their own keys, and ErrRecordNotFound is returned otherwise.
+func (m APIKeyModel) Delete(id int64, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+        DELETE FROM api_keys
+        WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The scanAPIKey() helper scans a single api_keys row from either a *sql.Row or
+// *sql.Rows, converting the nullable columns as it goes.
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var key APIKey
+	var expiry, lastUsedAt sql.NullTime
+	var lastUsedIP sql.NullString
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array((*[]string)(&key.Permissions)),
+		&expiry,
+		pq.Array(&key.AllowedIPs),
+		&lastUsedAt,
+		&lastUsedIP,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiry.Valid {
+		key.Expiry = &expiry.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	key.LastUsedIP = lastUsedIP.String
+	return &key, nil
+}
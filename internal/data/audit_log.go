@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Define an AuditEntry struct to record an administrative action taken against a user
+// account. There are deliberately no foreign keys on the actor and target IDs, so
+// that the entries outlive the accounts that they refer to.
+type AuditEntry struct {
+	ID           int64          `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ActorID      int64          `json:"actor_id"`
+	Action       string         `json:"action"`
+	TargetUserID int64          `json:"target_user_id"`
+	Details      map[string]any `json:"details"`
+}
+
+// Define the AuditLogModel type.
+type AuditLogModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new entry to the audit log.
+func (m AuditLogModel) Insert(entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertAuditEntry(ctx, m.DB, entry)
+}
+
+// The insertAuditEntry() function adds an entry to the audit log using either the
+// connection pool or a transaction, so that other models can record an action in the
+// same transaction as the change itself.
+func insertAuditEntry(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, entry *AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	query := `
+        INSERT INTO audit_log (actor_id, action, target_user_id, details)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at`
+	args := []any{entry.ActorID, entry.Action, entry.TargetUserID, details}
+	return db.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// The recordDeletion() function fills in an audit log entry for the deletion of a
+// user. The user record is gone afterwards, so the entry keeps a snapshot of who it
+// was, alongside any details that the entry already has.
+func recordDeletion(entry *AuditEntry, user *User) {
+	details := make(map[string]any, len(entry.Details)+4)
+	for key, value := range entry.Details {
+		details[key] = value
+	}
+	details["name"] = user.Name
+	details["email"] = user.Email
+	details["created_at"] = user.CreatedAt
+	details["activated"] = user.Activated
+	entry.TargetUserID = user.ID
+	entry.Details = details
+}
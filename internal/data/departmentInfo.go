@@ -5,27 +5,24 @@ import (
 	"database/sql"
 	"errors"
 	_ "fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"greenlight.m4rk1sov.github.com/internal/validator"
 	"time"
 )
 
 type DepartmentInfo struct {
 	ID                 int64  `json:"id"`
-	DepartmentName     string `json:"departmentName"`
-	StaffQuantity      int64  `json:"staffQuantity"`
-	DepartmentDirector string `json:"departmentDirector"`
+	DepartmentName     string `json:"departmentName" validate:"required,max=500"`
+	StaffQuantity      int64  `json:"staffQuantity" validate:"required,min=1" messages:"min=must be a positive integer"`
+	DepartmentDirector string `json:"departmentDirector" validate:"required"`
 	Module_Info        int64  `json:"module_Info"`
 }
 
 // To prevent duplication, we can collect the validation checks for a movie into a standalone
 // ValidateMovie() function
 func ValidateDepartment(v *validator.Validator, department *DepartmentInfo) {
-	v.Check(department.DepartmentName != "", "departmentName", "must be provided")
-	v.Check(len(department.DepartmentName) <= 500, "departmentName", "must not be more than 500 bytes long")
-	v.Check(department.StaffQuantity != 0, "staffQuantity", "must be provided")
-	v.Check(department.StaffQuantity > 0, "staffQuantity", "must be a positive integer")
-	v.Check(department.DepartmentDirector != "", "departmentDirector", "must be provided")
+	// The rules are declared in the validate tags on the DepartmentInfo struct.
+	v.Struct(department)
 }
 
 // Define a DepartmentInfoModel struct type which wraps a sql.DB connection pool.
@@ -205,6 +202,46 @@ WHERE id = $1`
 	return nil
 }
 
+// GetAllForModules returns the departments belonging to each of the given modules,
+// keyed by module ID. It's used to embed departments in module responses with a single
+// query, however many modules there are.
+func (m DepartmentInfoModel) GetAllForModules(moduleIDs []int64) (map[int64][]*DepartmentInfo, error) {
+	query := `
+SELECT id, departmentName, staffQuantity, departmentDirector, module_Info
+FROM departmentInfo
+WHERE module_Info = ANY($1)
+ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(moduleIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	departments := make(map[int64][]*DepartmentInfo)
+	for rows.Next() {
+		var departmentInfo DepartmentInfo
+		err := rows.Scan(
+			&departmentInfo.ID,
+			&departmentInfo.DepartmentName,
+			&departmentInfo.StaffQuantity,
+			&departmentInfo.DepartmentDirector,
+			&departmentInfo.Module_Info,
+		)
+		if err != nil {
+			return nil, err
+		}
+		departments[departmentInfo.Module_Info] = append(departments[departmentInfo.Module_Info], &departmentInfo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return departments, nil
+}
+
 // Create a new GetAll() method which returns a slice of movies. Although we're not
 // using them right now, we've set this up to accept the various filter parameters as
 // arguments.
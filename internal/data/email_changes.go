@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define an EmailChange struct to hold a pending change of email address, which is
+// confirmed by presenting the email-change token that was sent to the new address.
+type EmailChange struct {
+	UserID int64
+	Email  string
+}
+
+// Define the EmailChangeModel type.
+type EmailChangeModel struct {
+	DB *sql.DB
+}
+
+// New() creates an email-change token for a user along with the new email address
+// that it confirms. Any earlier pending changes for the user are discarded, so that
+// only the most recently requested address can be confirmed.
+func (m EmailChangeModel) New(userID int64, email string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`, ScopeEmailChange, userID)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+        INSERT INTO tokens (hash, user_id, expiry, scope)
+        VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO email_changes (hash, email) VALUES ($1, $2)`, token.Hash, email)
+	if err != nil {
+		return nil, err
+	}
+	return token, tx.Commit()
+}
+
+// GetForToken() returns the pending email change for an unexpired email-change token,
+// or ErrRecordNotFound if there isn't one.
+func (m EmailChangeModel) GetForToken(tokenPlaintext string) (*EmailChange, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+        SELECT tokens.user_id, email_changes.email
+        FROM tokens
+        INNER JOIN email_changes ON email_changes.hash = tokens.hash
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2
+        AND tokens.expiry > $3`
+	var change EmailChange
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeEmailChange, time.Now()).Scan(&change.UserID, &change.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &change, nil
+}
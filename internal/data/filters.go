@@ -12,6 +12,10 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// The JSON names of the fields that the client wants in the results. If it's
+	// empty, then all of the fields are wanted. Models use it to leave out columns
+	// where they can.
+	Fields []string
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safelist
@@ -42,14 +46,20 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// The largest page number and page size that clients can ask for.
+const (
+	MaxPage     = 10_000_000
+	MaxPageSize = 100
+)
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the page and page_size parameters contain sensible values.
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.CheckField(f.Page > 0, "page", validator.Min(1).WithMessage("must be greater than zero"))
+	v.CheckField(f.Page <= MaxPage, "page", validator.Max(MaxPage).WithMessage("must be a maximum of 10 million"))
+	v.CheckField(f.PageSize > 0, "page_size", validator.Min(1).WithMessage("must be greater than zero"))
+	v.CheckField(f.PageSize <= MaxPageSize, "page_size", validator.Max(MaxPageSize))
 	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.CheckField(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", validator.OneOf(f.SortSafelist...).WithMessage("invalid sort value"))
 }
 
 // Define a new Metadata struct for holding the pagination metadata.
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Define an IdempotencyRecord struct to hold the request and stored response for an
+// Idempotency-Key. Keys are scoped (for example to the user making the request), so
+// that different clients can't see each other's responses. While the original request
+// is still being processed, Completed is false and there is no response yet.
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	RequestHash []byte
+	Completed   bool
+	Status      int
+	Headers     http.Header
+	Body        []byte
+	Expiry      time.Time
+}
+
+// Define the IdempotencyKeyModel type.
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Reserve() claims an idempotency key for a new request, returning true if the key was
+// free (or had expired). Otherwise it returns the existing record for the key, so that
+// the caller can replay its response (or, if it isn't completed, report that the key
+// is in use). Because the claim is a single INSERT, only one
+// of several concurrent requests with the same key can succeed.
+func (m IdempotencyKeyModel) Reserve(scope, key string, requestHash []byte, ttl time.Duration) (bool, *IdempotencyRecord, error) {
+	query := `
+        INSERT INTO idempotency_keys (scope, key, request_hash, expiry)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (scope, key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash, status = NULL, headers = NULL, body = NULL,
+            created_at = NOW(), expiry = EXCLUDED.expiry
+        WHERE idempotency_keys.expiry < NOW()
+        RETURNING scope`
+	args := []any{scope, key, requestHash, time.Now().Add(ttl)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&scope)
+	switch {
+	case err == nil:
+		return true, nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return false, nil, err
+	}
+	// The key is already in use, so fetch the existing record. If it has gone by now,
+	// then the request using it has only just released it, so report the key as still
+	// in use rather than as an error; the client can retry.
+	record, err := m.Get(scope, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return false, &IdempotencyRecord{Scope: scope, Key: key, RequestHash: requestHash}, nil
+		default:
+			return false, nil, err
+		}
+	}
+	return false, record, nil
+}
+
+// Get() returns the record for an idempotency key, or ErrRecordNotFound if there isn't
+// one.
+func (m IdempotencyKeyModel) Get(scope, key string) (*IdempotencyRecord, error) {
+	query := `
+        SELECT scope, key, request_hash, status, headers, body, expiry
+        FROM idempotency_keys
+        WHERE scope = $1 AND key = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var record IdempotencyRecord
+	var status sql.NullInt64
+	var headers []byte
+	err := m.DB.QueryRowContext(ctx, query, scope, key).Scan(
+		&record.Scope,
+		&record.Key,
+		&record.RequestHash,
+		&status,
+		&headers,
+		&record.Body,
+		&record.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if status.Valid {
+		record.Completed = true
+		record.Status = int(status.Int64)
+		err = json.Unmarshal(headers, &record.Headers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &record, nil
+}
+
+// Complete() stores the response for a reserved idempotency key.
+func (m IdempotencyKeyModel) Complete(scope, key string, status int, headers http.Header, body []byte) error {
+	js, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	query := `
+        UPDATE idempotency_keys
+        SET status = $3, headers = $4, body = $5
+        WHERE scope = $1 AND key = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, scope, key, status, js, body)
+	return err
+}
+
+// Release() deletes an idempotency key, so that the request can be retried. It's used
+// when the original request fails without a response worth keeping.
+func (m IdempotencyKeyModel) Release(scope, key string) error {
+	query := `
+        DELETE FROM idempotency_keys
+        WHERE scope = $1 AND key = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, key)
+	return err
+}
+
+// DeleteExpired() deletes all of the expired idempotency keys, returning the number of
+// keys deleted.
+func (m IdempotencyKeyModel) DeleteExpired() (int64, error) {
+	query := `
+        DELETE FROM idempotency_keys
+        WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
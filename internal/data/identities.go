@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define an Identity struct to link an account at an external identity provider
+// (identified by the issuer URL and the provider's subject ID) to a local user.
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Define the IdentityModel type.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert() links an external identity to a user.
+func (m IdentityModel) Insert(identity *Identity) error {
+	query := `
+        INSERT INTO user_identities (issuer, subject, user_id)
+        VALUES ($1, $2, $3)
+        RETURNING created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, identity.Issuer, identity.Subject, identity.UserID).Scan(&identity.CreatedAt)
+}
+
+// GetUser() returns the user linked to an external identity, or ErrRecordNotFound if
+// the identity hasn't been linked to anyone yet.
+func (m IdentityModel) GetUser(issuer, subject string) (*User, error) {
+	query := `
+        SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+        FROM users
+        INNER JOIN user_identities ON user_identities.user_id = users.id
+        WHERE user_identities.issuer = $1 AND user_identities.subject = $2`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Define an OIDCState struct to hold the values that we need to remember between
+// sending a user to the identity provider and them coming back to the callback
+// endpoint. The state value itself is only stored as a SHA-256 hash.
+type OIDCState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+// Define the OIDCStateModel type.
+type OIDCStateModel struct {
+	DB *sql.DB
+}
+
+// Insert() stores a pending login.
+func (m OIDCStateModel) Insert(state *OIDCState) error {
+	hash := sha256.Sum256([]byte(state.State))
+	query := `
+        INSERT INTO oidc_states (hash, nonce, code_verifier, expiry)
+        VALUES ($1, $2, $3, $4)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash[:], state.Nonce, state.CodeVerifier, state.Expiry)
+	return err
+}
+
+// Consume() looks up an unexpired pending login by its state value and deletes it in
+// the same query, so that each state can only ever be used once. It returns
+// ErrRecordNotFound if there is no matching login.
+func (m OIDCStateModel) Consume(stateValue string) (*OIDCState, error) {
+	hash := sha256.Sum256([]byte(stateValue))
+	query := `
+        DELETE FROM oidc_states
+        WHERE hash = $1 AND expiry > $2
+        RETURNING nonce, code_verifier, expiry`
+	state := OIDCState{State: stateValue}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(&state.Nonce, &state.CodeVerifier, &state.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &state, nil
+}
+
+// DeleteExpired() deletes the pending logins which were never completed, returning
+// the number deleted.
+func (m OIDCStateModel) DeleteExpired() (int64, error) {
+	query := `
+        DELETE FROM oidc_states
+        WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
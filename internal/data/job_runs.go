@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The values of the JobRun Status field.
+const (
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Define a JobRun struct to record a single run of one of the scheduled background
+// jobs. The Instance field identifies the API instance which ran the job, and Result
+// holds whatever the job reported, such as the number of records that it deleted.
+type JobRun struct {
+	ID         int64          `json:"id"`
+	Job        string         `json:"job"`
+	Instance   string         `json:"instance"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Status     string         `json:"status"`
+	Result     map[string]any `json:"result"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Define the JobRunModel type.
+type JobRunModel struct {
+	DB *sql.DB
+}
+
+// The values that the sort parameter can take when listing job runs.
+var JobRunSortSafelist = []string{"id", "job", "started_at", "-id", "-job", "-started_at"}
+
+// Insert() records a job run.
+func (m JobRunModel) Insert(run *JobRun) error {
+	if run.Result == nil {
+		run.Result = map[string]any{}
+	}
+	result, err := json.Marshal(run.Result)
+	if err != nil {
+		return err
+	}
+	query := `
+        INSERT INTO job_runs (job, instance, started_at, finished_at, status, result, error)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id`
+	args := []any{run.Job, run.Instance, run.StartedAt, run.FinishedAt, run.Status, result, run.Error}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&run.ID)
+}
+
+// GetAll() returns a page of job runs, optionally only those of a single job or with
+// a particular status.
+func (m JobRunModel) GetAll(job, status string, filters Filters) ([]*JobRun, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, job, instance, started_at, finished_at, status, result, error
+        FROM job_runs
+        WHERE (job = $1 OR $1 = '')
+        AND (status = $2 OR $2 = '')
+        ORDER BY %s %s, id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, job, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	runs := []*JobRun{}
+	for rows.Next() {
+		run, err := scanJobRun(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		runs = append(runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return runs, metadata, nil
+}
+
+// GetLatest() returns the most recent run of each job, keyed by the job name.
+func (m JobRunModel) GetLatest() (map[string]*JobRun, error) {
+	query := `
+        SELECT DISTINCT ON (job) id, job, instance, started_at, finished_at, status, result, error
+        FROM job_runs
+        ORDER BY job, started_at DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	latest := make(map[string]*JobRun)
+	for rows.Next() {
+		run, err := scanJobRun(rows, nil)
+		if err != nil {
+			return nil, err
+		}
+		latest[run.Job] = run
+	}
+	return latest, rows.Err()
+}
+
+// DeleteOlderThan() deletes the job runs which started before the given time,
+// returning the number of runs deleted.
+func (m JobRunModel) DeleteOlderThan(before time.Time) (int64, error) {
+	query := `
+        DELETE FROM job_runs
+        WHERE started_at < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// The scanJobRun() helper scans a single job_runs row, decoding the result column. If
+// totalRecords isn't nil, the row is expected to start with the count(*) OVER() column.
+func scanJobRun(rows *sql.Rows, totalRecords *int) (*JobRun, error) {
+	var run JobRun
+	var result []byte
+	dest := []any{&run.ID, &run.Job, &run.Instance, &run.StartedAt, &run.FinishedAt, &run.Status, &result, &run.Error}
+	if totalRecords != nil {
+		dest = append([]any{totalRecords}, dest...)
+	}
+	err := rows.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(result, &run.Result)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define a LoginAttempt struct to hold the failed login counter for a single key. The
+// key identifies what is being tracked, such as a particular user account
+// ("user:<id>") or client IP address ("ip:<address>").
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time // The zero value means that the key isn't locked.
+}
+
+// Locked reports whether the key is currently locked out.
+func (a *LoginAttempt) Locked() bool {
+	return a.LockedUntil.After(time.Now())
+}
+
+// Define the LoginAttemptModel type.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Get() returns the failed login record for a specific key, or ErrRecordNotFound if
+// there have been no failures recorded.
+func (m LoginAttemptModel) Get(key string) (*LoginAttempt, error) {
+	query := `
+        SELECT key, failures, last_failure, locked_until
+        FROM login_attempts
+        WHERE key = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return scanLoginAttempt(m.DB.QueryRowContext(ctx, query, key))
+}
+
+// RecordFailure() increments the failure counter for a key and returns the updated
+// record. If the previous failure happened longer ago than the window duration, or an
+// earlier lockout has already expired, then the counter starts again from 1. When the
+// counter reaches the limit, the key is locked out for the lockout duration, in the
+// same statement, so that concurrent failures can't slip past the limit.
+func (m LoginAttemptModel) RecordFailure(key string, window time.Duration, limit int, lockout time.Duration) (*LoginAttempt, error) {
+	query := `
+        INSERT INTO login_attempts (key, failures, last_failure, locked_until)
+        VALUES ($1, 1, NOW(), CASE WHEN $3 <= 1 THEN NOW() + make_interval(secs => $4) END)
+        ON CONFLICT (key) DO UPDATE
+        SET failures = CASE
+                WHEN login_attempts.last_failure < NOW() - make_interval(secs => $2)
+                  OR login_attempts.locked_until < NOW() THEN 1
+                ELSE login_attempts.failures + 1
+            END,
+            locked_until = CASE
+                WHEN login_attempts.locked_until >= NOW() THEN login_attempts.locked_until
+                WHEN CASE
+                    WHEN login_attempts.last_failure < NOW() - make_interval(secs => $2)
+                      OR login_attempts.locked_until < NOW() THEN 1
+                    ELSE login_attempts.failures + 1
+                END >= $3 THEN NOW() + make_interval(secs => $4)
+                ELSE NULL
+            END,
+            last_failure = NOW()
+        RETURNING key, failures, last_failure, locked_until`
+	args := []any{key, window.Seconds(), limit, lockout.Seconds()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return scanLoginAttempt(m.DB.QueryRowContext(ctx, query, args...))
+}
+
+// Reset() removes the failed login record for a key, clearing both the failure
+// counter and any lockout.
+func (m LoginAttemptModel) Reset(key string) error {
+	query := `
+        DELETE FROM login_attempts
+        WHERE key = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// DeleteStale() deletes the records whose last failure was longer ago than the window
+// duration and which aren't locked out, as RecordFailure() would start their counters
+// again from 1 anyway. It returns the number of records deleted.
+func (m LoginAttemptModel) DeleteStale(window time.Duration) (int64, error) {
+	query := `
+        DELETE FROM login_attempts
+        WHERE last_failure < NOW() - make_interval(secs => $1)
+        AND (locked_until IS NULL OR locked_until < NOW())`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// The scanLoginAttempt() helper scans a single login_attempts row, converting the
+// nullable locked_until column into a zero time.Time if it isn't set.
+func scanLoginAttempt(row *sql.Row) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	var lockedUntil sql.NullTime
+	err := row.Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailure, &lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	attempt.LockedUntil = lockedUntil.Time
+	return &attempt, nil
+}
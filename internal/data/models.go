@@ -21,6 +21,15 @@ type Models struct {
 	Users          UserModel       // Add a new Users field.
 	Tokens         TokenModel      // Add a new Tokens field.
 	Permissions    PermissionModel // Add a new Permissions field.
+	LoginAttempts  LoginAttemptModel
+	TwoFactor      TwoFactorModel
+	APIKeys        APIKeyModel
+	Identities     IdentityModel
+	OIDCStates     OIDCStateModel
+	EmailChanges   EmailChangeModel
+	AuditLog       AuditLogModel
+	Idempotency    IdempotencyKeyModel
+	JobRuns        JobRunModel
 	//// Set the Movies field to be an interface containing the methods that both the
 	//// 'real' model and mock model need to support.
 	//Movies interface {
@@ -42,6 +51,15 @@ func NewModels(db *sql.DB) Models {
 		Users:          UserModel{DB: db},       // Initialize a new UserModel instance
 		Tokens:         TokenModel{DB: db},      // Initialize a new TokenModel instance
 		Permissions:    PermissionModel{DB: db}, // Initialize a new PermissionModel instance
+		LoginAttempts:  LoginAttemptModel{DB: db},
+		TwoFactor:      TwoFactorModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		Identities:     IdentityModel{DB: db},
+		OIDCStates:     OIDCStateModel{DB: db},
+		EmailChanges:   EmailChangeModel{DB: db},
+		AuditLog:       AuditLogModel{DB: db},
+		Idempotency:    IdempotencyKeyModel{DB: db},
+		JobRuns:        JobRunModel{DB: db},
 	}
 }
 
@@ -14,20 +14,18 @@ type Module_info struct {
 	ID             int64     `json:"id"`
 	CreatedAt      time.Time `json:"-"`
 	UpdatedAt      time.Time `json:"-"`
-	ModuleName     string    `json:"moduleName"`
-	ModuleDuration Runtime   `json:"moduleDuration,omitempty"`
-	ExamType       string    `json:"examType"`
+	ModuleName     string    `json:"moduleName" validate:"required,max=500"`
+	ModuleDuration Runtime   `json:"moduleDuration,omitempty" validate:"required,min=5,max=15"`
+	ExamType       string    `json:"examType" validate:"required"`
 	Version        int32     `json:"version"` // The version starts at number 1
 }
 
 // To prevent duplication, we can collect the validation checks for a movie into a standalone
 // ValidateMovie() function
 func ValidateModule(v *validator.Validator, module *Module_info) {
-	v.Check(module.ModuleName != "", "moduleName", "must be provided")
-	v.Check(len(module.ModuleName) <= 500, "moduleName", "must not be more than 500 bytes long")
-	v.Check(module.ModuleDuration != 0, "moduleDuration", "must be provided")
-	v.Check(module.ModuleDuration > 0, "moduleDuration", "must be a positive integer")
-	v.Check(module.ExamType != "", "examType", "must be provided")
+	// The rules are declared in the validate tags on the Module_info struct. Note that
+	// the module duration must be between 5 and 15, matching the database constraint.
+	v.Struct(module)
 }
 
 // Define a Module_infoModel struct type which wraps a sql.DB connection pool.
@@ -35,6 +33,9 @@ type Module_infoModel struct {
 	DB *sql.DB
 }
 
+// The values that the sort parameter can take when listing modules.
+var ModuleSortSafelist = []string{"id", "moduleName", "moduleDuration", "-id", "-moduleName", "-moduleDuration"}
+
 // The Insert() method accepts a pointer to a movie struct, which should contain the
 // data for the new record.
 func (m Module_infoModel) Insert(module_info *Module_info) error {
@@ -214,58 +215,53 @@ WHERE id = $1`
 // arguments.
 // Update the function signature to return a Metadata struct.
 func (m Module_infoModel) GetAllModules(moduleName string, examType string, filters Filters) ([]*Module_info, Metadata, error) {
-	// Construct the SQL query to retrieve all movie records.
-	// Update the SQL query to include the filter conditions.
-
-	// Use full-text search for the title filter.
-
-	// Add an ORDER BY clause and interpolate the sort column and direction. Importantly
-	// notice that we also include a secondary sort on the movie ID to ensure a
-	// consistent ordering.
-
-	// Update the SQL query to include the LIMIT and OFFSET clauses with placeholder
-	// parameter values.
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	modules_info := []*Module_info{}
+	metadata, err := m.EachModule(ctx, moduleName, examType, filters, func(module_info *Module_info, _ Metadata) error {
+		modules_info = append(modules_info, module_info)
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return modules_info, metadata, nil
+}
 
-	// Update the SQL query to include the window function which counts the total
-	// (filtered) records.
+// EachModule calls fn for each of the modules matching the name, exam type and
+// filters, as they are read from the database, and then returns the pagination
+// metadata, which is also passed to fn. Like MovieModel.Each, it runs until ctx is done
+// rather than setting its own timeout, so that a handler can stream the modules to a
+// slow client. If fn returns an error, then EachModule stops and returns it.
+func (m Module_infoModel) EachModule(ctx context.Context, moduleName string, examType string, filters Filters, fn func(module_info *Module_info, metadata Metadata) error) (Metadata, error) {
+	// Use full-text search for the name filter, and only filter on the name or the
+	// exam type if they were given. Importantly notice that we also include a
+	// secondary sort on the module ID to ensure a consistent ordering, and the window
+	// function which counts the total (filtered) records.
 	query := fmt.Sprintf(`
 SELECT count(*) OVER(), id, created_at, updated_at, moduleName, moduleDuration, examType, version
 FROM module_info
-WHERE (to_tsvector('simple', moduleName) @@ plainto_tsquery('simple', $1))
+WHERE (to_tsvector('simple', moduleName) @@ plainto_tsquery('simple', $1) OR $1 = '')
+AND (examType = $2 OR $2 = '')
 ORDER BY %s %s, id ASC
-LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
-	// containing the result.
-
-	// As our SQL query now has quite a few placeholder parameters, let's collect the
-	// values for the placeholders in a slice. Notice here how we call the limit() and
-	// offset() methods on the Filters struct to get the appropriate values for the
-	// LIMIT and OFFSET clauses.
+	// Collect the values for the placeholders in a slice, calling the limit() and
+	// offset() methods on the Filters struct to get the values for the LIMIT and
+	// OFFSET clauses.
 	args := []any{moduleName, examType, filters.limit(), filters.offset()}
 
-	// And then pass the args slice to QueryContext() as a variadic parameter.
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+		return Metadata{}, err
 	}
-
 	// Importantly, defer a call to rows.Close() to ensure that the resultset is closed
-	// before GetAll() returns.
+	// before EachModule() returns.
 	defer rows.Close()
-	// Initialize an empty slice to hold the movie data.
-	// Declare a totalRecords variable.
 	totalRecords := 0
-	modules_info := []*Module_info{}
-	// Use rows.Next to iterate through the rows in the resultset.
 	for rows.Next() {
-		// Initialize an empty Movie struct to hold the data for an individual movie.
 		var module_info Module_info
-		// Scan the values from the row into the Movie struct. Again, note that we're
-		// using the pq.Array() adapter on the genres field here.
 		err := rows.Scan(
 			&totalRecords, // Scan the count from the window function into totalRecords.
 			&module_info.ID,
@@ -277,24 +273,21 @@ LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
 			&module_info.Version,
 		)
 		if err != nil {
-			return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+			return Metadata{}, err
+		}
+		// Pass the module to fn, along with the metadata.
+		err = fn(&module_info, calculateMetadata(totalRecords, filters.Page, filters.PageSize))
+		if err != nil {
+			return Metadata{}, err
 		}
-
-		// Add the Movie struct to the slice.
-		modules_info = append(modules_info, &module_info)
 	}
 	// When the rows.Next() loop has finished, call rows.Err() to retrieve any error
 	// that was encountered during the iteration.
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
-
+		return Metadata{}, err
 	}
 
 	// Generate a Metadata struct, passing in the total record count and pagination
 	// parameters from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-
-	// Include the metadata struct when returning.
-	// If everything went OK, then return the slice of movies.
-	return modules_info, metadata, nil
+	return calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"greenlight.m4rk1sov.github.com/internal/validator"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -15,31 +17,36 @@ import (
 // - used to hide form users, omitempty to hide if null, to leave json name ",omitempty"
 // additionally, we can add ",string" to directive to convert int to string
 type Movie struct {
-	ID        int64     `json:"id"`             // Unique Integer ID for the movie
-	CreatedAt time.Time `json:"-"`              // Timestamps for when movies added to the database
-	Title     string    `json:"title"`          // Movie title
-	Year      int32     `json:"year,omitempty"` // Movie release year
+	ID        int64     `json:"id"` // Unique Integer ID for the movie
+	CreatedAt time.Time `json:"-"`  // Timestamps for when movies added to the database
+	// Movie title
+	Title string `json:"title" validate:"required,max=500"`
+	// Movie release year
+	Year int32 `json:"year,omitempty" validate:"required,min=1888,notfuture" messages:"min=must be greater than 1888"`
+	// Movie Runtime (in minutes)
 	// now Runtime type, if null, skips the method
-	Runtime Runtime  `json:"runtime,omitempty"` // Movie Runtime (in minutes)
-	Genres  []string `json:"genres,omitempty"`  // Slice of genres for the movie (romance, comedy, etc.)
-	Version int32    `json:"version"`           // The version starts at number 1 and will be
-	// incremented each time the movie information is updated
+	Runtime Runtime `json:"runtime,omitempty" validate:"required,min=1" messages:"min=must be a positive integer"`
+	// Slice of genres for the movie (romance, comedy, etc.)
+	Genres []string `json:"genres,omitempty" validate:"required,min=1,max=5,unique,dive,required" messages:"min=must contain at least 1 genre;max=must not contain more than 5 genres"`
+	// The version starts at number 1 and will be incremented each time the movie
+	// information is updated
+	Version int32 `json:"version"`
+}
+
+// Register the notfuture rule used by the Movie struct, which checks that a year isn't
+// after the current one.
+func init() {
+	validator.RegisterRule("notfuture", func(value reflect.Value, _ string) (bool, validator.Error) {
+		year := int64(time.Now().Year())
+		return value.Int() <= year, validator.Max(year).WithMessage("must not be in the future")
+	})
 }
 
 // To prevent duplication, we can collect the validation checks for a movie into a standalone
 // ValidateMovie() function
 func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	// The rules are declared in the validate tags on the Movie struct.
+	v.Struct(movie)
 }
 
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
@@ -47,6 +54,9 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
+// The values that the sort parameter can take when listing movies.
+var MovieSortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
 // The Insert() method accepts a pointer to a movie struct, which should contain the
 // data for the new record.
 func (m MovieModel) Insert(movie *Movie) error {
@@ -222,38 +232,82 @@ WHERE id = $1`
 	return nil
 }
 
+// The movieColumns slice maps the JSON name of each Movie field to its database column
+// and the destination to scan it into, so that queries can select only the fields
+// that the client wants.
+var movieColumns = []struct {
+	field  string
+	column string
+	dest   func(movie *Movie) any
+}{
+	{"id", "id", func(movie *Movie) any { return &movie.ID }},
+	{"title", "title", func(movie *Movie) any { return &movie.Title }},
+	{"year", "year", func(movie *Movie) any { return &movie.Year }},
+	{"runtime", "runtime", func(movie *Movie) any { return &movie.Runtime }},
+	{"genres", "genres", func(movie *Movie) any { return pq.Array(&movie.Genres) }},
+	{"version", "version", func(movie *Movie) any { return &movie.Version }},
+}
+
+// The selectMovieColumns() helper returns the columns to select for the given fields
+// (or for all of them, if fields is empty), and a function which returns the scan
+// destinations for them. The created_at column isn't part of the JSON, but is always
+// selected.
+func selectMovieColumns(fields []string) (string, func(movie *Movie) []any) {
+	columns := []string{"created_at"}
+	dests := []func(movie *Movie) any{func(movie *Movie) any { return &movie.CreatedAt }}
+	for _, c := range movieColumns {
+		if len(fields) == 0 || validator.PermittedValue(c.field, fields...) {
+			columns = append(columns, c.column)
+			dests = append(dests, c.dest)
+		}
+	}
+	return strings.Join(columns, ", "), func(movie *Movie) []any {
+		targets := make([]any, len(dests))
+		for i, dest := range dests {
+			targets[i] = dest(movie)
+		}
+		return targets
+	}
+}
+
 // Create a new GetAll() method which returns a slice of movies. Although we're not
 // using them right now, we've set this up to accept the various filter parameters as
 // arguments.
 // Update the function signature to return a Metadata struct.
 func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	// Construct the SQL query to retrieve all movie records.
-	// Update the SQL query to include the filter conditions.
-
-	// Use full-text search for the title filter.
-
-	// Add an ORDER BY clause and interpolate the sort column and direction. Importantly
-	// notice that we also include a secondary sort on the movie ID to ensure a
-	// consistent ordering.
-
-	// Update the SQL query to include the LIMIT and OFFSET clauses with placeholder
-	// parameter values.
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	movies := []*Movie{}
+	metadata, err := m.Each(ctx, title, genres, filters, func(movie *Movie, _ Metadata) error {
+		movies = append(movies, movie)
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return movies, metadata, nil
+}
 
-	// Update the SQL query to include the window function which counts the total
-	// (filtered) records.
+// Each calls fn for each of the movies matching the title, genres and filters, as they
+// are read from the database, and then returns the pagination metadata. This lets a
+// handler stream a page of results to the client without holding it all in memory.
+// The metadata is passed to fn too, as the total record count comes with every row, so
+// that a handler can write it before the movies.
+// Unlike the other methods, Each doesn't set its own timeout, as fn may be writing to
+// a slow client: the query runs until ctx is done, so a handler should pass in the
+// request's context, which the server's write timeout bounds. If fn returns an error,
+// then Each stops and returns it.
+func (m MovieModel) Each(ctx context.Context, title string, genres []string, filters Filters, fn func(movie *Movie, metadata Metadata) error) (Metadata, error) {
+	// Only select the columns for the fields that the client wants.
+	columns, scanTargets := selectMovieColumns(filters.Fields)
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+SELECT count(*) OVER(), %s
 FROM movies
 WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 AND (genres @> $2 OR $2 = '{}')
 ORDER BY %s %s, id ASC
-LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
-	// containing the result.
+LIMIT $3 OFFSET $4`, columns, filters.sortColumn(), filters.sortDirection())
 
 	// As our SQL query now has quite a few placeholder parameters, let's collect the
 	// values for the placeholders in a slice. Notice here how we call the limit() and
@@ -264,53 +318,40 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	// And then pass the args slice to QueryContext() as a variadic parameter.
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+		return Metadata{}, err
 	}
 
 	// Importantly, defer a call to rows.Close() to ensure that the resultset is closed
-	// before GetAll() returns.
+	// before Each() returns.
 	defer rows.Close()
-	// Initialize an empty slice to hold the movie data.
 	// Declare a totalRecords variable.
 	totalRecords := 0
-	movies := []*Movie{}
 	// Use rows.Next to iterate through the rows in the resultset.
 	for rows.Next() {
 		// Initialize an empty Movie struct to hold the data for an individual movie.
 		var movie Movie
-		// Scan the values from the row into the Movie struct. Again, note that we're
-		// using the pq.Array() adapter on the genres field here.
-		err := rows.Scan(
-			&totalRecords, // Scan the count from the window function into totalRecords.
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		// Scan the count from the window function into totalRecords, and the selected
+		// columns into the Movie struct.
+		err := rows.Scan(append([]any{&totalRecords}, scanTargets(&movie)...)...)
 		if err != nil {
-			return nil, Metadata{}, err // Update this to return an empty Metadata struct.
+			return Metadata{}, err
 		}
 
-		// Add the Movie struct to the slice.
-		movies = append(movies, &movie)
+		// Pass the movie to fn, along with the metadata.
+		err = fn(&movie, calculateMetadata(totalRecords, filters.Page, filters.PageSize))
+		if err != nil {
+			return Metadata{}, err
+		}
 	}
 	// When the rows.Next() loop has finished, call rows.Err() to retrieve any error
 	// that was encountered during the iteration.
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
-
+		return Metadata{}, err
 	}
 
 	// Generate a Metadata struct, passing in the total record count and pagination
 	// parameters from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-
-	// Include the metadata struct when returning.
-	// If everything went OK, then return the slice of movies.
-	return movies, metadata, nil
+	return calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
 //type MockMovieModel struct{}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Define an ErrUnknownHashFormat error, which is returned when a stored password hash
+// isn't in a format that any of the registered hashers recognize.
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// The PasswordHasher interface describes a password hashing algorithm. Hashes must be
+// self-describing, meaning that they carry an identifier for the algorithm along with
+// any parameters needed to verify them, so that hashes created by different
+// algorithms (or with different settings) can live side by side in the users table.
+type PasswordHasher interface {
+	// Hash returns the hash of a plaintext password.
+	Hash(plaintext string) ([]byte, error)
+	// Recognizes reports whether the hash was created by this algorithm.
+	Recognizes(hash []byte) bool
+	// Verify reports whether a plaintext password matches the hash.
+	Verify(hash []byte, plaintext string) (bool, error)
+	// NeedsRehash reports whether the hash was created with settings other than the
+	// hasher's current ones, and so should be replaced the next time we have the
+	// plaintext password.
+	NeedsRehash(hash []byte) bool
+}
+
+// DefaultPasswordHasher is used to hash all new passwords.
+var DefaultPasswordHasher PasswordHasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// passwordHashers holds the hashers that stored hashes are verified with, in addition
+// to the default one. Bcrypt is included so that the hashes of existing users keep
+// working, and are upgraded when they next log in.
+var passwordHashers = []PasswordHasher{
+	BcryptHasher{Cost: 12},
+}
+
+// RegisterPasswordHasher adds a hasher which stored password hashes can be verified
+// with. It should only be called during initialization.
+func RegisterPasswordHasher(hasher PasswordHasher) {
+	passwordHashers = append(passwordHashers, hasher)
+}
+
+// The hasherFor() helper returns the hasher which recognizes a stored hash, giving
+// preference to the default hasher.
+func hasherFor(hash []byte) (PasswordHasher, error) {
+	if DefaultPasswordHasher.Recognizes(hash) {
+		return DefaultPasswordHasher, nil
+	}
+	for _, hasher := range passwordHashers {
+		if hasher.Recognizes(hash) {
+			return hasher, nil
+		}
+	}
+	return nil, ErrUnknownHashFormat
+}
+
+// The BcryptHasher type hashes passwords with bcrypt. Bcrypt hashes are already
+// self-describing, in the form $2a$12$<salt and hash>. Note that bcrypt only uses the
+// first 72 bytes of a password, which is why Verify() rejects anything longer, rather
+// than letting a password match any other which shares its first 72 bytes.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h BcryptHasher) Recognizes(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) ||
+		bytes.HasPrefix(hash, []byte("$2b$")) ||
+		bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (h BcryptHasher) Verify(hash []byte, plaintext string) (bool, error) {
+	if len(plaintext) > 72 {
+		return false, nil
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+// The Argon2idHasher type hashes passwords with argon2id. Hashes are stored in the PHC
+// string format, $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>, with the salt and key
+// encoded as unpadded base64.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func (h Argon2idHasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+	return []byte(hash), nil
+}
+
+func (h Argon2idHasher) Recognizes(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func (h Argon2idHasher) Verify(hash []byte, plaintext string) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	otherKey := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength ||
+		uint32(len(key)) != h.KeyLength
+}
+
+// The decodeArgon2idHash() helper parses a hash in the PHC string format, returning the
+// parameters it was created with along with the salt and key.
+func decodeArgon2idHash(hash []byte) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 || string(parts[1]) != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Cheap settings, so that the tests run quickly.
+var (
+	testBcryptHasher   = BcryptHasher{Cost: 4}
+	testArgon2idHasher = Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+)
+
+func TestPasswordHashers(t *testing.T) {
+	for _, hasher := range []PasswordHasher{testBcryptHasher, testArgon2idHasher} {
+		hash, err := hasher.Hash("pa55word1234")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasher.Recognizes(hash) {
+			t.Errorf("%T doesn't recognize its own hash %s", hasher, hash)
+		}
+		tests := []struct {
+			plaintext string
+			want      bool
+		}{
+			{"pa55word1234", true},
+			{"pa55word123", false},
+			{"pa55word12345", false},
+			{"", false},
+		}
+		for _, tt := range tests {
+			got, err := hasher.Verify(hash, tt.plaintext)
+			if err != nil {
+				t.Fatalf("%T: Verify(%q): %v", hasher, tt.plaintext, err)
+			}
+			if got != tt.want {
+				t.Errorf("%T: Verify(%q) = %t; want %t", hasher, tt.plaintext, got, tt.want)
+			}
+		}
+	}
+}
+
+// TestBcryptHasherLongPassword checks that a password longer than the 72 bytes bcrypt
+// uses doesn't match the hash of its first 72 bytes.
+func TestBcryptHasherLongPassword(t *testing.T) {
+	prefix := strings.Repeat("a", 72)
+	hash, err := testBcryptHasher.Hash(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plaintext := range []string{prefix + "b", prefix + strings.Repeat("a", 100)} {
+		ok, err := testBcryptHasher.Verify(hash, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("Verify() matched a %d byte password against the hash of its first 72 bytes", len(plaintext))
+		}
+	}
+}
+
+func TestHashersRecognize(t *testing.T) {
+	bcryptHash, err := testBcryptHasher.Hash("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2idHash, err := testArgon2idHasher.Hash("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testBcryptHasher.Recognizes(argon2idHash) {
+		t.Error("BcryptHasher recognizes an argon2id hash")
+	}
+	if testArgon2idHasher.Recognizes(bcryptHash) {
+		t.Error("Argon2idHasher recognizes a bcrypt hash")
+	}
+	_, err = testArgon2idHasher.Verify([]byte("$argon2id$v=19$m=1024"), "pa55word1234")
+	if err == nil {
+		t.Error("Verify() accepted a truncated argon2id hash")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := testBcryptHasher.Hash("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2idHash, err := testArgon2idHasher.Hash("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moreMemory := testArgon2idHasher
+	moreMemory.Memory *= 2
+	moreIterations := testArgon2idHasher
+	moreIterations.Iterations++
+	moreParallelism := testArgon2idHasher
+	moreParallelism.Parallelism++
+	longerSalt := testArgon2idHasher
+	longerSalt.SaltLength *= 2
+	longerKey := testArgon2idHasher
+	longerKey.KeyLength *= 2
+
+	tests := []struct {
+		name   string
+		hasher PasswordHasher
+		hash   []byte
+		want   bool
+	}{
+		{"bcrypt same cost", testBcryptHasher, bcryptHash, false},
+		{"bcrypt higher cost", BcryptHasher{Cost: 5}, bcryptHash, true},
+		{"bcrypt malformed", testBcryptHasher, []byte("$2a$"), true},
+		{"argon2id same settings", testArgon2idHasher, argon2idHash, false},
+		{"argon2id more memory", moreMemory, argon2idHash, true},
+		{"argon2id more iterations", moreIterations, argon2idHash, true},
+		{"argon2id more parallelism", moreParallelism, argon2idHash, true},
+		{"argon2id longer salt", longerSalt, argon2idHash, true},
+		{"argon2id longer key", longerKey, argon2idHash, true},
+		{"argon2id malformed", testArgon2idHasher, []byte("$argon2id$v=19"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasherFor(t *testing.T) {
+	bcryptHash, err := testBcryptHasher.Hash("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := hasherFor(bcryptHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hasher.(BcryptHasher); !ok {
+		t.Errorf("got %T for a bcrypt hash; want BcryptHasher", hasher)
+	}
+	_, err = hasherFor([]byte("$1$md5crypt"))
+	if !errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("got error %v for an unknown hash; want %v", err, ErrUnknownHashFormat)
+	}
+}
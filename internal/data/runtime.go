@@ -3,6 +3,7 @@ package data
 import (
 	"errors"
 	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
 	"strconv"
 	"strings"
 )
@@ -60,3 +61,19 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	*r = Runtime(i)
 	return nil
 }
+
+// Implement the msgpack.CustomEncoder and msgpack.CustomDecoder interfaces, so that a
+// Runtime is encoded as the same "<runtime> mins" string in MessagePack responses as
+// it is in JSON. (The XML and CSV encoders work from the JSON representation, so they
+// use MarshalJSON() above.)
+func (r Runtime) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.EncodeString(fmt.Sprintf("%d mins", r))
+}
+
+func (r *Runtime) DecodeMsgpack(dec *msgpack.Decoder) error {
+	s, err := dec.DecodeString()
+	if err != nil {
+		return ErrInvalidRuntimeFormat
+	}
+	return r.UnmarshalJSON([]byte(strconv.Quote(s)))
+}
@@ -15,8 +15,26 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication" // Include a new authentication scope.
+	// The 2fa-pending scope is issued after a correct password for accounts with
+	// two-factor authentication enabled, and can only be exchanged for an
+	// authentication token along with a valid two-factor code.
+	ScopeTwoFactorPending = "2fa-pending"
+	// The email-change scope confirms that a user controls the new address before we
+	// switch their account over to it.
+	ScopeEmailChange = "email-change"
+	// The password-reset scope lets a user choose a new password after an
+	// administrator has forced a reset.
+	ScopePasswordReset = "password-reset"
+	// The impersonation scope is issued to an administrator to act as another user,
+	// for example to reproduce a problem that they have reported. It authenticates
+	// requests like an authentication token, except on account management endpoints.
+	ScopeImpersonation = "impersonation"
 )
 
+// SessionScopes are the scopes of the tokens which authenticate requests, or can be
+// exchanged for a token that does. Deleting them logs a user out everywhere.
+var SessionScopes = []string{ScopeAuthentication, ScopeTwoFactorPending, ScopeImpersonation}
+
 // never use math/rand for cryptographic, unless you need speed in certain scenarios
 
 // Define a Token struct to hold the data for an individual token. This includes the
@@ -69,8 +87,8 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 
 // Check that the plaintext token has been provided and is exactly 26 bytes long.
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.CheckField(tokenPlaintext != "", "token", validator.Required())
+	v.CheckField(len(tokenPlaintext) == 26, "token", validator.Length(26))
 }
 
 // Define the TokenModel type.
@@ -89,15 +107,50 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewWithAudit() creates a new token like New(), and records it in the audit log in
+// the same transaction, so that a token for an administrative action (such as
+// impersonating a user) can't be issued without the audit log saying so.
+func (m TokenModel) NewWithAudit(userID int64, ttl time.Duration, scope string, entry *AuditEntry) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	err = insertToken(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+	entry.TargetUserID = userID
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
+	return token, tx.Commit()
+}
+
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertToken(ctx, m.DB, token)
+}
+
+// The insertToken() function adds a token using either the connection pool or a
+// transaction.
+func insertToken(ctx context.Context, db interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}, token *Token) error {
 	query := `
         INSERT INTO tokens (hash, user_id, expiry, scope) 
         VALUES ($1, $2, $3, $4)`
 	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := db.ExecContext(ctx, query, args...)
 	return err
 }
 
@@ -111,3 +164,18 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteExpired() deletes all of the expired tokens (in any scope), returning the
+// number of tokens deleted.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+        DELETE FROM tokens
+        WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"strings"
+	"time"
+)
+
+// The number of recovery codes that we issue when two-factor authentication is
+// enabled.
+const recoveryCodeCount = 10
+
+// Define a TwoFactor struct to hold the TOTP settings for a user. The secret is only
+// used for generating and checking codes, so it must never appear in any JSON output.
+// Enabled is false until the user has proven that their authenticator app is set up
+// by verifying a code, and LastUsedStep records the time step of the most recently
+// accepted code so that a code can't be replayed.
+type TwoFactor struct {
+	UserID       int64     `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       []byte    `json:"-"`
+	Enabled      bool      `json:"enabled"`
+	LastUsedStep int64     `json:"-"`
+}
+
+// Check that a two-factor code has been provided and is a sensible length. The code
+// may either be a TOTP code or one of the user's recovery codes.
+func ValidateTwoFactorCode(v *validator.Validator, code string) {
+	v.CheckField(code != "", "code", validator.Required())
+	v.CheckField(len(code) <= 32, "code", validator.MaxLength(32))
+}
+
+// NormalizeRecoveryCode converts a recovery code entered by a user into the canonical
+// form that we hash, so that case, spaces and hyphens don't matter.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// The generateRecoveryCode() function returns a new random recovery code in the form
+// XXXXX-XXXXX, along with the SHA-256 hash of its normalized form.
+func generateRecoveryCode() (string, []byte, error) {
+	randomBytes := make([]byte, 8)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)[:10]
+	hash := sha256.Sum256([]byte(code))
+	return code[:5] + "-" + code[5:], hash[:], nil
+}
+
+// Define the TwoFactorModel type.
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Get() returns the two-factor settings for a user, or ErrRecordNotFound if the user
+// has never started enrollment.
+func (m TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+        SELECT user_id, created_at, secret, enabled, last_used_step
+        FROM users_two_factor
+        WHERE user_id = $1`
+	var twoFactor TwoFactor
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&twoFactor.UserID,
+		&twoFactor.CreatedAt,
+		&twoFactor.Secret,
+		&twoFactor.Enabled,
+		&twoFactor.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &twoFactor, nil
+}
+
+// Enroll() stores a new (not yet enabled) secret for a user. If the user already has
+// a pending enrollment then its secret is replaced, but an enabled configuration is
+// never overwritten and ErrEditConflict is returned instead.
+func (m TwoFactorModel) Enroll(twoFactor *TwoFactor) error {
+	query := `
+        INSERT INTO users_two_factor (user_id, secret)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+        WHERE users_two_factor.enabled = false
+        RETURNING created_at, enabled`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, twoFactor.UserID, twoFactor.Secret).Scan(&twoFactor.CreatedAt, &twoFactor.Enabled)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// UseStep() records that the code for a particular time step has been used. Because
+// the update only succeeds if the step is later than the last one used, this returns
+// ErrEditConflict if the code has been used before (or a later one already has).
+func (m TwoFactorModel) UseStep(userID int64, step int64) error {
+	query := `
+        UPDATE users_two_factor
+        SET last_used_step = $2
+        WHERE user_id = $1 AND last_used_step < $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Delete() removes the two-factor configuration and all recovery codes for a user.
+func (m TwoFactorModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_two_factor WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Enable() marks a user's two-factor configuration as enabled and issues a fresh set of
+// recovery codes, returning the plaintext codes. Only the hashes are stored, so this is
+// the one and only time that the plaintext codes are available. Both happen in one
+// transaction, so that two-factor authentication is never enabled without recovery
+// codes. If the configuration is already enabled, ErrEditConflict is returned.
+func (m TwoFactorModel) Enable(userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `UPDATE users_two_factor SET enabled = true WHERE user_id = $1 AND NOT enabled`, userID)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, hash, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash, userID)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode() checks a recovery code for a user and, if it is valid, deletes it
+// so that it can't be used again. It returns false if the code doesn't match.
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(NormalizeRecoveryCode(code)))
+	query := `
+        DELETE FROM recovery_codes
+        WHERE hash = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, hash[:], userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
@@ -2,10 +2,13 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"errors"
-	"golang.org/x/crypto/bcrypt"
+	"fmt"
+	"github.com/lib/pq"
 	"greenlight.m4rk1sov.github.com/internal/validator"
 	"time"
 )
@@ -46,10 +49,10 @@ type password struct {
 	hash      []byte
 }
 
-// The Set() method calculates the bcrypt hash of a plaintext password, and stores both
-// the hash and the plaintext versions in the struct.
+// The Set() method calculates the hash of a plaintext password using the default
+// password hasher, and stores both the hash and the plaintext versions in the struct.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := DefaultPasswordHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -58,34 +61,48 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// The SetRandom() method replaces the password with a random one which is never shown
+// to anybody. We use this when an account shouldn't be usable with a password, such as
+// when an administrator forces a password reset.
+func (p *password) SetRandom() error {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	return p.Set(base64.RawURLEncoding.EncodeToString(randomBytes))
+}
+
 // The Matches() method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
-// otherwise.
+// otherwise. The hash is verified with whichever algorithm created it.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	hasher, err := hasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
 	}
-	return true, nil
+	return hasher.Verify(p.hash, plaintextPassword)
+}
+
+// The NeedsRehash() method reports whether the stored hash was created by an algorithm
+// or with settings other than those of the default password hasher. If so, the hash
+// should be replaced after the next successful password check.
+func (p *password) NeedsRehash() bool {
+	return !DefaultPasswordHasher.Recognizes(p.hash) || DefaultPasswordHasher.NeedsRehash(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.CheckField(email != "", "email", validator.Required())
+	v.CheckField(validator.Matches(email, validator.EmailRX), "email", validator.Format("email").WithMessage("must be a valid email address"))
 }
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.CheckField(password != "", "password", validator.Required())
+	v.CheckField(len(password) >= 8, "password", validator.MinLength(8))
+	v.CheckField(len(password) <= 256, "password", validator.MaxLength(256))
 }
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.CheckField(user.Name != "", "name", validator.Required())
+	v.CheckField(len(user.Name) <= 500, "name", validator.MaxLength(500))
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
 	// If the plaintext password is not nil, call the standalone
@@ -108,6 +125,9 @@ type UserModel struct {
 	DB *sql.DB
 }
 
+// The values that the sort parameter can take when listing users.
+var UserSortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
 // Insert a new record in the database for the user. Note that the id, created_at and
 // version fields are all automatically generated by our database, so we use the
 // RETURNING clause to read them into the User struct after the insert, in the same way
@@ -136,6 +156,39 @@ VALUES ($1, $2, $3, $4)
 	return nil
 }
 
+// Retrieve the User details from the database based on the user's ID, returning a
+// ErrRecordNotFound error if there is no matching record.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated, version
+        FROM users
+        WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
@@ -167,12 +220,117 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetAll() returns a page of users matching the provided filters. A nil activated
+// value matches users in either state, an empty email matches any address (otherwise
+// it is a case-insensitive substring match), and zero createdAfter or createdBefore
+// times leave that end of the creation date range open.
+func (m UserModel) GetAll(activated *bool, email string, createdAfter, createdBefore time.Time, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, version
+        FROM users
+        WHERE ($1::boolean IS NULL OR activated = $1)
+        AND (strpos(lower(email), lower($2)) > 0 OR $2 = '')
+        AND ($3::timestamptz IS NULL OR created_at >= $3)
+        AND ($4::timestamptz IS NULL OR created_at < $4)
+        ORDER BY %s %s, id ASC
+        LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+	var after, before sql.NullTime
+	if !createdAfter.IsZero() {
+		after = sql.NullTime{Time: createdAfter, Valid: true}
+	}
+	if !createdBefore.IsZero() {
+		before = sql.NullTime{Time: createdBefore, Valid: true}
+	}
+	args := []any{activated, email, after, before, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
 // Update the details for a specific user. Notice that we check against the version
 // field to help prevent any race conditions during the request cycle, just like we did
 // when updating a movie. And we also check for a violation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
 func (m UserModel) Update(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return updateUser(ctx, m.DB, user)
+}
+
+// UpdateWithAudit() updates a user like Update(), and records the change in the audit
+// log in the same transaction. The user's tokens in the given scopes, and their API
+// keys if deleteAPIKeys is true, are deleted in the transaction too, so that an
+// administrator can't log a user out (or lock them out) without the audit log saying
+// so, or half do it.
+func (m UserModel) UpdateWithAudit(user *User, entry *AuditEntry, scopes []string, deleteAPIKeys bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = updateUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+	if len(scopes) > 0 {
+		query := `
+            DELETE FROM tokens
+            WHERE user_id = $1 AND scope = ANY($2)`
+		_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(scopes))
+		if err != nil {
+			return err
+		}
+	}
+	if deleteAPIKeys {
+		_, err = tx.ExecContext(ctx, `DELETE FROM api_keys WHERE user_id = $1`, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+	entry.TargetUserID = user.ID
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The updateUser() function updates a user using either the connection pool or a
+// transaction.
+func updateUser(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, user *User) error {
 	query := `
         UPDATE users 
         SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -186,9 +344,7 @@ func (m UserModel) Update(user *User) error {
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -202,6 +358,117 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// Delete a user. The tokens, permissions and other records belonging to the user are
+// removed by the ON DELETE CASCADE constraints on their tables.
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+        DELETE FROM users
+        WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteWithAudit() deletes a user and records the deletion in the audit log, in a
+// single transaction, so that a user can't be deleted without the audit log saying
+// so. The entry's target and details are filled in from the deleted record.
+func (m UserModel) DeleteWithAudit(id int64, entry *AuditEntry) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+        DELETE FROM users
+        WHERE id = $1
+        RETURNING id, created_at, name, email, activated`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var user User
+	err = tx.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	recordDeletion(entry, &user)
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUnactivated() deletes the accounts which were registered before the given time
+// but never activated, returning the deleted users. An account which was deactivated
+// by an administrator also has activated = false, but it has an entry in the audit log
+// saying so, and accounts with any audit log entries are left alone. Like
+// DeleteWithAudit(), each deletion is recorded in the audit log in the same
+// transaction, with a copy of the given entry.
+func (m UserModel) DeleteUnactivated(createdBefore time.Time, entry AuditEntry) ([]*User, error) {
+	query := `
+        DELETE FROM users
+        WHERE NOT activated
+        AND created_at < $1
+        AND NOT EXISTS (SELECT 1 FROM audit_log WHERE audit_log.target_user_id = users.id)
+        RETURNING id, created_at, name, email, activated`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, query, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// The rows have to be closed before the transaction can be used again.
+	rows.Close()
+	for _, user := range users {
+		userEntry := entry
+		recordDeletion(&userEntry, user)
+		err = insertAuditEntry(ctx, tx, &userEntry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return users, tx.Commit()
+}
+
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"greenlight.m4rk1sov.github.com/internal/validator"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The hand-written validation functions which the validate struct tags replaced. The
+// tests below check that the tags produce exactly the same errors, so that clients see
+// no difference.
+
+func legacyValidateMovie(v *validator.Validator, movie *Movie) {
+	v.CheckField(movie.Title != "", "title", validator.Required())
+	v.CheckField(len(movie.Title) <= 500, "title", validator.MaxLength(500))
+	v.CheckField(movie.Year != 0, "year", validator.Required())
+	v.CheckField(movie.Year >= 1888, "year", validator.Min(1888).WithMessage("must be greater than 1888"))
+	currentYear := time.Now().Year()
+	v.CheckField(movie.Year <= int32(currentYear), "year", validator.Max(int64(currentYear)).WithMessage("must not be in the future"))
+	v.CheckField(movie.Runtime != 0, "runtime", validator.Required())
+	v.CheckField(movie.Runtime > 0, "runtime", validator.Min(1).WithMessage("must be a positive integer"))
+	v.CheckField(movie.Genres != nil, "genres", validator.Required())
+	v.CheckField(len(movie.Genres) >= 1, "genres", validator.MinItems(1).WithMessage("must contain at least 1 genre"))
+	v.CheckField(len(movie.Genres) <= 5, "genres", validator.MaxItems(5).WithMessage("must not contain more than 5 genres"))
+	v.CheckField(validator.Unique(movie.Genres), "genres", validator.UniqueItems())
+	for i, genre := range movie.Genres {
+		v.CheckField(genre != "", validator.Index("genres", i), validator.Required())
+	}
+}
+
+func legacyValidateModule(v *validator.Validator, module *Module_info) {
+	v.CheckField(module.ModuleName != "", "moduleName", validator.Required())
+	v.CheckField(len(module.ModuleName) <= 500, "moduleName", validator.MaxLength(500))
+	v.CheckField(module.ModuleDuration != 0, "moduleDuration", validator.Required())
+	v.CheckField(module.ModuleDuration > 0, "moduleDuration", validator.Min(1).WithMessage("must be a positive integer"))
+	v.CheckField(module.ExamType != "", "examType", validator.Required())
+}
+
+func legacyValidateDepartment(v *validator.Validator, department *DepartmentInfo) {
+	v.CheckField(department.DepartmentName != "", "departmentName", validator.Required())
+	v.CheckField(len(department.DepartmentName) <= 500, "departmentName", validator.MaxLength(500))
+	v.CheckField(department.StaffQuantity != 0, "staffQuantity", validator.Required())
+	v.CheckField(department.StaffQuantity > 0, "staffQuantity", validator.Min(1).WithMessage("must be a positive integer"))
+	v.CheckField(department.DepartmentDirector != "", "departmentDirector", validator.Required())
+}
+
+// The sameErrors() helper runs a legacy validation function and its replacement on the
+// same value, and reports any difference in the errors.
+func sameErrors[T any](t *testing.T, value *T, legacy, tags func(*validator.Validator, *T)) {
+	t.Helper()
+	want, got := validator.New(), validator.New()
+	legacy(want, value)
+	tags(got, value)
+	if !reflect.DeepEqual(got.Errors, want.Errors) {
+		t.Errorf("%+v:\ngot errors  %v\nwant errors %v", *value, got.Errors, want.Errors)
+	}
+}
+
+func TestValidateMovieMatchesLegacy(t *testing.T) {
+	valid := Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}}
+	tests := []func(m *Movie){
+		func(m *Movie) {},
+		func(m *Movie) { *m = Movie{} },
+		func(m *Movie) { m.Title = strings.Repeat("a", 500) },
+		func(m *Movie) { m.Title = strings.Repeat("a", 501) },
+		func(m *Movie) { m.Year = 1887 },
+		func(m *Movie) { m.Year = 1888 },
+		func(m *Movie) { m.Year = int32(time.Now().Year()) },
+		func(m *Movie) { m.Year = int32(time.Now().Year() + 1) },
+		func(m *Movie) { m.Year = -1 },
+		func(m *Movie) { m.Runtime = -5 },
+		func(m *Movie) { m.Genres = nil },
+		func(m *Movie) { m.Genres = []string{} },
+		func(m *Movie) { m.Genres = []string{"a", "b", "c", "d", "e", "f"} },
+		func(m *Movie) { m.Genres = []string{"drama", "drama"} },
+		func(m *Movie) { m.Genres = []string{""} },
+		func(m *Movie) { m.Genres = []string{"drama", "", ""} },
+		func(m *Movie) { m.Genres = []string{"", "", "", "", "", ""} },
+	}
+	for _, change := range tests {
+		movie := valid
+		movie.Genres = append([]string(nil), valid.Genres...)
+		change(&movie)
+		sameErrors(t, &movie, legacyValidateMovie, ValidateMovie)
+	}
+}
+
+func TestValidateModuleMatchesLegacy(t *testing.T) {
+	valid := Module_info{ModuleName: "Databases", ModuleDuration: 10, ExamType: "written"}
+	tests := []func(m *Module_info){
+		func(m *Module_info) {},
+		func(m *Module_info) { *m = Module_info{} },
+		func(m *Module_info) { m.ModuleName = strings.Repeat("a", 501) },
+		func(m *Module_info) { m.ModuleDuration = 0 },
+		func(m *Module_info) { m.ModuleDuration = 5 },
+		func(m *Module_info) { m.ModuleDuration = 15 },
+		func(m *Module_info) { m.ExamType = "" },
+	}
+	for _, change := range tests {
+		module := valid
+		change(&module)
+		sameErrors(t, &module, legacyValidateModule, ValidateModule)
+	}
+}
+
+// TestValidateModuleDuration pins the one deliberate difference from the legacy
+// validation: the module duration must be between 5 and 15, as the database requires,
+// rather than just positive.
+func TestValidateModuleDuration(t *testing.T) {
+	tests := []struct {
+		duration Runtime
+		want     []validator.Error
+	}{
+		{-1, []validator.Error{validator.Min(5)}},
+		{4, []validator.Error{validator.Min(5)}},
+		{16, []validator.Error{validator.Max(15)}},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateModule(v, &Module_info{ModuleName: "Databases", ModuleDuration: tt.duration, ExamType: "written"})
+		want := validator.Errors{"moduleDuration": tt.want}
+		if !reflect.DeepEqual(v.Errors, want) {
+			t.Errorf("duration %d: got errors %v; want %v", tt.duration, v.Errors, want)
+		}
+	}
+}
+
+func TestValidateDepartmentMatchesLegacy(t *testing.T) {
+	valid := DepartmentInfo{DepartmentName: "Computer Science", StaffQuantity: 12, DepartmentDirector: "Ada Lovelace"}
+	tests := []func(d *DepartmentInfo){
+		func(d *DepartmentInfo) {},
+		func(d *DepartmentInfo) { *d = DepartmentInfo{} },
+		func(d *DepartmentInfo) { d.DepartmentName = strings.Repeat("a", 500) },
+		func(d *DepartmentInfo) { d.DepartmentName = strings.Repeat("a", 501) },
+		func(d *DepartmentInfo) { d.StaffQuantity = -3 },
+		func(d *DepartmentInfo) { d.StaffQuantity = 1 },
+		func(d *DepartmentInfo) { d.DepartmentDirector = "" },
+	}
+	for _, change := range tests {
+		department := valid
+		change(&department)
+		sameErrors(t, &department, legacyValidateDepartment, ValidateDepartment)
+	}
+}
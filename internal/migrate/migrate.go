@@ -0,0 +1,433 @@
+// Package migrate applies the embedded SQL migrations to the database. Migrations are
+// files named like 000001_create_movies_table.up.sql (with a matching .down.sql file),
+// and can come from several directories, as long as their versions don't collide: they
+// are applied as a single sequence, in version order.
+//
+// The applied versions are recorded in the schema_versions table. All of the operations
+// hold a PostgreSQL advisory lock, so that several instances of the API starting at once
+// with -migrate-on-start don't try to apply the same migrations.
+//
+// Databases which were migrated with the external migrate tool have a schema_migrations
+// table instead, which records only the latest version applied. The first time a
+// Migrator runs against one, it adopts that version, recording every migration up to
+// and including it as applied. This is only safe from version 6 upwards: the
+// migrations2 directory used to be numbered from 1 to 3 too, so a lower version doesn't
+// say which of the two directories' migrations were applied, and a dirty version says
+// that the last migration only partly applied. In either case the Migrator refuses to
+// run, returning ErrLegacyVersion, until the operator has checked the schema and
+// recorded the latest migration which is fully applied with Adopt.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The key for the advisory lock, which is arbitrary but must be the same for every
+// instance.
+const lockKey = 7_433_108_512
+
+// Each migration has to finish within this time.
+const migrationTimeout = 5 * time.Minute
+
+var filenameRX = regexp.MustCompile(`^([0-9]+)_(.+)\.(up|down)\.sql$`)
+
+// ErrUnknownVersion is returned by To if there's no migration with the version.
+var ErrUnknownVersion = errors.New("migrate: unknown version")
+
+// ErrLegacyVersion is returned if the version in the external migrate tool's
+// schema_migrations table can't be adopted safely (see the package documentation).
+var ErrLegacyVersion = errors.New("migrate: the version in schema_migrations can't be adopted")
+
+// ErrAlreadyAdopted is returned by Adopt if schema_versions already records applied
+// migrations.
+var ErrAlreadyAdopted = errors.New("migrate: schema_versions already records applied migrations")
+
+// Legacy versions below this one are ambiguous, as both directories used them.
+const minLegacyVersion = 6
+
+// A Migration is a single version of the schema.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// A Status describes a migration and whether it has been applied.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load reads the migrations from the given file systems, returning them in version
+// order. It returns an error if two migrations have the same version, or if either
+// half of a migration is missing.
+func Load(sources ...fs.FS) ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+	for _, source := range sources {
+		entries, err := fs.ReadDir(source, ".")
+		if err != nil {
+			return nil, err
+		}
+		// Check for collisions within this source separately, so that an up migration
+		// in one directory can't be paired with a down migration in another.
+		seen := make(map[int64]bool)
+		for _, entry := range entries {
+			matches := filenameRX.FindStringSubmatch(entry.Name())
+			if entry.IsDir() || matches == nil {
+				continue
+			}
+			version, err := strconv.ParseInt(matches[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+			}
+			sql, err := fs.ReadFile(source, entry.Name())
+			if err != nil {
+				return nil, err
+			}
+			m, exists := byVersion[version]
+			switch {
+			case !exists:
+				m = &Migration{Version: version, Name: matches[2]}
+				byVersion[version] = m
+			case !seen[version] || m.Name != matches[2]:
+				return nil, fmt.Errorf("migrate: version %d is used by more than one migration", version)
+			}
+			seen[version] = true
+			if matches[3] == "up" {
+				m.Up = string(sql)
+			} else {
+				m.Down = string(sql)
+			}
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrate: migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// A Migrator applies and rolls back migrations.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// Logf, if set, is called with a message for each migration applied or rolled back.
+	Logf func(format string, args ...any)
+}
+
+// New returns a Migrator for the migrations in the given file systems.
+func New(db *sql.DB, sources ...fs.FS) (*Migrator, error) {
+	migrations, err := Load(sources...)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Status returns every migration, and whether it has been applied.
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(true, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations {
+			appliedAt, ok := applied[migration.Version]
+			statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Up applies all of the migrations which haven't been applied yet, returning the
+// number applied.
+func (m *Migrator) Up() (int, error) {
+	return m.migrate(func(Migration) bool { return true }, func(Migration) bool { return false })
+}
+
+// Down rolls back the most recently applied migration, returning the number rolled
+// back (which is 0 if none have been applied).
+func (m *Migrator) Down() (int, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return 0, err
+	}
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].Applied {
+			version := statuses[i].Version
+			return m.migrate(func(Migration) bool { return false }, func(mg Migration) bool { return mg.Version == version })
+		}
+	}
+	return 0, nil
+}
+
+// To migrates the schema to the given version, applying the migrations up to and
+// including it and rolling back any after it. Version 0 rolls back every migration.
+// It returns the number of migrations applied or rolled back.
+func (m *Migrator) To(version int64) (int, error) {
+	if version != 0 && !m.has(version) {
+		return 0, fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+	return m.migrate(
+		func(mg Migration) bool { return mg.Version <= version },
+		func(mg Migration) bool { return mg.Version > version },
+	)
+}
+
+// Adopt records the migrations up to and including the given version as applied,
+// without running them, for a database whose schema_migrations table can't be adopted
+// automatically. It returns ErrAlreadyAdopted if any migrations are already recorded,
+// as it's only meant for bringing such a database under the Migrator's control.
+func (m *Migrator) Adopt(version int64) (int, error) {
+	if !m.has(version) {
+		return 0, fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+	count := 0
+	err := m.withLock(false, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) > 0 {
+			return ErrAlreadyAdopted
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		count, err = m.recordApplied(ctx, tx, version)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (m *Migrator) has(version int64) bool {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// The migrate() method applies the pending migrations for which up returns true, in
+// version order, after rolling back the applied migrations for which down returns
+// true, in reverse order. Each migration runs in its own transaction, along with the
+// change to schema_versions, so a failed migration leaves no trace.
+func (m *Migrator) migrate(up, down func(Migration) bool) (int, error) {
+	count := 0
+	err := m.withLock(true, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			migration := m.Migrations[i]
+			if _, ok := applied[migration.Version]; !ok || !down(migration) {
+				continue
+			}
+			err := run(conn, migration.Down, `DELETE FROM schema_versions WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: rolling back %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			m.logf("rolled back %d_%s", migration.Version, migration.Name)
+			count++
+		}
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; ok || !up(migration) {
+				continue
+			}
+			err := run(conn, migration.Up, `INSERT INTO schema_versions (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migrate: applying %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			m.logf("applied %d_%s", migration.Version, migration.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// The run() function runs the SQL for a migration and the statement which records it,
+// in a transaction.
+func run(conn *sql.Conn, migrationSQL, record string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, migrationSQL)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) logf(format string, args ...any) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+// The withLock() method runs fn on a single connection while holding the advisory lock,
+// after making sure that the schema_versions table exists. If adoptLegacy is true, a
+// new table is filled in from the external migrate tool's schema_migrations table.
+func (m *Migrator) withLock(adoptLegacy bool, fn func(conn *sql.Conn) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	err = m.createVersionsTable(ctx, conn, adoptLegacy)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// The createVersionsTable() method creates the schema_versions table if it doesn't
+// exist yet. If adoptLegacy is true and the database was migrated with the external
+// migrate tool, the migrations up to the version in its schema_migrations table are
+// recorded as applied, so that they aren't applied again, or ErrLegacyVersion is
+// returned if that version can't be trusted.
+func (m *Migrator) createVersionsTable(ctx context.Context, conn *sql.Conn, adoptLegacy bool) error {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_versions') IS NOT NULL`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+        CREATE TABLE schema_versions (
+            version bigint PRIMARY KEY,
+            name text NOT NULL,
+            applied_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+        )`)
+	if err != nil {
+		return err
+	}
+	if adoptLegacy {
+		err = m.adoptLegacyVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// The adoptLegacyVersion() method records the migrations up to the version in the
+// external migrate tool's schema_migrations table, if there is one, as applied.
+func (m *Migrator) adoptLegacyVersion(ctx context.Context, tx *sql.Tx) error {
+	var legacy bool
+	err := tx.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&legacy)
+	if err != nil || !legacy {
+		return err
+	}
+	var version int64
+	var dirty bool
+	err = tx.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		// The migrate tool leaves the table empty once every migration has been
+		// rolled back.
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		default:
+			return err
+		}
+	}
+	switch {
+	case dirty:
+		return fmt.Errorf("%w: version %d is dirty, so it may only be partly applied", ErrLegacyVersion, version)
+	case version < minLegacyVersion:
+		return fmt.Errorf("%w: version %d may be from either migrations directory", ErrLegacyVersion, version)
+	}
+	_, err = m.recordApplied(ctx, tx, version)
+	return err
+}
+
+// The recordApplied() method records the migrations up to and including the given
+// version as applied, without running them, returning the number recorded.
+func (m *Migrator) recordApplied(ctx context.Context, tx *sql.Tx, version int64) (int, error) {
+	count := 0
+	for _, migration := range m.Migrations {
+		if migration.Version > version {
+			break
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO schema_versions (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+		if err != nil {
+			return count, err
+		}
+		m.logf("adopted %d_%s", migration.Version, migration.Name)
+		count++
+	}
+	return count, nil
+}
+
+// The appliedVersions() function returns the applied versions and when they were
+// applied.
+func appliedVersions(conn *sql.Conn) (map[int64]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_versions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
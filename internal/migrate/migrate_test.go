@@ -0,0 +1,157 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestLoad(t *testing.T) {
+	first := fstest.MapFS{
+		"000001_create_movies.up.sql":   {Data: []byte("up 1")},
+		"000001_create_movies.down.sql": {Data: []byte("down 1")},
+		"000003_create_users.up.sql":    {Data: []byte("up 3")},
+		"000003_create_users.down.sql":  {Data: []byte("down 3")},
+		"embed.go":                      {Data: []byte("package migrations")},
+	}
+	second := fstest.MapFS{
+		"000002_create_modules.up.sql":   {Data: []byte("up 2")},
+		"000002_create_modules.down.sql": {Data: []byte("down 2")},
+	}
+
+	migrations, err := Load(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range migrations {
+		got = append(got, fmt.Sprintf("%d_%s", m.Version, m.Name))
+	}
+	if strings.Join(got, " ") != "1_create_movies 2_create_modules 3_create_users" {
+		t.Errorf("got migrations %v; want them in version order", got)
+	}
+
+	collision := fstest.MapFS{
+		"000001_create_modules.up.sql":   {Data: []byte("up")},
+		"000001_create_modules.down.sql": {Data: []byte("down")},
+	}
+	_, err = Load(first, collision)
+	if err == nil {
+		t.Error("got no error for two migrations with the same version")
+	}
+	_, err = Load(fstest.MapFS{"000001_create_movies.up.sql": {Data: []byte("up")}})
+	if err == nil {
+		t.Error("got no error for a migration without a down file")
+	}
+}
+
+// TestAdoptLegacyVersion needs a PostgreSQL database given by the
+// GREENLIGHT_TEST_DB_DSN environment variable. It works in a schema of its own, which
+// it drops again afterwards.
+func TestAdoptLegacyVersion(t *testing.T) {
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+	migrations := make([]Migration, 8)
+	for i := range migrations {
+		migrations[i] = Migration{Version: int64(i + 1), Name: "noop", Up: "SELECT 1", Down: "SELECT 1"}
+	}
+
+	tests := []struct {
+		name        string
+		version     int64
+		dirty       bool
+		wantApplied int
+		wantErr     error
+	}{
+		{"adopted", 6, false, 6, nil},
+		{"ambiguous", 3, false, 0, ErrLegacyVersion},
+		{"dirty", 7, true, 0, ErrLegacyVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestSchema(t, dsn)
+			_, err := db.Exec(`CREATE TABLE schema_migrations (version bigint NOT NULL, dirty boolean NOT NULL)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec(`INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, tt.version, tt.dirty)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &Migrator{DB: db, Migrations: migrations}
+
+			statuses, err := m.Status()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				// The operator records the version by hand, after which the
+				// migrations work as usual.
+				count, err := m.Adopt(5)
+				if err != nil || count != 5 {
+					t.Fatalf("adopted %d migrations with error %v; want 5", count, err)
+				}
+				_, err = m.Adopt(5)
+				if !errors.Is(err, ErrAlreadyAdopted) {
+					t.Errorf("got error %v adopting again; want %v", err, ErrAlreadyAdopted)
+				}
+				statuses, err = m.Status()
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.wantApplied = 5
+			}
+			applied := 0
+			for _, status := range statuses {
+				if status.Applied {
+					applied++
+				}
+			}
+			if applied != tt.wantApplied {
+				t.Errorf("got %d applied migrations; want %d", applied, tt.wantApplied)
+			}
+		})
+	}
+}
+
+// The newTestSchema() helper returns a connection pool which uses a new, empty schema.
+func newTestSchema(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	// Unknown settings in the DSN are passed on to PostgreSQL as run-time parameters.
+	if strings.Contains(dsn, "://") {
+		if strings.Contains(dsn, "?") {
+			dsn += "&search_path=" + schema
+		} else {
+			dsn += "?search_path=" + schema
+		}
+	} else {
+		dsn += " search_path=" + schema
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,286 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "greenlight"
+	testNonce    = "nonce-123"
+)
+
+// testIdP is an identity provider which serves a discovery document, a key set and a
+// token endpoint. The token endpoint returns whatever ID token the test has set.
+type testIdP struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey
+	idToken   string
+	code      string
+	verifier  string
+	jwksCalls int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	idp := &testIdP{t: t, keys: map[string]*rsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwksCalls++
+		var keys []map[string]string
+		for kid, key := range idp.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		if r.PostFormValue("code") != idp.code || challengeS256(r.PostFormValue("code_verifier")) != challengeS256(idp.verifier) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// rotate replaces the provider's signing keys with a new key with the given ID.
+func (idp *testIdP) rotate(kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keys = map[string]*rsa.PrivateKey{kid: key}
+	return key
+}
+
+// claims returns a valid set of claims for the provider, which tests can modify.
+func (idp *testIdP) claims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            idp.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+}
+
+// sign returns an RS256 ID token for the claims, signed with the key and labelled
+// with the key ID.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := newTestIdP(t)
+	p := New(idp.URL, testClientID, "", "https://api.example.com/v1/oidc/callback")
+	authURL, err := p.AuthCodeURL(context.Background(), "state-1", testNonce, "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != idp.URL+"/authorize" {
+		t.Errorf("got endpoint %q; want %q", got, idp.URL+"/authorize")
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"state":                 "state-1",
+		"nonce":                 testNonce,
+		"code_challenge":        challengeS256("verifier-1"),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := u.Query().Get(name); got != value {
+			t.Errorf("got %s=%q; want %q", name, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newTestIdP(t)
+	key := idp.rotate("key-1")
+	idp.code, idp.verifier = "code-1", "verifier-1"
+	idp.idToken = sign(t, key, "key-1", idp.claims())
+	p := New(idp.URL, testClientID, "secret", "https://api.example.com/v1/oidc/callback")
+
+	claims, err := p.Exchange(context.Background(), "code-1", "verifier-1", testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	// The token endpoint checks the PKCE code verifier, so the wrong one is refused.
+	_, err = p.Exchange(context.Background(), "code-1", "verifier-2", testNonce)
+	if !errors.Is(err, ErrExchangeRejected) {
+		t.Errorf("got error %v; want %v", err, ErrExchangeRejected)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	idp := newTestIdP(t)
+	key := idp.rotate("key-1")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(name string, value any) map[string]any {
+		claims := idp.claims()
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		nonce   string
+		wantErr error
+	}{
+		{"valid", sign(t, key, "key-1", idp.claims()), testNonce, nil},
+		{"audience array", sign(t, key, "key-1", with("aud", []string{"other", testClientID})), testNonce, nil},
+		{"bad signature", sign(t, otherKey, "key-1", idp.claims()), testNonce, ErrInvalidToken},
+		{"unknown key", sign(t, key, "key-2", idp.claims()), testNonce, ErrInvalidToken},
+		{"wrong issuer", sign(t, key, "key-1", with("iss", "https://evil.example.com")), testNonce, ErrInvalidToken},
+		{"wrong audience", sign(t, key, "key-1", with("aud", "someone-else")), testNonce, ErrInvalidToken},
+		{"wrong nonce", sign(t, key, "key-1", idp.claims()), "nonce-456", ErrInvalidToken},
+		{"missing subject", sign(t, key, "key-1", with("sub", "")), testNonce, ErrInvalidToken},
+		{"expired", sign(t, key, "key-1", with("exp", time.Now().Add(-2*leeway).Unix())), testNonce, ErrInvalidToken},
+		{"expired within leeway", sign(t, key, "key-1", with("exp", time.Now().Add(-leeway/2).Unix())), testNonce, nil},
+		{"issued in the future", sign(t, key, "key-1", with("iat", time.Now().Add(2*leeway).Unix())), testNonce, ErrInvalidToken},
+		{"malformed", "not-a-token", testNonce, ErrInvalidToken},
+		{"unsigned", unsigned(idp.claims()), testNonce, ErrUnsupportedAlg},
+	}
+	p := New(idp.URL, testClientID, "", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.Verify(context.Background(), tt.token, tt.nonce)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				if claims.Subject != "user-1" {
+					t.Errorf("got subject %q; want %q", claims.Subject, "user-1")
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// unsigned returns a token with the "none" algorithm, which must never be accepted.
+func unsigned(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	idp := newTestIdP(t)
+	oldKey := idp.rotate("key-1")
+	p := New(idp.URL, testClientID, "", "")
+
+	_, err := p.Verify(context.Background(), sign(t, oldKey, "key-1", idp.claims()), testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider starts signing with a new key. Until the refresh delay has passed,
+	// an unknown key ID doesn't make us fetch the key set again, so that a client
+	// can't make us hammer the provider with made up key IDs.
+	newKey := idp.rotate("key-2")
+	newToken := sign(t, newKey, "key-2", idp.claims())
+	_, err = p.Verify(context.Background(), newToken, testNonce)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got error %v; want %v", err, ErrInvalidToken)
+	}
+	if idp.jwksCalls != 1 {
+		t.Errorf("got %d key set fetches; want 1", idp.jwksCalls)
+	}
+
+	// Once it has passed, the new key is fetched and the old one is forgotten.
+	p.keysFetched = time.Now().Add(-keyRefreshDelay)
+	_, err = p.Verify(context.Background(), newToken, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idp.jwksCalls != 2 {
+		t.Errorf("got %d key set fetches; want 2", idp.jwksCalls)
+	}
+	_, err = p.Verify(context.Background(), sign(t, oldKey, "key-1", idp.claims()), testNonce)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("got error %v for a token signed with a retired key; want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.rotate("key-1")
+	// The issuer in the discovery document has to match the configured one exactly
+	// (apart from a trailing slash).
+	p := New(strings.Replace(idp.URL, "127.0.0.1", "localhost", 1), testClientID, "", "")
+	_, err := p.AuthCodeURL(context.Background(), "state-1", testNonce, "verifier-1")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("got error %v; want an issuer mismatch", err)
+	}
+}
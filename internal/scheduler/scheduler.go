@@ -0,0 +1,241 @@
+// Package scheduler runs background jobs at regular intervals, recording each run in
+// the job_runs table. When several instances of the API share a database, only one of
+// them runs the jobs: the leader, which is whichever instance holds a PostgreSQL
+// advisory lock. If the leader stops or loses its database connection, the lock is
+// released along with its session, and another instance takes over on its next tick.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"greenlight.m4rk1sov.github.com/internal/jsonlog"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Job is a task which is run every Interval.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Run carries out the job, returning a summary of what it did (like the number of
+	// records deleted) for the job history.
+	Run func() (map[string]any, error)
+}
+
+// A Scheduler runs the jobs while it's the leader.
+type Scheduler struct {
+	DB     *sql.DB
+	Runs   data.JobRunModel
+	Logger *jsonlog.Logger
+	Jobs   []Job
+	// The key for the advisory lock, which must be the same for every instance.
+	LockKey int64
+	// How often to check for jobs which are due, and, if another instance is the
+	// leader, to try to take over.
+	Tick time.Duration
+	// The name of this instance in the job history.
+	Instance string
+
+	// The connection holding the advisory lock, which is only used by the Run()
+	// goroutine. The fields below it are also read by the Leader() and NextRuns()
+	// methods, so they're protected by the mutex.
+	conn   *sql.Conn
+	mu     sync.Mutex
+	leader bool
+	next   map[string]time.Time
+}
+
+// New returns a Scheduler for the jobs, which checks for jobs that are due every 30
+// seconds and identifies itself by the host name and process ID.
+func New(db *sql.DB, logger *jsonlog.Logger, lockKey int64, jobs ...Job) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		DB:       db,
+		Runs:     data.JobRunModel{DB: db},
+		Logger:   logger,
+		Jobs:     jobs,
+		LockKey:  lockKey,
+		Tick:     30 * time.Second,
+		Instance: fmt.Sprintf("%s:%d", host, os.Getpid()),
+	}
+}
+
+// Run runs the jobs which are due on every tick until the context is cancelled, and
+// then gives up the leadership so that another instance can take over straight away.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Tick)
+	defer ticker.Stop()
+	defer s.resign()
+	for {
+		if s.lead() {
+			for _, job := range s.Jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				if !time.Now().Before(s.nextRun(job.Name)) {
+					s.run(job)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Leader reports whether this instance is currently running the jobs.
+func (s *Scheduler) Leader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// NextRuns returns when each job will next run, if this instance is the leader.
+func (s *Scheduler) NextRuns() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := make(map[string]time.Time, len(s.next))
+	for name, t := range s.next {
+		next[name] = t
+	}
+	return next
+}
+
+func (s *Scheduler) nextRun(name string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next[name]
+}
+
+// The lead() method reports whether this instance is the leader, first trying to
+// become the leader if it isn't, or checking that the session holding the lock is
+// still alive if it is.
+func (s *Scheduler) lead() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if s.conn != nil {
+		err := s.conn.PingContext(ctx)
+		if err == nil {
+			return true
+		}
+		s.Logger.PrintError(fmt.Errorf("scheduler lost its database session: %w", err), map[string]string{
+			"instance": s.Instance,
+		})
+		s.drop()
+		return false
+	}
+
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		s.Logger.PrintError(err, nil)
+		return false
+	}
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, s.LockKey).Scan(&acquired)
+	if err != nil || !acquired {
+		if err != nil {
+			s.Logger.PrintError(err, nil)
+		}
+		conn.Close()
+		return false
+	}
+	s.conn = conn
+
+	// Carry on from the previous leader's schedule, so that a change of leader doesn't
+	// make every job run again at once. Jobs which have never run are due straight away.
+	latest, err := s.Runs.GetLatest()
+	if err != nil {
+		s.Logger.PrintError(err, nil)
+	}
+	next := make(map[string]time.Time, len(s.Jobs))
+	for _, job := range s.Jobs {
+		if run, ok := latest[job.Name]; ok {
+			next[job.Name] = run.StartedAt.Add(job.Interval)
+		}
+	}
+	s.mu.Lock()
+	s.leader = true
+	s.next = next
+	s.mu.Unlock()
+	s.Logger.PrintInfo("scheduler became leader", map[string]string{"instance": s.Instance})
+	return true
+}
+
+// The drop() method forgets the connection holding the lock without returning it to
+// the pool. Returning driver.ErrBadConn from Raw() makes database/sql close the
+// connection, which ends the session and so releases the lock, even if the connection
+// was actually fine and it was only the ping which failed.
+func (s *Scheduler) drop() {
+	s.conn.Raw(func(any) error { return driver.ErrBadConn })
+	s.conn.Close()
+	s.conn = nil
+	s.mu.Lock()
+	s.leader = false
+	s.next = nil
+	s.mu.Unlock()
+}
+
+// The resign() method releases the lock, if this instance holds it.
+func (s *Scheduler) resign() {
+	if s.conn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := s.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, s.LockKey)
+	if err != nil {
+		s.drop()
+		return
+	}
+	s.conn.Close()
+	s.conn = nil
+	s.mu.Lock()
+	s.leader = false
+	s.next = nil
+	s.mu.Unlock()
+}
+
+// The run() method runs a job and records the run in the job history.
+func (s *Scheduler) run(job Job) {
+	run := &data.JobRun{Job: job.Name, Instance: s.Instance, StartedAt: time.Now()}
+	result, err := runJob(job)
+	run.FinishedAt = time.Now()
+	run.Result = result
+	if err != nil {
+		run.Status = data.JobFailed
+		run.Error = err.Error()
+		s.Logger.PrintError(err, map[string]string{"job": job.Name})
+	} else {
+		run.Status = data.JobSucceeded
+		s.Logger.PrintInfo("job completed", map[string]string{
+			"job":      job.Name,
+			"duration": run.FinishedAt.Sub(run.StartedAt).String(),
+		})
+	}
+
+	s.mu.Lock()
+	s.next[job.Name] = run.StartedAt.Add(job.Interval)
+	s.mu.Unlock()
+
+	err = s.Runs.Insert(run)
+	if err != nil {
+		s.Logger.PrintError(err, map[string]string{"job": job.Name})
+	}
+}
+
+// The runJob() function runs a job, turning a panic into an error so that one broken
+// job doesn't stop the others.
+func runJob(job Job) (result map[string]any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run()
+}
@@ -0,0 +1,298 @@
+// Package settings reads settings from several sources into the flags of a flag.FlagSet,
+// so that each setting is declared once, as a flag, with its type and default value.
+// In increasing order of precedence, the sources are:
+//
+//  1. the default values of the flags,
+//  2. a YAML (.yaml or .yml) or TOML (.toml) file,
+//  3. environment variables, named after the flags with a prefix, in upper case and with
+//     underscores instead of hyphens (so -db-max-idle-time is GREENLIGHT_DB_MAX_IDLE_TIME
+//     with the prefix GREENLIGHT_), and
+//  4. the command-line arguments.
+//
+// In the file, a setting can be written either under its flag name, or nested by the
+// parts of its name, with hyphens or underscores. These are all the same setting:
+//
+//	db-max-idle-time: 15m
+//	db_max_idle_time: 15m
+//	db:
+//	  max-idle-time: 15m
+package settings
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The sources of a setting's value, as reported by Source.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// ErrCommandLine wraps the error from parsing the command-line arguments, which the
+// FlagSet has already reported along with its usage.
+var ErrCommandLine = errors.New("invalid command-line arguments")
+
+// A Loader loads the flags of a FlagSet from the sources.
+type Loader struct {
+	FlagSet *flag.FlagSet
+	// The prefix for the environment variables, like GREENLIGHT_.
+	EnvPrefix string
+	// The name of the flag which holds the path of the file. If it's empty, or the flag
+	// isn't set by any of the other sources, no file is read.
+	FileFlag string
+	// Whether to ignore settings in the file which aren't flags of the FlagSet, so that
+	// a program can share a file with another which has more settings.
+	IgnoreUnknown bool
+	// The flags which hold secrets, whose values are redacted by Print.
+	Secrets []string
+	// The flags which aren't settings, like -print-config, which can only be set on the
+	// command line and aren't printed.
+	CommandLineOnly []string
+	// LookupEnv looks up an environment variable. It defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+
+	sources map[string]string
+}
+
+// Load parses the command-line arguments, and then sets the flags which weren't given
+// on the command line from the environment variables or the file. Rather than stopping
+// at the first invalid value, it returns all of the problems, joined into one error.
+func (l *Loader) Load(args []string) error {
+	err := l.FlagSet.Parse(args)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCommandLine, err)
+	}
+	lookupEnv := l.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+
+	l.sources = make(map[string]string)
+	l.FlagSet.Visit(func(f *flag.Flag) {
+		l.sources[f.Name] = SourceFlag
+	})
+	var errs []error
+	set := func(name, value, source string) {
+		if _, ok := l.sources[name]; ok && l.sources[name] != SourceFile {
+			return
+		}
+		err := l.FlagSet.Set(name, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s from %s: %w", value, name, describe(source), err))
+			return
+		}
+		l.sources[name] = source
+	}
+
+	// Work out the file first, as it has the lowest precedence but its path could come
+	// from an environment variable.
+	if l.FileFlag != "" {
+		if value, ok := lookupEnv(l.envName(l.FileFlag)); ok {
+			set(l.FileFlag, value, SourceEnv)
+		}
+		if path := l.FlagSet.Lookup(l.FileFlag).Value.String(); path != "" {
+			values, err := readFile(path)
+			if err != nil {
+				return err
+			}
+			keys := make([]string, 0, len(values))
+			for key := range values {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if l.FlagSet.Lookup(key) == nil && l.IgnoreUnknown {
+					continue
+				}
+				if l.FlagSet.Lookup(key) == nil || l.commandLineOnly(key) || key == l.FileFlag {
+					errs = append(errs, fmt.Errorf("unknown setting %q in %s", key, path))
+					continue
+				}
+				set(key, values[key], SourceFile)
+			}
+		}
+	}
+
+	l.FlagSet.VisitAll(func(f *flag.Flag) {
+		if l.commandLineOnly(f.Name) || f.Name == l.FileFlag {
+			return
+		}
+		if value, ok := lookupEnv(l.envName(f.Name)); ok {
+			set(f.Name, value, SourceEnv)
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Source returns where the value of a flag came from.
+func (l *Loader) Source(name string) string {
+	if source, ok := l.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// Print writes the settings as YAML, in a form which can be used as the file, with a
+// comment after each one saying where its value came from. Secrets are redacted.
+func (l *Loader) Print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	l.FlagSet.VisitAll(func(f *flag.Flag) {
+		if l.commandLineOnly(f.Name) || f.Name == l.FileFlag {
+			return
+		}
+		value := f.Value.String()
+		if l.secret(f.Name) {
+			value = Redact(value)
+		}
+		source := l.Source(f.Name)
+		if source == SourceEnv {
+			source = "env " + l.envName(f.Name)
+		}
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: f.Name},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value, LineComment: source},
+		)
+	})
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err := enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Redacted returns the value of a flag as a string, redacted if it's a secret, for
+// writing to a log.
+func (l *Loader) Redacted(name string) string {
+	value := l.FlagSet.Lookup(name).Value.String()
+	if l.secret(name) {
+		return Redact(value)
+	}
+	return value
+}
+
+func (l *Loader) envName(name string) string {
+	return l.EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func (l *Loader) secret(name string) bool {
+	for _, secret := range l.Secrets {
+		if secret == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Loader) commandLineOnly(name string) bool {
+	for _, only := range l.CommandLineOnly {
+		if only == name {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(source string) string {
+	switch source {
+	case SourceEnv:
+		return "the environment"
+	case SourceFile:
+		return "the config file"
+	}
+	return source
+}
+
+var passwordRX = regexp.MustCompile(`(password=)(?:'(?:[^'\\]|\\.)*'|[^\s&]+)`)
+
+// Redact hides a secret value. For a URL, like a PostgreSQL DSN, only the password is
+// hidden, and likewise for the password in a key=value DSN. Empty values are left
+// empty, so that it's clear that they haven't been set.
+func Redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		return passwordRX.ReplaceAllString(u.Redacted(), "${1}xxxxx")
+	}
+	if passwordRX.MatchString(value) {
+		return passwordRX.ReplaceAllString(value, "${1}xxxxx")
+	}
+	return "xxxxx"
+}
+
+// The readFile() function reads the settings from a YAML or TOML file, depending on its
+// extension, and flattens them into a map of flag names to values.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	default:
+		return nil, fmt.Errorf("config: %s must be a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: reading %s: %w", path, err)
+	}
+	values := make(map[string]string)
+	err = flatten(values, "", doc)
+	if err != nil {
+		return nil, fmt.Errorf("config: reading %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// The flatten() function adds the values in a decoded file to the map, joining the
+// keys of nested tables with hyphens. Lists are joined with spaces, like the values of
+// flags which take more than one value.
+func flatten(values map[string]string, prefix string, doc map[string]any) error {
+	for key, value := range doc {
+		name := strings.ReplaceAll(key, "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+		if table, ok := value.(map[string]any); ok {
+			err := flatten(values, name, table)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, exists := values[name]; exists {
+			return fmt.Errorf("%s is set more than once", name)
+		}
+		switch value := value.(type) {
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, " ")
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
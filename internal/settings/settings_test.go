@@ -0,0 +1,55 @@
+package settings
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadIgnoreUnknown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte("db:\n  dsn: postgres://file\nport: 4000\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newLoader := func(ignoreUnknown bool, env map[string]string) (*Loader, *string) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.String("config", "", "")
+		dsn := fs.String("db-dsn", "", "")
+		return &Loader{
+			FlagSet:       fs,
+			EnvPrefix:     "TEST_",
+			FileFlag:      "config",
+			IgnoreUnknown: ignoreUnknown,
+			LookupEnv: func(key string) (string, bool) {
+				value, ok := env[key]
+				return value, ok
+			},
+		}, dsn
+	}
+
+	loader, _ := newLoader(false, nil)
+	err = loader.Load([]string{"-config", path})
+	if err == nil {
+		t.Error("got no error for the unknown port setting")
+	}
+
+	loader, dsn := newLoader(true, map[string]string{"TEST_CONFIG": path})
+	err = loader.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dsn != "postgres://file" || loader.Source("db-dsn") != SourceFile {
+		t.Errorf("got db-dsn %q from %s; want it from the file", *dsn, loader.Source("db-dsn"))
+	}
+
+	loader, _ = newLoader(true, nil)
+	err = loader.Load([]string{"-unknown"})
+	if !errors.Is(err, ErrCommandLine) {
+		t.Errorf("got error %v; want %v", err, ErrCommandLine)
+	}
+}
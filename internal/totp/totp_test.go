@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The secret used by the test vectors in RFC 4226 and RFC 6238 (for SHA-1).
+var rfcSecret = []byte("12345678901234567890")
+
+// TestCodeRFC4226 uses the HOTP test vectors from RFC 4226 appendix D, as a TOTP code
+// is a HOTP code with the time step as the counter.
+func TestCodeRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := Code(rfcSecret, int64(counter)); got != code {
+			t.Errorf("counter %d: got code %s; want %s", counter, got, code)
+		}
+	}
+}
+
+// TestCodeRFC6238 uses the SHA-1 test vectors from RFC 6238 appendix B. They're 8
+// digits long, and we use 6, which are the last 6 digits of the same value.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		step int64
+		code string
+	}{
+		{59, 0x1, "94287082"},
+		{1111111109, 0x23523EC, "07081804"},
+		{1111111111, 0x23523ED, "14050471"},
+		{1234567890, 0x273EF07, "89005924"},
+		{2000000000, 0x3F940AA, "69279037"},
+		{20000000000, 0x27BC86AA, "65353130"},
+	}
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		if got := Step(at); got != tt.step {
+			t.Errorf("%d: got step %#x; want %#x", tt.unix, got, tt.step)
+		}
+		want := tt.code[len(tt.code)-Digits:]
+		if got := Code(rfcSecret, tt.step); got != want {
+			t.Errorf("%d: got code %s; want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	tests := []struct {
+		name     string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", Code(rfcSecret, current), 1, current, true},
+		{"previous step", Code(rfcSecret, current-1), 1, current - 1, true},
+		{"next step", Code(rfcSecret, current+1), 1, current + 1, true},
+		{"outside the skew", Code(rfcSecret, current-2), 1, 0, false},
+		{"no skew", Code(rfcSecret, current-1), 0, 0, false},
+		{"wrong length", Code(rfcSecret, current) + "0", 1, 0, false},
+		{"empty", "", 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if step != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("got step %d and %t; want %d and %t", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Greenlight", "alice@example.com", rfcSecret)
+	want := "otpauth://totp/Greenlight:alice@example.com?algorithm=SHA1&digits=6&issuer=Greenlight&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if uri != want {
+		t.Errorf("got %s; want %s", uri, want)
+	}
+	if strings.Contains(EncodeSecret(rfcSecret), "=") {
+		t.Error("the encoded secret is padded")
+	}
+}
@@ -0,0 +1,200 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// The bundled list of breached password hashes. Each line holds the uppercase hex SHA-1
+// hash of a password which is known to appear in public breaches, split into a 5
+// character prefix and the remaining 35 character suffix, in the form PREFIX:SUFFIX.
+// This is the same layout as the Pwned Passwords range API, so a larger list
+// downloaded from there can be used instead.
+//
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+// The BreachedPasswords type holds a set of breached password hashes, grouped by the
+// prefix of the hash. Lookups only ever compare suffixes within a single prefix, in the
+// same k-anonymity style as the Pwned Passwords API, so the list could be swapped for a
+// remote range lookup without changing callers.
+type BreachedPasswords struct {
+	ranges map[string]map[string]bool
+}
+
+// BundledBreachedPasswords returns the breached password list which is embedded in
+// the binary.
+func BundledBreachedPasswords() (*BreachedPasswords, error) {
+	return LoadBreachedPasswords(strings.NewReader(bundledBreachedPasswords))
+}
+
+// LoadBreachedPasswordsFile reads a breached password list from a file.
+func LoadBreachedPasswordsFile(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadBreachedPasswords(f)
+}
+
+// LoadBreachedPasswords reads a breached password list in the PREFIX:SUFFIX format.
+// Blank lines and lines starting with # are ignored, and anything after a second colon
+// (such as the breach count in Pwned Passwords downloads) is discarded.
+func LoadBreachedPasswords(r io.Reader) (*BreachedPasswords, error) {
+	b := &BreachedPasswords{ranges: make(map[string]map[string]bool)}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Split(strings.ToUpper(text), ":")
+		if len(parts) < 2 || len(parts[0]) != 5 || len(parts[1]) != 35 {
+			return nil, fmt.Errorf("breached passwords: invalid entry on line %d", line)
+		}
+		if b.ranges[parts[0]] == nil {
+			b.ranges[parts[0]] = make(map[string]bool)
+		}
+		b.ranges[parts[0]][parts[1]] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Contains returns true if the password appears in the breached password list.
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return b.ranges[hash[:5]][hash[5:]]
+}
+
+// The PasswordPolicy type holds the rules that new passwords must follow.
+type PasswordPolicy struct {
+	// MinScore is the lowest acceptable PasswordStrength() score, from 0 to 4.
+	MinScore int
+	// Breached is the list of breached passwords to reject. If it is nil, then
+	// passwords aren't checked against a breached password list.
+	Breached *BreachedPasswords
+}
+
+// Check validates a new password against the policy, recording any problems in the
+// Validator under the given key. The userInputs are values such as the user's name and
+// email address, which the password must not contain. It doesn't check the length of
+// the password, which is left to the caller.
+func (p *PasswordPolicy) Check(v *Validator, key, password string, userInputs ...string) {
+	if password == "" {
+		return
+	}
+	v.CheckField(!containsUserInput(password, userInputs), key, Error{
+		Code:    "contains_user_info",
+		Message: "must not contain your name or email address",
+	})
+	v.CheckField(PasswordStrength(password) >= p.MinScore, key, Error{
+		Code:    "too_weak",
+		Message: "is too easy to guess",
+		Params:  map[string]any{"min_score": p.MinScore},
+	})
+	if p.Breached != nil {
+		v.CheckField(!p.Breached.Contains(password), key, Error{
+			Code:    "breached",
+			Message: "has appeared in a data breach, please choose another",
+		})
+	}
+}
+
+// The containsUserInput() helper reports whether the password contains any of the user
+// inputs, or the local part of any email addresses among them, ignoring case. Very
+// short values are skipped, so that a user called Al can still use an "al" somewhere.
+func containsUserInput(password string, userInputs []string) bool {
+	password = strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		candidates := []string{input}
+		if local, _, found := strings.Cut(input, "@"); found {
+			candidates = append(candidates, local)
+		}
+		candidates = append(candidates, strings.Fields(input)...)
+		for _, candidate := range candidates {
+			if len(candidate) >= 3 && strings.Contains(password, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PasswordStrength returns a rough score for how hard a password is to guess, from 0
+// (trivial) to 4 (strong). The score is based on an estimate of the password's entropy
+// in bits: the size of the character pool it draws from, and its length once runs of
+// repeated characters (aaaa) and sequences (1234, abcd) are discounted.
+func PasswordStrength(password string) int {
+	var lower, upper, digit, symbol bool
+	var length float64
+	var prev rune
+	run := 0
+	for i, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+		// Characters which continue a run of repeats or a sequence add little, so we
+		// stop counting a run after its first two characters.
+		if i > 0 && (r == prev || r == prev+1 || r == prev-1) {
+			run++
+		} else {
+			run = 0
+		}
+		if run < 2 {
+			length++
+		}
+		prev = r
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0
+	}
+	bits := length * math.Log2(float64(pool))
+	switch {
+	case bits < 25:
+		return 0
+	case bits < 40:
+		return 1
+	case bits < 55:
+		return 2
+	case bits < 70:
+		return 3
+	default:
+		return 4
+	}
+}
@@ -0,0 +1,167 @@
+package validator
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", 0},
+		{"aaaaaaaa", 0},
+		{"12345678", 0},
+		{"abcdefghijkl", 0},
+		{"password", 1},
+		{"qwerty", 1},
+		{"pa55word", 2},
+		{"kP4nW9xR", 2},
+		{"Moana2016!", 3},
+		{"Tr0ub4dor&3", 4},
+		{"correct horse battery staple", 4},
+		{"x7#Kp9!qLm2$Vz", 4},
+	}
+	for _, tt := range tests {
+		got := PasswordStrength(tt.password)
+		if got != tt.want {
+			t.Errorf("PasswordStrength(%q) = %d; want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestContainsUserInput(t *testing.T) {
+	inputs := []string{"Alice Smith", "alice.smith@example.com"}
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"x7#Kp9!qLm2$Vz", false},
+		{"ALICESMITH2024", true},
+		{"alice smith!", true},
+		{"iamALICE99", true},
+		{"smith&wesson", true},
+		{"alice.smith@example.com", true},
+		{"my alice.smith rules", true},
+		{"example.com!", false},
+	}
+	for _, tt := range tests {
+		got := containsUserInput(tt.password, inputs)
+		if got != tt.want {
+			t.Errorf("containsUserInput(%q) = %t; want %t", tt.password, got, tt.want)
+		}
+	}
+
+	// Values shorter than three characters are ignored.
+	if containsUserInput("always-alright", []string{"Al", "al@x.io"}) {
+		t.Error("a two letter name matched the password")
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	breached, err := BundledBreachedPasswords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &PasswordPolicy{MinScore: 2, Breached: breached}
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"strong", "x7#Kp9!qLm2$Vz", nil},
+		{"empty", "", nil},
+		{"breached", "password123", []string{"breached"}},
+		{"weak and breached", "iloveyou", []string{"too_weak", "breached"}},
+		{"weak", "abcdefghijkl", []string{"too_weak"}},
+		{"contains name", "Alice#Kp9!qLm2$Vz", []string{"contains_user_info"}},
+		{"contains email", "x7#alice.smith@example.com", []string{"contains_user_info"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			policy.Check(v, "password", tt.password, "Alice Smith", "alice.smith@example.com")
+			var got []string
+			for _, e := range v.Errors["password"] {
+				got = append(got, e.Code)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got error codes %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Without a breached password list, only the strength and user input checks run.
+	v := New()
+	(&PasswordPolicy{MinScore: 2}).Check(v, "password", "password123")
+	if !v.Valid() {
+		t.Errorf("got errors %v without a breached password list", v.Errors)
+	}
+}
+
+// The rangeEntry() helper returns the PREFIX:SUFFIX line for a password.
+func rangeEntry(password string) string {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash[:5] + ":" + hash[5:]
+}
+
+func TestBreachedPasswordsFile(t *testing.T) {
+	// A second password with the same hash prefix as a listed one must not match, so
+	// we list a suffix from that prefix which belongs to no real password.
+	entry := rangeEntry("Moana2016!")
+	decoy := entry[:6] + strings.Repeat("0", 35)
+	list := strings.Join([]string{
+		"# custom list",
+		"",
+		strings.ToLower(entry) + ":42",
+		decoy,
+		rangeEntry("Alice#Kp9!qLm2$Vz"),
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte(list), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breached, err := LoadBreachedPasswordsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"Moana2016!", true},
+		{"Alice#Kp9!qLm2$Vz", true},
+		{"moana2016!", false},
+		{"password123", false},
+	}
+	for _, tt := range tests {
+		got := breached.Contains(tt.password)
+		if got != tt.want {
+			t.Errorf("Contains(%q) = %t; want %t", tt.password, got, tt.want)
+		}
+	}
+	if len(breached.ranges) != 2 || len(breached.ranges[entry[:5]]) != 2 {
+		t.Errorf("got ranges %v; want two prefixes, one with two suffixes", breached.ranges)
+	}
+}
+
+func TestLoadBreachedPasswordsInvalid(t *testing.T) {
+	for _, list := range []string{
+		"password123",
+		"ABCDE:1234",
+		"ABCD:" + strings.Repeat("0", 36),
+	} {
+		_, err := LoadBreachedPasswords(strings.NewReader(list))
+		if err == nil {
+			t.Errorf("loading %q: got no error", list)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A Rule checks a single field value against a validation rule, given the rule's
+// parameter from the struct tag (the part after the = sign, or the empty string if
+// there isn't one). It returns whether the value is ok and, if not, the error to
+// report.
+type Rule func(value reflect.Value, param string) (bool, Error)
+
+// rules holds the rules which can be used in validate struct tags, keyed by name.
+var rules = map[string]Rule{
+	"required": requiredRule,
+	"min":      minRule,
+	"max":      maxRule,
+	"len":      lenRule,
+	"unique":   uniqueRule,
+	"oneof":    oneOfRule,
+	"email":    emailRule,
+}
+
+// RegisterRule adds a custom rule which can be used in validate struct tags, replacing
+// any existing rule with the same name. It should only be called during initialization.
+func RegisterRule(name string, rule Rule) {
+	rules[name] = rule
+}
+
+// Struct validates the fields of a struct (or a pointer to a struct) according to
+// their validate tags, recording any problems in the Validator. The tag holds a comma
+// separated list of rules, which are checked in order:
+//
+//	Title  string   `json:"title" validate:"required,max=500"`
+//	Genres []string `json:"genres" validate:"required,min=1,unique,dive,required"`
+//
+// Errors are recorded against the field's JSON name. The special dive rule applies the
+// rules that follow it to each element of a slice (recorded against paths like
+// genres[2]), or validates a nested struct (with paths like address.city).
+//
+// The default messages for the rules can be overridden with a messages tag, which
+// holds a semicolon separated list of rule=message pairs:
+//
+//	Genres []string `validate:"min=1" messages:"min=must contain at least 1 genre"`
+//
+// Struct panics if it is passed anything other than a struct, or if a tag refers to an
+// unknown rule, as both are programming errors.
+func (v *Validator) Struct(s any) {
+	value := reflect.Indirect(reflect.ValueOf(s))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: Struct called with %T", s))
+	}
+	v.validateStruct("", value)
+}
+
+func (v *Validator) validateStruct(prefix string, value reflect.Value) {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		key := jsonName(field)
+		if prefix != "" {
+			key = Field(prefix, key)
+		}
+		messages := parseMessages(field.Tag.Get("messages"))
+		v.validateValue(key, value.Field(i), strings.Split(tag, ","), messages)
+	}
+}
+
+func (v *Validator) validateValue(key string, value reflect.Value, tagRules []string, messages map[string]string) {
+	for i, tagRule := range tagRules {
+		name, param, _ := strings.Cut(strings.TrimSpace(tagRule), "=")
+		if name == "" {
+			continue
+		}
+		if name == "dive" {
+			v.dive(key, value, tagRules[i+1:], messages)
+			return
+		}
+		rule, ok := rules[name]
+		if !ok {
+			panic(fmt.Sprintf("validator: unknown rule %q", name))
+		}
+		if ok, err := rule(value, param); !ok {
+			if message, exists := messages[name]; exists {
+				err.Message = message
+			}
+			v.AddFieldError(key, err)
+		}
+	}
+}
+
+// The dive() method validates each element of a slice, or the fields of a nested
+// struct.
+func (v *Validator) dive(key string, value reflect.Value, tagRules []string, messages map[string]string) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Struct:
+		v.validateStruct(key, value)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			element := reflect.Indirect(value.Index(i))
+			if element.Kind() == reflect.Struct {
+				v.validateStruct(Index(key, i), element)
+				continue
+			}
+			v.validateValue(Index(key, i), element, tagRules, messages)
+		}
+	}
+}
+
+// The jsonName() helper returns the name of a field in JSON, so that errors are
+// reported against the names that clients use.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func parseMessages(tag string) map[string]string {
+	if tag == "" {
+		return nil
+	}
+	messages := make(map[string]string)
+	for _, pair := range strings.Split(tag, ";") {
+		name, message, found := strings.Cut(pair, "=")
+		if found {
+			messages[strings.TrimSpace(name)] = strings.TrimSpace(message)
+		}
+	}
+	return messages
+}
+
+// The mustAtoi() helper converts a rule parameter to an integer, panicking if it isn't
+// one because the struct tag is wrong.
+func mustAtoi(param string) int {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		panic(fmt.Sprintf("validator: invalid rule parameter %q", param))
+	}
+	return n
+}
+
+// The numeric() helper returns a value of any integer kind as an int64.
+func numeric(value reflect.Value) (int64, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint()), true
+	}
+	return 0, false
+}
+
+// The built-in rules follow. The length-based rules (min, max and len) apply to the
+// length in bytes of strings, the number of items in slices and the value of integers.
+
+func requiredRule(value reflect.Value, _ string) (bool, Error) {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return !value.IsNil(), Required()
+	}
+	return !value.IsZero(), Required()
+}
+
+func minRule(value reflect.Value, param string) (bool, Error) {
+	n := mustAtoi(param)
+	switch value.Kind() {
+	case reflect.String:
+		return value.Len() >= n, MinLength(n)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return value.Len() >= n, MinItems(n)
+	}
+	if i, ok := numeric(value); ok {
+		return i >= int64(n), Min(int64(n))
+	}
+	panic(fmt.Sprintf("validator: min rule used on %s", value.Kind()))
+}
+
+func maxRule(value reflect.Value, param string) (bool, Error) {
+	n := mustAtoi(param)
+	switch value.Kind() {
+	case reflect.String:
+		return value.Len() <= n, MaxLength(n)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return value.Len() <= n, MaxItems(n)
+	}
+	if i, ok := numeric(value); ok {
+		return i <= int64(n), Max(int64(n))
+	}
+	panic(fmt.Sprintf("validator: max rule used on %s", value.Kind()))
+}
+
+func lenRule(value reflect.Value, param string) (bool, Error) {
+	n := mustAtoi(param)
+	return value.Len() == n, Length(n)
+}
+
+func uniqueRule(value reflect.Value, _ string) (bool, Error) {
+	switch values := value.Interface().(type) {
+	case []string:
+		return Unique(values), UniqueItems()
+	case []int64:
+		return Unique(values), UniqueItems()
+	case []int:
+		return Unique(values), UniqueItems()
+	}
+	// Fall back to comparing the elements as interface values, which works for any
+	// comparable element type (including named types like Permissions).
+	elements := make([]any, value.Len())
+	for i := range elements {
+		elements[i] = value.Index(i).Interface()
+	}
+	return Unique(elements), UniqueItems()
+}
+
+func oneOfRule(value reflect.Value, param string) (bool, Error) {
+	permittedValues := strings.Fields(param)
+	return PermittedValue(fmt.Sprint(value.Interface()), permittedValues...), OneOf(permittedValues...)
+}
+
+func emailRule(value reflect.Value, _ string) (bool, Error) {
+	return Matches(value.String(), EmailRX), Format("email").WithMessage("must be a valid email address")
+}
@@ -0,0 +1,182 @@
+package validator
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRules pins the code, message and parameters of each built-in rule's error.
+func TestRules(t *testing.T) {
+	type tagged struct {
+		Name   string         `json:"name" validate:"required,min=2,max=5"`
+		Code   string         `json:"code" validate:"len=3"`
+		Count  int            `json:"count" validate:"min=1,max=10"`
+		Tags   []string       `json:"tags" validate:"required,min=1,max=2,unique"`
+		IDs    []int64        `json:"ids" validate:"unique"`
+		Kind   string         `json:"kind" validate:"oneof=movie series"`
+		Email  string         `json:"email" validate:"email"`
+		Extra  map[string]any `json:"extra" validate:"required"`
+		Hidden string         `json:"-" validate:"required"`
+	}
+	valid := tagged{Name: "abc", Code: "abc", Count: 5, Tags: []string{"a"}, IDs: []int64{1, 2}, Kind: "movie", Email: "alice@example.com", Extra: map[string]any{}, Hidden: "x"}
+	tests := []struct {
+		name   string
+		change func(*tagged)
+		key    string
+		want   string
+	}{
+		{"valid", func(*tagged) {}, "", ""},
+		{"required string", func(s *tagged) { s.Name = "" }, "name", `{"code":"required","message":"must be provided"}`},
+		{"min length", func(s *tagged) { s.Name = "a" }, "name", `{"code":"min_length","message":"must be at least 2 bytes long","min":2}`},
+		{"max length", func(s *tagged) { s.Name = "abcdef" }, "name", `{"code":"max_length","max":5,"message":"must not be more than 5 bytes long"}`},
+		{"length", func(s *tagged) { s.Code = "ab" }, "code", `{"code":"length","length":3,"message":"must be 3 bytes long"}`},
+		{"min", func(s *tagged) { s.Count = 0 }, "count", `{"code":"min","message":"must be at least 1","min":1}`},
+		{"max", func(s *tagged) { s.Count = 11 }, "count", `{"code":"max","max":10,"message":"must be a maximum of 10"}`},
+		{"required slice", func(s *tagged) { s.Tags = nil }, "tags", `{"code":"required","message":"must be provided"}`},
+		{"min items", func(s *tagged) { s.Tags = []string{} }, "tags", `{"code":"min_items","message":"must contain at least 1 items","min":1}`},
+		{"max items", func(s *tagged) { s.Tags = []string{"a", "b", "c"} }, "tags", `{"code":"max_items","max":2,"message":"must not contain more than 2 items"}`},
+		{"unique strings", func(s *tagged) { s.Tags = []string{"a", "a"} }, "tags", `{"code":"unique","message":"must not contain duplicate values"}`},
+		{"unique ints", func(s *tagged) { s.IDs = []int64{1, 1} }, "ids", `{"code":"unique","message":"must not contain duplicate values"}`},
+		{"one of", func(s *tagged) { s.Kind = "book" }, "kind", `{"allowed":["movie","series"],"code":"one_of","message":"must be one of the permitted values"}`},
+		{"email", func(s *tagged) { s.Email = "alice" }, "email", `{"code":"format","format":"email","message":"must be a valid email address"}`},
+		{"required map", func(s *tagged) { s.Extra = nil }, "extra", `{"code":"required","message":"must be provided"}`},
+		{"field without a JSON name", func(s *tagged) { s.Hidden = "" }, "Hidden", `{"code":"required","message":"must be provided"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.change(&s)
+			v := New()
+			v.Struct(&s)
+			got, err := json.Marshal(v.Errors)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "{}"
+			if tt.key != "" {
+				want = `{"` + tt.key + `":[` + tt.want + `]}`
+			}
+			if string(got) != want {
+				t.Errorf("got %s; want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestStruct checks how Struct() combines rules: required stops any further errors for
+// the field, dive applies rules to each element or nested field, and the messages tag
+// overrides the default messages.
+func TestStruct(t *testing.T) {
+	type address struct {
+		City string `json:"city" validate:"required"`
+	}
+	type person struct {
+		Name      string    `json:"name" validate:"required,min=3,max=5"`
+		Nicknames []string  `json:"nicknames" validate:"max=2,unique,dive,required,max=4" messages:"max=is too long"`
+		Address   address   `json:"address" validate:"dive"`
+		Previous  []address `json:"previous" validate:"dive"`
+		Untagged  string    `json:"untagged"`
+	}
+	tests := []struct {
+		name   string
+		person person
+		want   Errors
+	}{
+		{
+			"required stops other errors",
+			person{Address: address{City: "Oslo"}},
+			Errors{"name": {Required()}},
+		},
+		{
+			"several errors for a field",
+			person{Name: "Al", Nicknames: []string{"a", "a", "b"}, Address: address{City: "Oslo"}},
+			Errors{
+				"name":      {MinLength(3)},
+				"nicknames": {MaxItems(2).WithMessage("is too long"), UniqueItems()},
+			},
+		},
+		{
+			"dive into slice elements",
+			person{Name: "Alice", Nicknames: []string{"", "Alexandra"}, Address: address{City: "Oslo"}},
+			Errors{
+				"nicknames[0]": {Required()},
+				"nicknames[1]": {MaxLength(4).WithMessage("is too long")},
+			},
+		},
+		{
+			"dive into nested structs",
+			person{Name: "Alice", Previous: []address{{City: "Oslo"}, {}}},
+			Errors{
+				"address.city":     {Required()},
+				"previous[1].city": {Required()},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			v.Struct(tt.person)
+			if !reflect.DeepEqual(v.Errors, tt.want) {
+				t.Errorf("got %v; want %v", v.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterRule(t *testing.T) {
+	RegisterRule("lowercase", func(value reflect.Value, _ string) (bool, Error) {
+		return value.String() == strings.ToLower(value.String()), Invalid("must be lower case")
+	})
+	RegisterRule("prefix", func(value reflect.Value, param string) (bool, Error) {
+		return strings.HasPrefix(value.String(), param), Pattern("^" + param)
+	})
+	t.Cleanup(func() {
+		delete(rules, "lowercase")
+		delete(rules, "prefix")
+	})
+	type slug struct {
+		Slug string `json:"slug" validate:"required,lowercase,prefix=gl-"`
+	}
+
+	v := New()
+	v.Struct(slug{Slug: "gl-moana"})
+	if !v.Valid() {
+		t.Errorf("got errors %v for a valid slug", v.Errors)
+	}
+	v = New()
+	v.Struct(slug{Slug: "Moana"})
+	want := Errors{"slug": {Invalid("must be lower case"), Pattern("^gl-")}}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got %v; want %v", v.Errors, want)
+	}
+}
+
+func TestStructPanics(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+	}{
+		{"not a struct", "moana"},
+		{"unknown rule", struct {
+			Title string `validate:"nonsense"`
+		}{}},
+		{"invalid parameter", struct {
+			Title string `validate:"max=many"`
+		}{}},
+		{"rule on the wrong kind", struct {
+			Flag bool `validate:"min=1"`
+		}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Struct() didn't panic")
+				}
+			}()
+			New().Struct(tt.value)
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package validator
 
-import "regexp"
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 // Declare a regular expression for sanity checking the format of email addresses (we'll
 // use this later in the book). If you're interested, this regular expression pattern is
@@ -11,14 +16,47 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// Define an Error type to describe a single problem with a field. The Code is a stable,
+// machine-readable identifier for the rule that failed (such as "required" or
+// "max_length") and the Params hold the rule's arguments (such as the maximum length),
+// so that clients can build their own localized messages. The Message is a default
+// English description.
+type Error struct {
+	Code    string
+	Message string
+	Params  map[string]any
+}
+
+// MarshalJSON flattens the parameters into the error object alongside the code and
+// message, so that an error is encoded like {"code":"max_length","max":500,"message":
+// "must not be more than 500 bytes long"}.
+func (e Error) MarshalJSON() ([]byte, error) {
+	obj := make(map[string]any, len(e.Params)+2)
+	for key, value := range e.Params {
+		obj[key] = value
+	}
+	obj["code"] = e.Code
+	obj["message"] = e.Message
+	return json.Marshal(obj)
+}
+
+// WithMessage returns a copy of the error with a different default message.
+func (e Error) WithMessage(message string) Error {
+	e.Message = message
+	return e
+}
+
+// Errors maps field paths to the problems with that field.
+type Errors map[string][]Error
+
 // Define a new Validator type which contains a map of validation errors.
 type Validator struct {
-	Errors map[string]string
+	Errors Errors
 }
 
 // New is a helper which creates a new Validator instance with an empty errors map.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(Errors)}
 }
 
 // Valid returns true if the errors map doesn't contain any entries.
@@ -26,14 +64,32 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-// AddError adds an error message to the map (so long as no entry already exists for
-// the given key).
-func (v *Validator) AddError(key, message string) {
-	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+// AddFieldError adds an error to the map for the given field path. A field can have
+// any number of errors, except that once a field is reported as missing there is no
+// point reporting anything else about it. Exact repeats of an existing error for the
+// field are also skipped, so that checks made in a loop don't repeat themselves.
+func (v *Validator) AddFieldError(key string, err Error) {
+	for _, existing := range v.Errors[key] {
+		if existing.Code == "required" || (existing.Code == err.Code && existing.Message == err.Message) {
+			return
+		}
+	}
+	v.Errors[key] = append(v.Errors[key], err)
+}
+
+// CheckField adds an error to the map only if a validation check is not 'ok'.
+func (v *Validator) CheckField(ok bool, key string, err Error) {
+	if !ok {
+		v.AddFieldError(key, err)
 	}
 }
 
+// AddError adds an error message to the map, with the generic code "invalid". It's a
+// shorthand for problems which don't correspond to one of the standard rules below.
+func (v *Validator) AddError(key, message string) {
+	v.AddFieldError(key, Invalid(message))
+}
+
 // Check adds an error message to the map only if a validation check is not 'ok'.
 func (v *Validator) Check(ok bool, key, message string) {
 	if !ok {
@@ -41,6 +97,89 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// Index returns the path of an element in a list field, such as genres[2].
+func Index(key string, i int) string {
+	return fmt.Sprintf("%s[%d]", key, i)
+}
+
+// Field returns the path of a field in a nested object, such as address.city.
+func Field(parent, key string) string {
+	return parent + "." + key
+}
+
+// The following functions return the errors for the standard validation rules, with
+// their default messages.
+
+func Invalid(message string) Error {
+	return Error{Code: "invalid", Message: message}
+}
+
+func Required() Error {
+	return Error{Code: "required", Message: "must be provided"}
+}
+
+func MinLength(min int) Error {
+	return Error{Code: "min_length", Message: fmt.Sprintf("must be at least %d bytes long", min), Params: map[string]any{"min": min}}
+}
+
+func MaxLength(max int) Error {
+	return Error{Code: "max_length", Message: fmt.Sprintf("must not be more than %d bytes long", max), Params: map[string]any{"max": max}}
+}
+
+func Length(length int) Error {
+	return Error{Code: "length", Message: fmt.Sprintf("must be %d bytes long", length), Params: map[string]any{"length": length}}
+}
+
+func Min(min int64) Error {
+	return Error{Code: "min", Message: fmt.Sprintf("must be at least %d", min), Params: map[string]any{"min": min}}
+}
+
+func Max(max int64) Error {
+	return Error{Code: "max", Message: fmt.Sprintf("must be a maximum of %d", max), Params: map[string]any{"max": max}}
+}
+
+func MinItems(min int) Error {
+	return Error{Code: "min_items", Message: fmt.Sprintf("must contain at least %d items", min), Params: map[string]any{"min": min}}
+}
+
+func MaxItems(max int) Error {
+	return Error{Code: "max_items", Message: fmt.Sprintf("must not contain more than %d items", max), Params: map[string]any{"max": max}}
+}
+
+func UniqueItems() Error {
+	return Error{Code: "unique", Message: "must not contain duplicate values"}
+}
+
+func OneOf[T any](permittedValues ...T) Error {
+	return Error{Code: "one_of", Message: "must be one of the permitted values", Params: map[string]any{"allowed": permittedValues}}
+}
+
+func Format(format string) Error {
+	return Error{Code: "format", Message: fmt.Sprintf("must be a valid %s", format), Params: map[string]any{"format": format}}
+}
+
+// Type returns the error for a value of the wrong JSON type, such as a string where a
+// number was expected.
+func Type(typ string) Error {
+	article := "a"
+	if strings.IndexAny(typ[:1], "aeiou") == 0 {
+		article = "an"
+	}
+	return Error{Code: "type", Message: fmt.Sprintf("must be %s %s", article, typ), Params: map[string]any{"type": typ}}
+}
+
+func Pattern(pattern string) Error {
+	return Error{Code: "pattern", Message: fmt.Sprintf("must match the pattern %s", pattern), Params: map[string]any{"pattern": pattern}}
+}
+
+func UnknownField() Error {
+	return Error{Code: "unknown_field", Message: "is not a known field"}
+}
+
+func AlreadyExists() Error {
+	return Error{Code: "already_exists", Message: "already exists"}
+}
+
 // Generic function which returns true if a specific value is in a list.
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	for i := range permittedValues {
@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code = 'admin:users';
\ No newline at end of file
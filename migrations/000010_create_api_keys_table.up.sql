@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+                                        id bigserial PRIMARY KEY,
+                                        created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+                                        user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+                                        name text NOT NULL,
+                                        prefix text NOT NULL,
+                                        hash bytea UNIQUE NOT NULL,
+                                        permissions text[] NOT NULL,
+                                        expiry timestamp(0) with time zone,
+                                        allowed_ips text[] NOT NULL DEFAULT '{}',
+                                        last_used_at timestamp(0) with time zone,
+                                        last_used_ip text
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+                                               issuer text NOT NULL,
+                                               subject text NOT NULL,
+                                               user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+                                               created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+                                               PRIMARY KEY (issuer, subject)
+);
+CREATE TABLE IF NOT EXISTS oidc_states (
+                                           hash bytea PRIMARY KEY,
+                                           nonce text NOT NULL,
+                                           code_verifier text NOT NULL,
+                                           expiry timestamp(0) with time zone NOT NULL
+);
\ No newline at end of file
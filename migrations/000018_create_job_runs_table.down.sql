@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tokens_expiry_idx;
+DROP TABLE IF EXISTS job_runs;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS job_runs (
+                                        id bigserial PRIMARY KEY,
+                                        job text NOT NULL,
+                                        instance text NOT NULL,
+                                        started_at timestamp with time zone NOT NULL,
+                                        finished_at timestamp with time zone NOT NULL,
+                                        status text NOT NULL,
+                                        result jsonb NOT NULL DEFAULT '{}',
+                                        error text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS job_runs_job_started_at_idx ON job_runs (job, started_at);
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
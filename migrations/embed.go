@@ -0,0 +1,12 @@
+// Package migrations embeds the SQL migrations for the main schema, so that they can be
+// applied by the binaries themselves (see internal/migrate). The module tables have
+// their own directory, migrations2. The two directories share one sequence of versions,
+// so a new migration takes the next version after the highest in either of them.
+package migrations
+
+import "embed"
+
+// FS holds the .up.sql and .down.sql files.
+//
+//go:embed *.sql
+var FS embed.FS
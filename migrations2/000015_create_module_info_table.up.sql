@@ -1,4 +1,4 @@
-CREATE TABLE module_info (
+CREATE TABLE IF NOT EXISTS module_info (
                              id BIGSERIAL PRIMARY KEY,
                              created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
                              updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
@@ -0,0 +1,11 @@
+// Package migrations2 embeds the SQL migrations for the module and department tables.
+// Their versions are part of the same sequence as the ones in the migrations directory,
+// so that the two directories can be applied together (see internal/migrate).
+package migrations2
+
+import "embed"
+
+// FS holds the .up.sql and .down.sql files.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,307 @@
+// Package client is a Go client for the Greenlight API. It uses the same types as the
+// API itself (so a Movie's runtime is sent and received as "102 mins", for example),
+// authenticates with a bearer token or an API key, retries requests which are rate
+// limited, and turns error responses into errors which can be checked with errors.Is.
+//
+//	c := client.New("https://api.example.com")
+//	token, err := c.CreateAuthenticationToken(ctx, "alice@example.com", "pa55word")
+//	if err != nil {
+//		return err
+//	}
+//	c.Token = token.Plaintext
+//	movie, err := c.GetMovie(ctx, 1)
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"greenlight.m4rk1sov.github.com/internal/data"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The API's resource types. These are aliases, so they have the same JSON encoding as
+// in the API.
+type (
+	Movie      = data.Movie
+	Runtime    = data.Runtime
+	Module     = data.Module_info
+	Department = data.DepartmentInfo
+	User       = data.User
+	Token      = data.Token
+	Metadata   = data.Metadata
+)
+
+// A Client makes requests to the Greenlight API. Its fields shouldn't be changed while
+// requests are being made.
+type Client struct {
+	// BaseURL is the URL of the API, such as https://api.example.com.
+	BaseURL string
+	// Token is the authentication token sent as a bearer token. If it's empty, APIKey
+	// is sent in the X-API-Key header instead (if that's set).
+	Token  string
+	APIKey string
+	// HTTPClient is used to make the requests.
+	HTTPClient *http.Client
+	// MaxRetries is the number of times that a request is retried after the API's rate
+	// limiter responds with 429 Too Many Requests. The client waits for the time in
+	// the Retry-After header before retrying, or backs off exponentially from
+	// RetryBackoff if there isn't one. Other 429 responses, such as a login lockout,
+	// aren't retried.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// MaxRetryWait is the longest that the client will wait before a retry. If the API
+	// asks for a longer wait, the error is returned straight away, so that the caller
+	// can decide what to do. Zero means that there's no limit.
+	MaxRetryWait time.Duration
+	// UserAgent is sent in the User-Agent header.
+	UserAgent string
+}
+
+// New returns a Client for the API at baseURL, with the default settings.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:   3,
+		RetryBackoff: 500 * time.Millisecond,
+		MaxRetryWait: 30 * time.Second,
+		UserAgent:    "greenlight-go-client",
+	}
+}
+
+// ListOptions are the options for the list endpoints. The zero value gets the first
+// page with the API's default page size and order.
+type ListOptions struct {
+	Page     int
+	PageSize int
+	// Sort is the field to sort by, with a leading - for descending order.
+	Sort string
+	// Fields limits the fields which are returned for each item.
+	Fields []string
+}
+
+func (o ListOptions) values() url.Values {
+	qs := url.Values{}
+	if o.Page > 0 {
+		qs.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PageSize > 0 {
+		qs.Set("page_size", strconv.Itoa(o.PageSize))
+	}
+	if o.Sort != "" {
+		qs.Set("sort", o.Sort)
+	}
+	if len(o.Fields) > 0 {
+		qs.Set("fields", strings.Join(o.Fields, ","))
+	}
+	return qs
+}
+
+// request describes a single API call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	// body is encoded as JSON, unless it's a []byte, which is sent as it is.
+	body        any
+	contentType string
+	// idempotent requests are sent with an Idempotency-Key header, so that they can be
+	// retried safely.
+	idempotent bool
+}
+
+// The do() method sends a request and decodes the JSON response into dst (if it isn't
+// nil), retrying when the API is rate limiting the client. It returns the response's
+// status code, or an *Error for an error response.
+func (c *Client) do(ctx context.Context, req request, dst any) (int, error) {
+	var body []byte
+	switch b := req.body.(type) {
+	case nil:
+	case []byte:
+		body = b
+	default:
+		var err error
+		body, err = json.Marshal(b)
+		if err != nil {
+			return 0, err
+		}
+	}
+	contentType := req.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	var idempotencyKey string
+	if req.idempotent {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	u := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		r, err := http.NewRequestWithContext(ctx, req.method, u, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		r.Header.Set("Accept", "application/json, application/problem+json")
+		if body != nil {
+			r.Header.Set("Content-Type", contentType)
+		}
+		if idempotencyKey != "" {
+			r.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		if c.UserAgent != "" {
+			r.Header.Set("User-Agent", c.UserAgent)
+		}
+		switch {
+		case c.Token != "":
+			r.Header.Set("Authorization", "Bearer "+c.Token)
+		case c.APIKey != "":
+			r.Header.Set("X-API-Key", c.APIKey)
+		}
+
+		resp, err := c.httpClient().Do(r)
+		if err != nil {
+			return 0, err
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		if resp.StatusCode >= 400 {
+			apiErr := newError(resp, respBody)
+			if !apiErr.retryable() || attempt >= c.MaxRetries {
+				return resp.StatusCode, apiErr
+			}
+			wait := apiErr.RetryAfter
+			if wait <= 0 {
+				wait = c.RetryBackoff << attempt
+			}
+			if c.MaxRetryWait > 0 && wait > c.MaxRetryWait {
+				return resp.StatusCode, apiErr
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return 0, ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		if dst != nil && len(respBody) > 0 {
+			err = json.Unmarshal(respBody, dst)
+			if err != nil {
+				return resp.StatusCode, fmt.Errorf("client: decoding %s %s response: %w", req.method, req.path, err)
+			}
+		}
+		return resp.StatusCode, nil
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// An Iterator steps through the items of a list endpoint, fetching each page as it's
+// needed:
+//
+//	it := c.Movies(ctx, client.MovieFilter{Genres: []string{"drama"}})
+//	for it.Next() {
+//		fmt.Println(it.Value().Title)
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator[T any] struct {
+	fetch    func(page int) ([]T, Metadata, error)
+	page     int
+	items    []T
+	i        int
+	value    T
+	lastPage int
+	err      error
+}
+
+func newIterator[T any](firstPage int, fetch func(page int) ([]T, Metadata, error)) *Iterator[T] {
+	if firstPage < 1 {
+		firstPage = 1
+	}
+	return &Iterator[T]{fetch: fetch, page: firstPage - 1, lastPage: -1}
+}
+
+// Next advances to the next item, returning false when there are no more items or an
+// error occurred.
+func (it *Iterator[T]) Next() bool {
+	for it.i >= len(it.items) {
+		if it.err != nil || (it.lastPage >= 0 && it.page >= it.lastPage) {
+			return false
+		}
+		it.page++
+		items, metadata, err := it.fetch(it.page)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		// An empty page has no metadata, and means that we've gone past the end.
+		it.items, it.i, it.lastPage = items, 0, metadata.LastPage
+		if len(items) == 0 {
+			return false
+		}
+	}
+	it.value = it.items[it.i]
+	it.i++
+	return true
+}
+
+// Value returns the current item.
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// The jsonPatch type is a JSON Patch (RFC 6902) document, which the update methods use
+// to check the version of the record before changing it.
+type jsonPatch []map[string]any
+
+func versionedPatch(version int32, fields map[string]any) []byte {
+	patch := jsonPatch{{"op": "test", "path": "/version", "value": version}}
+	for path, value := range fields {
+		patch = append(patch, map[string]any{"op": "add", "path": "/" + path, "value": value})
+	}
+	js, err := json.Marshal(patch)
+	if err != nil {
+		panic(err)
+	}
+	return js
+}
@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// These tests use fake servers to cover what the API itself can't easily be made to
+// do, such as sending errors in the legacy format. The tests against the API's own
+// routes are in cmd/api/client_test.go.
+
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	c := New(ts.URL)
+	c.RetryBackoff = time.Millisecond
+	return c
+}
+
+func TestAuthentication(t *testing.T) {
+	var authorization, apiKey string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		authorization, apiKey = r.Header.Get("Authorization"), r.Header.Get("X-API-Key")
+		w.Write([]byte(`{"user":{"id":1}}`))
+	})
+
+	c.APIKey = "gl_key"
+	_, err := c.CurrentUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" || apiKey != "gl_key" {
+		t.Errorf("got Authorization %q and X-API-Key %q; want only the API key", authorization, apiKey)
+	}
+
+	// The token takes precedence over the API key.
+	c.Token = "TOKEN"
+	_, err = c.CurrentUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer TOKEN" || apiKey != "" {
+		t.Errorf("got Authorization %q and X-API-Key %q; want only the token", authorization, apiKey)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		failures   int
+		maxRetries int
+		wantCalls  int32
+		wantWait   time.Duration
+		wantErr    error
+	}{
+		{"retry after header", "1", 1, 3, 2, time.Second, nil},
+		{"backoff", "", 2, 3, 3, 0, nil},
+		{"out of retries", "", 5, 2, 3, 0, ErrRateLimited},
+		{"no retries", "1", 1, 0, 1, 0, ErrRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if int(calls.Add(1)) <= tt.failures {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.Header().Set("Content-Type", "application/problem+json")
+					w.WriteHeader(http.StatusTooManyRequests)
+					w.Write([]byte(`{"type":"https://example.com/problems/rate-limit-exceeded","status":429}`))
+					return
+				}
+				w.Write([]byte(`{"movie":{"id":1}}`))
+			})
+			c.MaxRetries = tt.maxRetries
+
+			start := time.Now()
+			_, err := c.GetMovie(context.Background(), 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("got %d calls; want %d", got, tt.wantCalls)
+			}
+			if elapsed := time.Since(start); elapsed < tt.wantWait {
+				t.Errorf("retried after %s; want at least %s", elapsed, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "20")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.GetMovie(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestRetryNotRateLimited checks that a 429 response which isn't from the rate limiter,
+// or which asks for a longer wait than MaxRetryWait, is returned straight away rather
+// than blocking the caller.
+func TestRetryNotRateLimited(t *testing.T) {
+	tests := []struct {
+		name       string
+		problem    string
+		retryAfter time.Duration
+		wantErr    error
+	}{
+		{"login lockout", "too-many-login-attempts", 45 * time.Minute, ErrLoginLockedOut},
+		{"longer than the maximum wait", "rate-limit-exceeded", 31 * time.Second, ErrRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.Header().Set("Retry-After", strconv.Itoa(int(tt.retryAfter.Seconds())))
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprintf(w, `{"type":"https://example.com/problems/%s","status":429}`, tt.problem)
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := c.CreateAuthenticationToken(ctx, "alice@example.com", "pa55word1234")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.RetryAfter != tt.retryAfter {
+				t.Errorf("got error %#v; want one with the Retry-After time", err)
+			}
+			if got := calls.Load(); got != 1 {
+				t.Errorf("got %d calls; want 1", got)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantIs     []error
+		wantDetail string
+		wantFields map[string]string
+	}{
+		{
+			name:   "problem details",
+			status: http.StatusUnprocessableEntity,
+			body: `{"type":"https://example.com/problems/failed-validation","title":"Failed validation","status":422,
+				"detail":"the request contains invalid fields","request_id":"abc",
+				"errors":{"title":[{"code":"required","message":"must be provided"}],"year":[{"code":"max","message":"must be a maximum of 2024","max":2024}]}}`,
+			wantIs:     []error{ErrFailedValidation},
+			wantDetail: "the request contains invalid fields",
+			wantFields: map[string]string{"title": "must be provided", "year": "must be a maximum of 2024"},
+		},
+		{
+			name:       "edit conflict",
+			status:     http.StatusConflict,
+			body:       `{"type":"https://example.com/problems/edit-conflict","status":409,"detail":"unable to update the record due to an edit conflict, please try again"}`,
+			wantIs:     []error{ErrEditConflict},
+			wantDetail: "unable to update the record due to an edit conflict, please try again",
+		},
+		{
+			name:       "legacy message",
+			status:     http.StatusNotFound,
+			body:       `{"error":"the requested resource could not be found"}`,
+			wantIs:     []error{ErrNotFound},
+			wantDetail: "the requested resource could not be found",
+		},
+		{
+			name:       "legacy fields",
+			status:     http.StatusUnprocessableEntity,
+			body:       `{"error":{"email":"must be a valid email address","password":"must be provided"}}`,
+			wantIs:     []error{ErrFailedValidation},
+			wantFields: map[string]string{"email": "must be a valid email address", "password": "must be provided"},
+		},
+		{
+			name:       "not JSON",
+			status:     http.StatusBadGateway,
+			body:       "Bad Gateway\n",
+			wantIs:     []error{ErrServer},
+			wantDetail: "Bad Gateway",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+			_, err := c.GetMovie(context.Background(), 1)
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got error %v; want an *Error", err)
+			}
+			for _, target := range tt.wantIs {
+				if !errors.Is(err, target) {
+					t.Errorf("error doesn't match %v", target)
+				}
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("got status %d; want %d", apiErr.StatusCode, tt.status)
+			}
+			if apiErr.Detail != tt.wantDetail {
+				t.Errorf("got detail %q; want %q", apiErr.Detail, tt.wantDetail)
+			}
+			if len(apiErr.Fields) != len(tt.wantFields) {
+				t.Errorf("got fields %v; want %v", apiErr.Fields, tt.wantFields)
+			}
+			for key, message := range tt.wantFields {
+				if errs := apiErr.Fields[key]; len(errs) != 1 || errs[0].Message != message {
+					t.Errorf("got %s errors %v; want %q", key, errs, message)
+				}
+			}
+		})
+	}
+}
+
+func TestIterator(t *testing.T) {
+	const total, pageSize = 7, 3
+	var pages []int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pages = append(pages, page)
+		fmt.Fprint(w, `{"movies":[`)
+		for id := (page-1)*pageSize + 1; id <= page*pageSize && id <= total; id++ {
+			if id > (page-1)*pageSize+1 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"id":%d}`, id)
+		}
+		fmt.Fprintf(w, `],"metadata":{"current_page":%d,"page_size":%d,"first_page":1,"last_page":3,"total_records":%d}}`, page, pageSize, total)
+	})
+
+	it := c.Movies(context.Background(), MovieFilter{ListOptions: ListOptions{PageSize: pageSize}})
+	var ids []int64
+	for it.Next() {
+		ids = append(ids, it.Value().ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != total || ids[0] != 1 || ids[total-1] != total {
+		t.Errorf("got IDs %v; want 1 to %d", ids, total)
+	}
+	// It stops at the last page, rather than fetching an empty page after it.
+	if fmt.Sprint(pages) != "[1 2 3]" {
+		t.Errorf("fetched pages %v; want [1 2 3]", pages)
+	}
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The errors which an *Error matches with errors.Is, depending on its status code or
+// problem type. For example, errors.Is(err, client.ErrEditConflict) reports whether an
+// update failed because the record was changed by someone else first.
+var (
+	ErrBadRequest         = errors.New("client: bad request")
+	ErrUnauthorized       = errors.New("client: authentication required")
+	ErrForbidden          = errors.New("client: not permitted")
+	ErrNotFound           = errors.New("client: record not found")
+	ErrEditConflict       = errors.New("client: edit conflict")
+	ErrFailedValidation   = errors.New("client: failed validation")
+	ErrRateLimited        = errors.New("client: rate limited")
+	ErrLoginLockedOut     = errors.New("client: too many failed login attempts")
+	ErrServer             = errors.New("client: server error")
+	ErrTwoFactorRequired  = errors.New("client: two-factor authentication required")
+	ErrInvalidCredentials = errors.New("client: invalid credentials")
+)
+
+// A FieldError is a single problem with a field of a request which failed validation.
+type FieldError struct {
+	Code    string
+	Message string
+	// Params holds the parameters of the rule which failed, such as the maximum
+	// length for a max_length error.
+	Params map[string]any
+}
+
+// UnmarshalJSON reads a field error, where the parameters are members of the error
+// object alongside the code and message.
+func (e *FieldError) UnmarshalJSON(js []byte) error {
+	var obj map[string]any
+	err := json.Unmarshal(js, &obj)
+	if err != nil {
+		return err
+	}
+	e.Code, _ = obj["code"].(string)
+	e.Message, _ = obj["message"].(string)
+	delete(obj, "code")
+	delete(obj, "message")
+	if len(obj) > 0 {
+		e.Params = obj
+	}
+	return nil
+}
+
+// An Error is an error response from the API, as RFC 7807 problem details.
+type Error struct {
+	StatusCode int
+	Type       string
+	Title      string
+	Detail     string
+	Instance   string
+	RequestID  string
+	// Fields holds the problems with each field, keyed by field path, for a request
+	// which failed validation.
+	Fields map[string][]FieldError
+	// RetryAfter is the time from the Retry-After header, if there was one.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if len(e.Fields) > 0 {
+		var fields []string
+		for key, errs := range e.Fields {
+			for _, fieldErr := range errs {
+				fields = append(fields, fmt.Sprintf("%s %s", key, fieldErr.Message))
+			}
+		}
+		msg += ": " + strings.Join(fields, "; ")
+	}
+	return fmt.Sprintf("greenlight: %d %s", e.StatusCode, msg)
+}
+
+// Is reports whether the error matches one of the package's error values.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrEditConflict:
+		// A failed test operation in a JSON patch means that the version had changed.
+		return e.problemType() == "edit-conflict" || e.problemType() == "patch-test-failed"
+	case ErrInvalidCredentials:
+		return e.problemType() == "invalid-credentials"
+	case ErrLoginLockedOut:
+		return e.problemType() == "too-many-login-attempts"
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrFailedValidation:
+		return e.StatusCode == http.StatusUnprocessableEntity
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// The retryable() method reports whether the request can be retried after waiting.
+// Only the rate limiter's responses are, as a login lockout lasts far longer and
+// retrying just extends it. Errors in the legacy format have no type, and are
+// retried as before (subject to MaxRetryWait).
+func (e *Error) retryable() bool {
+	if e.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	return e.Type == "" || e.problemType() == "rate-limit-exceeded"
+}
+
+// The problemType() method returns the last part of the problem type URI, such as
+// "edit-conflict".
+func (e *Error) problemType() string {
+	return e.Type[strings.LastIndex(e.Type, "/")+1:]
+}
+
+// The newError() function builds an *Error from an error response. The API sends
+// problem details by default, but can be configured to send errors in the legacy
+// {"error": ...} format, so both are understood.
+func newError(resp *http.Response, body []byte) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	var problem struct {
+		Type      string                  `json:"type"`
+		Title     string                  `json:"title"`
+		Detail    string                  `json:"detail"`
+		Instance  string                  `json:"instance"`
+		RequestID string                  `json:"request_id"`
+		Errors    map[string][]FieldError `json:"errors"`
+		Legacy    json.RawMessage         `json:"error"`
+	}
+	if json.Unmarshal(body, &problem) != nil {
+		e.Detail = strings.TrimSpace(string(body))
+		return e
+	}
+	e.Type, e.Title, e.Detail, e.Instance, e.RequestID = problem.Type, problem.Title, problem.Detail, problem.Instance, problem.RequestID
+	e.Fields = problem.Errors
+	if problem.Legacy != nil {
+		// The legacy format has either a message, or a message for each field.
+		var fields map[string]string
+		if json.Unmarshal(problem.Legacy, &fields) == nil {
+			e.Fields = make(map[string][]FieldError, len(fields))
+			for key, message := range fields {
+				e.Fields[key] = []FieldError{{Message: message}}
+			}
+		} else {
+			json.Unmarshal(problem.Legacy, &e.Detail)
+		}
+	}
+	return e
+}
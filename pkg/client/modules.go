@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ModuleFilter holds the filters and options for listing modules.
+type ModuleFilter struct {
+	// ModuleName is a full-text search on the module name.
+	ModuleName string
+	// ExamType limits the list to modules with this exam type.
+	ExamType string
+	ListOptions
+}
+
+func (f ModuleFilter) values() url.Values {
+	qs := f.ListOptions.values()
+	if f.ModuleName != "" {
+		qs.Set("moduleName", f.ModuleName)
+	}
+	if f.ExamType != "" {
+		qs.Set("examType", f.ExamType)
+	}
+	return qs
+}
+
+// ListModules returns a page of modules, and the pagination metadata.
+func (c *Client) ListModules(ctx context.Context, filter ModuleFilter) ([]*Module, Metadata, error) {
+	var resp struct {
+		Modules  []*Module `json:"module_info"`
+		Metadata Metadata  `json:"metadata"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/modules", query: filter.values()}, &resp)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return resp.Modules, resp.Metadata, nil
+}
+
+// Modules returns an Iterator over all the modules which match the filter, starting
+// from filter.Page.
+func (c *Client) Modules(ctx context.Context, filter ModuleFilter) *Iterator[*Module] {
+	return newIterator(filter.Page, func(page int) ([]*Module, Metadata, error) {
+		filter.Page = page
+		return c.ListModules(ctx, filter)
+	})
+}
+
+// GetModule returns the module with the given ID.
+func (c *Client) GetModule(ctx context.Context, id int64) (*Module, error) {
+	var resp struct {
+		Module *Module `json:"module_info"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/v1/modules/%d", id)}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Module, nil
+}
+
+// CreateModule creates a module from the name, duration and exam type of module, and
+// returns the new record. It's safe to retry.
+func (c *Client) CreateModule(ctx context.Context, module *Module) (*Module, error) {
+	input := struct {
+		ModuleName     string  `json:"moduleName"`
+		ModuleDuration Runtime `json:"moduleDuration"`
+		ExamType       string  `json:"examType"`
+	}{module.ModuleName, module.ModuleDuration, module.ExamType}
+	var resp struct {
+		Module *Module `json:"modules"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/modules", body: input, idempotent: true}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Module, nil
+}
+
+// UpdateModule saves the name, duration and exam type of module, and returns the
+// updated record. If the module has been changed since it was fetched, the error
+// matches ErrEditConflict.
+func (c *Client) UpdateModule(ctx context.Context, module *Module) (*Module, error) {
+	patch := versionedPatch(module.Version, map[string]any{
+		"moduleName":     module.ModuleName,
+		"moduleDuration": module.ModuleDuration,
+		"examType":       module.ExamType,
+	})
+	var resp struct {
+		Module *Module `json:"module_info"`
+	}
+	_, err := c.do(ctx, request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/v1/modules/%d", module.ID),
+		body:        patch,
+		contentType: "application/json-patch+json",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Module, nil
+}
+
+// DeleteModule deletes the module with the given ID.
+func (c *Client) DeleteModule(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/v1/modules/%d", id)}, nil)
+	return err
+}
+
+// GetDepartment returns the department with the given ID.
+func (c *Client) GetDepartment(ctx context.Context, id int64) (*Department, error) {
+	var resp struct {
+		Department *Department `json:"departmentInfo"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/v1/departments/%d", id)}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Department, nil
+}
+
+// CreateDepartment creates a department and returns the new record. It's safe to
+// retry.
+func (c *Client) CreateDepartment(ctx context.Context, department *Department) (*Department, error) {
+	input := struct {
+		DepartmentName     string `json:"departmentName"`
+		StaffQuantity      int64  `json:"staffQuantity"`
+		DepartmentDirector string `json:"departmentDirector"`
+		ModuleInfo         int64  `json:"module_Info"`
+	}{department.DepartmentName, department.StaffQuantity, department.DepartmentDirector, department.Module_Info}
+	var resp struct {
+		Department *Department `json:"departments"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/departments", body: input, idempotent: true}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Department, nil
+}
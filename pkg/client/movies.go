@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MovieFilter holds the filters and options for listing movies.
+type MovieFilter struct {
+	// Title is a full-text search on the movie title.
+	Title string
+	// Genres lists genres which the movies must all have.
+	Genres []string
+	ListOptions
+}
+
+func (f MovieFilter) values() url.Values {
+	qs := f.ListOptions.values()
+	if f.Title != "" {
+		qs.Set("title", f.Title)
+	}
+	if len(f.Genres) > 0 {
+		qs.Set("genres", strings.Join(f.Genres, ","))
+	}
+	return qs
+}
+
+// ListMovies returns a page of movies, and the pagination metadata.
+func (c *Client) ListMovies(ctx context.Context, filter MovieFilter) ([]*Movie, Metadata, error) {
+	var resp struct {
+		Movies   []*Movie `json:"movies"`
+		Metadata Metadata `json:"metadata"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/movies", query: filter.values()}, &resp)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	return resp.Movies, resp.Metadata, nil
+}
+
+// Movies returns an Iterator over all the movies which match the filter, starting from
+// filter.Page.
+func (c *Client) Movies(ctx context.Context, filter MovieFilter) *Iterator[*Movie] {
+	return newIterator(filter.Page, func(page int) ([]*Movie, Metadata, error) {
+		filter.Page = page
+		return c.ListMovies(ctx, filter)
+	})
+}
+
+// GetMovie returns the movie with the given ID.
+func (c *Client) GetMovie(ctx context.Context, id int64) (*Movie, error) {
+	var resp struct {
+		Movie *Movie `json:"movie"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: fmt.Sprintf("/v1/movies/%d", id)}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Movie, nil
+}
+
+// CreateMovie creates a movie from the title, year, runtime and genres of movie, and
+// returns the new record. It's safe to retry.
+func (c *Client) CreateMovie(ctx context.Context, movie *Movie) (*Movie, error) {
+	input := struct {
+		Title   string   `json:"title"`
+		Year    int32    `json:"year"`
+		Runtime Runtime  `json:"runtime"`
+		Genres  []string `json:"genres"`
+	}{movie.Title, movie.Year, movie.Runtime, movie.Genres}
+	var resp struct {
+		Movie *Movie `json:"movie"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/movies", body: input, idempotent: true}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Movie, nil
+}
+
+// UpdateMovie saves the title, year, runtime and genres of movie, and returns the
+// updated record. If the movie has been changed since it was fetched (so that its
+// version doesn't match), the error matches ErrEditConflict.
+func (c *Client) UpdateMovie(ctx context.Context, movie *Movie) (*Movie, error) {
+	patch := versionedPatch(movie.Version, map[string]any{
+		"title":   movie.Title,
+		"year":    movie.Year,
+		"runtime": movie.Runtime,
+		"genres":  movie.Genres,
+	})
+	var resp struct {
+		Movie *Movie `json:"movie"`
+	}
+	_, err := c.do(ctx, request{
+		method:      http.MethodPatch,
+		path:        fmt.Sprintf("/v1/movies/%d", movie.ID),
+		body:        patch,
+		contentType: "application/json-patch+json",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Movie, nil
+}
+
+// DeleteMovie deletes the movie with the given ID.
+func (c *Client) DeleteMovie(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: fmt.Sprintf("/v1/movies/%d", id)}, nil)
+	return err
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// RegisterUser registers a new user account. The API emails the user a token, which
+// activates the account with ActivateUser.
+func (c *Client) RegisterUser(ctx context.Context, name, email, password string) (*User, error) {
+	input := map[string]string{"name": name, "email": email, "password": password}
+	var resp struct {
+		User *User `json:"user"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/users", body: input}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.User, nil
+}
+
+// ActivateUser activates a user account with the token from the welcome email.
+func (c *Client) ActivateUser(ctx context.Context, token string) (*User, error) {
+	var resp struct {
+		User *User `json:"user"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/v1/users/activated", body: map[string]string{"token": token}}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.User, nil
+}
+
+// RequestPasswordReset asks the API to email a password-reset token to an address, if
+// it has an account. The token works with ResetPassword, or in place of the current
+// password with ChangePasswordWithToken.
+func (c *Client) RequestPasswordReset(ctx context.Context, email string) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/tokens/password-reset", body: map[string]string{"email": email}}, nil)
+	return err
+}
+
+// ResetPassword sets a new password for a user account, with a password-reset token.
+func (c *Client) ResetPassword(ctx context.Context, token, password string) error {
+	input := map[string]string{"token": token, "password": password}
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/v1/users/password", body: input}, nil)
+	return err
+}
+
+// CurrentUser returns the account of the authenticated user.
+func (c *Client) CurrentUser(ctx context.Context) (*User, error) {
+	var resp struct {
+		User *User `json:"user"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/v1/users/me"}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.User, nil
+}
+
+// UpdateCurrentUser changes the name on the authenticated user's account.
+func (c *Client) UpdateCurrentUser(ctx context.Context, name string) (*User, error) {
+	var resp struct {
+		User *User `json:"user"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodPatch, path: "/v1/users/me", body: map[string]string{"name": name}}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.User, nil
+}
+
+// ChangePassword changes the authenticated user's password.
+func (c *Client) ChangePassword(ctx context.Context, currentPassword, password string) error {
+	input := map[string]string{"current_password": currentPassword, "password": password}
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/v1/users/me/password", body: input}, nil)
+	return err
+}
+
+// ChangePasswordWithToken changes the authenticated user's password, using a token
+// from RequestPasswordReset instead of the current password. This is how users who
+// log in with single sign-on, and so don't know their password, set one.
+func (c *Client) ChangePasswordWithToken(ctx context.Context, token, password string) error {
+	input := map[string]string{"token": token, "password": password}
+	_, err := c.do(ctx, request{method: http.MethodPut, path: "/v1/users/me/password", body: input}, nil)
+	return err
+}
+
+// A TwoFactorRequiredError is returned by CreateAuthenticationToken when the account
+// uses two-factor authentication. The login is completed by passing the pending token
+// and a code to CreateTwoFactorAuthenticationToken. It matches ErrTwoFactorRequired.
+type TwoFactorRequiredError struct {
+	PendingToken *Token
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return ErrTwoFactorRequired.Error()
+}
+
+func (e *TwoFactorRequiredError) Is(target error) bool {
+	return target == ErrTwoFactorRequired
+}
+
+// CreateAuthenticationToken logs in with an email address and password, and returns
+// an authentication token, which can be used by setting the Client's Token field. If
+// the account uses two-factor authentication, the error is a *TwoFactorRequiredError.
+func (c *Client) CreateAuthenticationToken(ctx context.Context, email, password string) (*Token, error) {
+	input := map[string]string{"email": email, "password": password}
+	var resp struct {
+		AuthenticationToken *Token `json:"authentication_token"`
+		PendingToken        *Token `json:"2fa_pending_token"`
+	}
+	status, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/tokens/authentication", body: input}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusAccepted {
+		return nil, &TwoFactorRequiredError{PendingToken: resp.PendingToken}
+	}
+	if resp.AuthenticationToken == nil {
+		return nil, errors.New("client: response has no authentication token")
+	}
+	return resp.AuthenticationToken, nil
+}
+
+// CreateTwoFactorAuthenticationToken completes a login which needs a two-factor
+// authentication code, with the pending token from CreateAuthenticationToken.
+func (c *Client) CreateTwoFactorAuthenticationToken(ctx context.Context, pendingToken, code string) (*Token, error) {
+	input := map[string]string{"token": pendingToken, "code": code}
+	var resp struct {
+		AuthenticationToken *Token `json:"authentication_token"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/v1/tokens/2fa", body: input}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.AuthenticationToken, nil
+}